@@ -0,0 +1,129 @@
+/*
+Copyright 2022 zoomoid.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ApprovalPolicySpec defines the requirements a kubeconfig's CSR needs to meet to be approved automatically
+type ApprovalPolicySpec struct {
+	// Usernames are glob patterns of the usernames the policy applies to, e.g., "robot-*". An empty list matches no
+	// usernames, use "*" to apply the policy to all usernames
+	// +optional
+	Usernames []string `json:"usernames,omitempty"`
+
+	// AllowedOrganizations are glob patterns of the organizations, i.e., groups, that may be requested.
+	// An empty list allows all organizations that are not forbidden
+	// +optional
+	AllowedOrganizations []string `json:"allowedOrganizations,omitempty"`
+
+	// ForbiddenOrganizations are glob patterns of organizations that must not be requested.
+	// system:masters is always forbidden, since it bypasses all authorization
+	// +optional
+	ForbiddenOrganizations []string `json:"forbiddenOrganizations,omitempty"`
+
+	// AllowedKeyAlgorithms are the public key algorithms that may be used. An empty list allows all algorithms
+	// +optional
+	AllowedKeyAlgorithms []KeyAlgorithm `json:"allowedKeyAlgorithms,omitempty"`
+
+	// MinRSAKeySize is the minimum size of RSA keys in bits
+	// +optional
+	MinRSAKeySize int32 `json:"minRSAKeySize,omitempty"`
+
+	// AllowedECDSACurves are the elliptic curves that ECDSA keys may use. An empty list allows all curves
+	// +optional
+	AllowedECDSACurves []ECDSACurve `json:"allowedECDSACurves,omitempty"`
+
+	// MaxDuration is the longest certificate lifetime that may be requested. If set, CSRs need to request a lifetime
+	// +optional
+	MaxDuration *metav1.Duration `json:"maxDuration,omitempty"`
+
+	// AllowedRoleRefs are the roles that the kubeconfig's roleRef and bindings, and the bindings of the KubeconfigGroups of
+	// its groups, may reference. An empty list allows all roles
+	// +optional
+	AllowedRoleRefs []RoleRefPattern `json:"allowedRoleRefs,omitempty"`
+
+	// OnViolation determines what happens to CSRs that violate the policy. Deny denies the CSR, Pending leaves it
+	// for manual approval
+	// +kubebuilder:default=Pending
+	// +optional
+	OnViolation ViolationAction `json:"onViolation,omitempty"`
+}
+
+// RoleRefPattern matches role references
+type RoleRefPattern struct {
+	// Kind of the role, either Role or ClusterRole
+	// +kubebuilder:validation:Enum=Role;ClusterRole
+	Kind string `json:"kind"`
+
+	// Name is a glob pattern of the role's name
+	Name string `json:"name"`
+}
+
+// +kubebuilder:validation:Enum=RSA;ECDSA;Ed25519
+type KeyAlgorithm string
+
+const (
+	KeyAlgorithmRSA     KeyAlgorithm = "RSA"
+	KeyAlgorithmECDSA   KeyAlgorithm = "ECDSA"
+	KeyAlgorithmEd25519 KeyAlgorithm = "Ed25519"
+)
+
+// +kubebuilder:validation:Enum=P-256;P-384;P-521
+type ECDSACurve string
+
+const (
+	ECDSACurveP256 ECDSACurve = "P-256"
+	ECDSACurveP384 ECDSACurve = "P-384"
+	ECDSACurveP521 ECDSACurve = "P-521"
+)
+
+// +kubebuilder:validation:Enum=Deny;Pending
+type ViolationAction string
+
+const (
+	ViolationActionDeny    ViolationAction = "Deny"
+	ViolationActionPending ViolationAction = "Pending"
+)
+
+// ApprovalPolicy is the Schema for the approvalpolicies API. If any ApprovalPolicy exists, the CSRs of kubeconfigs
+// are approved automatically if and only if all policies matching their username are satisfied
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster
+// +kubebuilder:printcolumn:name="On Violation",type=string,JSONPath=`.spec.onViolation`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+type ApprovalPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec ApprovalPolicySpec `json:"spec,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// ApprovalPolicyList contains a list of ApprovalPolicy
+type ApprovalPolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ApprovalPolicy `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ApprovalPolicy{}, &ApprovalPolicyList{})
+}
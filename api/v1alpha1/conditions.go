@@ -21,10 +21,17 @@ const (
 	ConditionTypeCSRCreated string = "CSRCreated"
 	// ConditionTypeCSRApproved indicates the status of the approval of a CSR
 	ConditionTypeCSRApproved string = "CSRApproved"
+	// ConditionTypeApproved indicates if issuing the credentials of a kubeconfig in the ServiceAccountToken or OIDC
+	// auth mode, which request no CSR, was approved for the kubeconfig's current spec
+	ConditionTypeApproved string = "Approved"
 	// ConditionTypeUserSecretCreated indicates the creation status of the user secret object
 	ConditionTypeUserSecretCreated string = "UserSecretCreated"
 	// ConditionTypeUserSecretFinished indicates if the user secret has all the required data fields
 	ConditionTypeUserSecretFinished string = "UserSecretFinished"
 	// ConditionTypeKubeconfigFinished indicates if the kubeconfig is complete
 	ConditionTypeKubeconfigFinished string = "KubeconfigFinished"
+	// ConditionTypeRBACSynced indicates if the role bindings of the kubeconfig's user match the spec
+	ConditionTypeRBACSynced string = "RBACSynced"
+	// ConditionTypeRevoked indicates that the kubeconfig's credentials were revoked
+	ConditionTypeRevoked string = "Revoked"
 )
@@ -0,0 +1,89 @@
+/*
+Copyright 2022 zoomoid.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	. "github.com/onsi/gomega"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// These are the fixtures shared by all specs of the webhook suite. All objects are cluster-scoped, so every spec
+// uses unique names instead of cleaning up after itself
+
+var names int
+
+// uniqueName returns a name that no other spec uses
+func uniqueName(prefix string) string {
+	names++
+	return fmt.Sprintf("%s-%d", prefix, names)
+}
+
+// newTestKubeconfig returns a kubeconfig in the ClientCertificate auth mode that passes validation. Its name and
+// username are unique
+func newTestKubeconfig(prefix string) *Kubeconfig {
+	name := uniqueName(prefix)
+	return &Kubeconfig{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: KubeconfigSpec{
+			Username: name,
+			AuthMode: AuthModeClientCertificate,
+			Cluster:  &Cluster{Name: "kubernetes", Server: "https://127.0.0.1:6443"},
+			CSR:      &CertificateSigningRequest{SignatureAlgorithm: SHA256WithRSA},
+			RoleRef:  &rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: "view"},
+		},
+	}
+}
+
+// clientAs returns a client that impersonates the user, e.g., to approve a kubeconfig created by the suite's client
+func clientAs(username string) client.Client {
+	impersonated := rest.CopyConfig(cfg)
+	impersonated.Impersonate = rest.ImpersonationConfig{UserName: username, Groups: []string{"system:masters"}}
+	c, err := client.New(impersonated, client.Options{Scheme: k8sClient.Scheme()})
+	ExpectWithOffset(1, err).NotTo(HaveOccurred())
+	return c
+}
+
+// errorFields returns the sorted field paths of the causes of an error returned by the kube-apiserver
+func errorFields(err error) []string {
+	fields := []string{}
+	var status apierrors.APIStatus
+	if errors.As(err, &status) && status.Status().Details != nil {
+		for _, cause := range status.Status().Details.Causes {
+			fields = append(fields, cause.Field)
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// expectRejected asserts that the webhook rejected a request because of the given fields
+func expectRejected(err error, fields ...string) {
+	ExpectWithOffset(1, err).To(HaveOccurred())
+	ExpectWithOffset(1, apierrors.IsInvalid(err) || apierrors.IsForbidden(err)).To(BeTrue(), "unexpected error %v", err)
+	for _, field := range fields {
+		ExpectWithOffset(1, err.Error()).To(ContainSubstring(field))
+	}
+}
@@ -17,6 +17,11 @@ limitations under the License.
 package v1alpha1
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+
+	certificatesv1 "k8s.io/api/certificates/v1"
 	rbacv1 "k8s.io/api/rbac/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
@@ -24,11 +29,31 @@ import (
 // KubeconfigSpec defines the desired state of Kubeconfig
 type KubeconfigSpec struct {
 	// Username is the name associated with the future owner of the kubeconfig. The certificate is bound to this name as Common Name,
-	// and the name is used for subresources as well
+	// and the name is used for subresources as well. In the ServiceAccountToken auth mode, the username only names the user in the kubeconfig
 	// +kubebuilder:validation:Required
 	Username string `json:"username,omitempty"`
 
-	// When wanting to use an existing CSR, add a reference to the secret containing private key and csr here
+	// AuthMode determines whether the kubeconfig's user authenticates with a client certificate issued through a CSR,
+	// with a bound token of a ServiceAccount created for the kubeconfig, e.g., for clients behind TLS-terminating proxies,
+	// or with OIDC ID tokens obtained by an exec credential plugin, e.g., for humans.
+	// this field is immutable after creation
+	// +kubebuilder:default=ClientCertificate
+	// +optional
+	AuthMode AuthMode `json:"authMode,omitempty"`
+
+	// ServiceAccountToken contains the parameters of the tokens requested in the ServiceAccountToken auth mode
+	// +optional
+	ServiceAccountToken *ServiceAccountToken `json:"serviceAccountToken,omitempty"`
+
+	// OIDC contains the parameters of the exec credential plugin used in the OIDC auth mode
+	// +optional
+	OIDC *OIDC `json:"oidc,omitempty"`
+
+	// When wanting to use an existing CSR, add a reference to the secret containing the PEM-encoded CSR
+	// in the key "tls.csr". The CSR's common name must match the username, and its organizations must match
+	// .spec.csr.additionalFields.organization and .spec.groups. The CSR is submitted as-is,
+	// the operator never generates or reads a private key for it, and the resulting kubeconfig contains no
+	// client key, which needs to be added by the user locally.
 	// this field is immutable after creation
 	// +optional
 	ExistingCSR *SecretRef `json:"existingCSR,omitempty"`
@@ -38,7 +63,9 @@ type KubeconfigSpec struct {
 	// +optional
 	AutoApproveCSR bool `json:"automaticApproval,omitempty"`
 
-	// CSR contains the parameters for generating the private key and CSR for the kube-api-server to sign
+	// CSR contains the parameters for generating the private key and CSR for the kube-api-server to sign.
+	// Changing it rotates the credentials: a new key and CSR are requested, and the previous credentials remain
+	// in the user secret until the new certificate is issued
 	// +optional
 	// +kubebuilder:default={signatureAlgorithm: SHA256WithRSA}
 	CSR *CertificateSigningRequest `json:"csr,omitempty"`
@@ -50,6 +77,94 @@ type KubeconfigSpec struct {
 	// RoleRef contains the role references that the created cluster role binding links against
 	// +optional
 	RoleRef *rbacv1.RoleRef `json:"roleRef,omitempty"`
+
+	// Bindings are additional role bindings for the kubeconfig's user. Bindings with a namespace create RoleBindings
+	// in that namespace and may reference Roles or ClusterRoles, bindings without a namespace create ClusterRoleBindings
+	// and must reference ClusterRoles. If bindings or groups are set, roleRef is no longer defaulted to cluster-admin
+	// +optional
+	Bindings []KubeconfigBinding `json:"bindings,omitempty"`
+
+	// Groups are added to the organizations of the certificate's subject, which the kube-apiserver maps to the user's groups.
+	// Role bindings for groups are managed with KubeconfigGroups. For existing CSRs, the CSR's organizations must match the groups.
+	// In the OIDC auth mode, the groups are the user's groups from the ID token, which the kubeconfig's roles are bound to as well.
+	// this field is immutable after creation
+	// +optional
+	Groups []string `json:"groups,omitempty"`
+
+	// Revoked removes all operator-managed role bindings of the username and records the issued certificate
+	// in the cluster-wide RevocationList, such that the username cannot be used by new kubeconfigs until the
+	// certificate expired. Revocation cannot be undone
+	// +optional
+	Revoked bool `json:"revoked,omitempty"`
+
+	// Target configures a secret that the final kubeconfig is delivered to in addition to the user secret,
+	// e.g., in a tenant's namespace, such that users can be granted access to their own credentials
+	// +optional
+	Target *KubeconfigTarget `json:"target,omitempty"`
+
+	// RotationToken is an arbitrary value whose change makes the controller rotate the credentials immediately, e.g.,
+	// after the private key was compromised. The previous credentials remain in the user secret until the new
+	// certificate is issued. Cannot be used with existingCSR
+	// +optional
+	RotationToken string `json:"rotationToken,omitempty"`
+
+	// RenewBefore is the duration before the client certificate's expiry at which the controller
+	// starts renewing the certificate. If unset, or if it exceeds the certificate's lifetime,
+	// the certificate is renewed after two thirds of its lifetime have passed
+	// +optional
+	RenewBefore *metav1.Duration `json:"renewBefore,omitempty"`
+}
+
+// AuthMode is the way the kubeconfig's user authenticates against the kube-apiserver
+// +kubebuilder:validation:Enum=ClientCertificate;ServiceAccountToken;OIDC
+type AuthMode string
+
+const (
+	// AuthModeClientCertificate authenticates with a client certificate issued through a CSR
+	AuthModeClientCertificate AuthMode = "ClientCertificate"
+	// AuthModeServiceAccountToken authenticates with a bound token of a ServiceAccount, requested through the TokenRequest API
+	AuthModeServiceAccountToken AuthMode = "ServiceAccountToken"
+	// AuthModeOIDC authenticates with OIDC ID tokens, which an exec credential plugin obtains from the identity provider
+	AuthModeOIDC AuthMode = "OIDC"
+)
+
+// ServiceAccountToken contains the parameters of tokens requested through the TokenRequest API
+type ServiceAccountToken struct {
+	// Audiences are the intended audiences of the token. If unset, the token is valid for the kube-apiserver's audiences
+	// +optional
+	Audiences []string `json:"audiences,omitempty"`
+
+	// Duration is the requested lifetime of the token. The kube-apiserver may grant a different lifetime, which the
+	// controller uses for scheduling the refresh of the token
+	// +kubebuilder:default="24h"
+	// +optional
+	Duration *metav1.Duration `json:"duration,omitempty"`
+}
+
+// OIDC contains the parameters of the identity provider that issues ID tokens for the kubeconfig's user, and how the
+// kube-apiserver maps the tokens' claims to usernames and groups
+type OIDC struct {
+	// IssuerURL is the URL of the OIDC identity provider, which must use https
+	// +kubebuilder:validation:Required
+	IssuerURL string `json:"issuerURL"`
+
+	// ClientID is the ID of the OIDC client that ID tokens are issued for
+	// +kubebuilder:validation:Required
+	ClientID string `json:"clientID"`
+
+	// ExtraScopes are requested from the identity provider in addition to the openid scope, e.g., email or groups
+	// +optional
+	ExtraScopes []string `json:"extraScopes,omitempty"`
+
+	// UsernamePrefix is the prefix the kube-apiserver adds to usernames from ID tokens, i.e., its --oidc-username-prefix.
+	// The roles of the kubeconfig are bound to the prefixed username
+	// +optional
+	UsernamePrefix string `json:"usernamePrefix,omitempty"`
+
+	// GroupsPrefix is the prefix the kube-apiserver adds to groups from ID tokens, i.e., its --oidc-groups-prefix.
+	// The roles of the kubeconfig are bound to each of .spec.groups with this prefix
+	// +optional
+	GroupsPrefix string `json:"groupsPrefix,omitempty"`
 }
 
 type SecretRef struct {
@@ -57,8 +172,64 @@ type SecretRef struct {
 	Namespace string `json:"namespace"`
 }
 
+// SecretKeyRef references a key in a secret
+type SecretKeyRef struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+
+	// Key is the key in the secret's data
+	// +kubebuilder:default=passphrase
+	// +optional
+	Key string `json:"key,omitempty"`
+}
+
+// KubeconfigTarget describes the secret that the final kubeconfig is delivered to
+type KubeconfigTarget struct {
+	// Namespace is the namespace of the target secret
+	Namespace string `json:"namespace"`
+
+	// SecretName is the name of the target secret, defaults to the name of the Kubeconfig
+	// +optional
+	SecretName string `json:"secretName,omitempty"`
+
+	// Key is the key in the target secret's data that holds the kubeconfig
+	// +kubebuilder:default=kubeconfig
+	// +optional
+	Key string `json:"key,omitempty"`
+
+	// Labels are added to the target secret's metadata
+	// +optional
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// Annotations are added to the target secret's metadata
+	// +optional
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// KubeconfigBinding describes a single role binding for the kubeconfig's user
+type KubeconfigBinding struct {
+	// Namespace of the RoleBinding. If empty, a ClusterRoleBinding is created
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+
+	// RoleRef references the Role or ClusterRole to bind
+	RoleRef rbacv1.RoleRef `json:"roleRef"`
+}
+
 type CsrRef struct {
 	Name string `json:"name"`
+
+	// Generation is the generation of the kubeconfig for which the CSR was requested
+	// +optional
+	Generation int64 `json:"generation,omitempty"`
+
+	// SpecHash is the hash of .spec.csr and .spec.rotationToken at the time the CSR was requested
+	// +optional
+	SpecHash string `json:"specHash,omitempty"`
+
+	// RolesHash is the hash of the username and the roles bound for it at the time the CSR was requested
+	// +optional
+	RolesHash string `json:"rolesHash,omitempty"`
 }
 
 type Cluster struct {
@@ -72,8 +243,56 @@ type CertificateSigningRequest struct {
 	SignatureAlgorithm SignatureAlgorithm `json:"signatureAlgorithm,omitempty"`
 	// +kubebuilder:default={}
 	AdditionalFields CertificateSigningRequestAdditionalFields `json:"additionalFields,omitempty"`
+
+	// Duration is the requested lifetime of the client certificate, passed to the signer as the CSR's expirationSeconds.
+	// Signers may issue certificates with a shorter lifetime than requested. If unset, the signer's default lifetime applies
+	// +optional
+	Duration *metav1.Duration `json:"duration,omitempty"`
+
+	// SignerName is the signer requested by the CSR, e.g., an external signer. If unset, the operator's --signer-name
+	// applies. The operator only approves CSRs of the signers it is configured to approve for, CSRs of other signers
+	// remain pending for their own approver
+	// +optional
+	SignerName string `json:"signerName,omitempty"`
+
+	// Usages are the key usages requested by the CSR, which must include "client auth". If unset, only "client auth"
+	// is requested
+	// +optional
+	Usages []certificatesv1.KeyUsage `json:"usages,omitempty"`
+
+	// KeySize is the size of the RSA private key in bits, only for RSA signature algorithms. If unset, 4096 bit keys
+	// are generated
+	// +kubebuilder:validation:Enum=2048;3072;4096
+	// +optional
+	KeySize int `json:"keySize,omitempty"`
+
+	// Curve is the elliptic curve of the ECDSA private key, only for ECDSA signature algorithms. If unset, the curve
+	// matching the hash of the signature algorithm is used, e.g., P-256 for ECDSAWithSHA256
+	// +optional
+	Curve ECDSACurve `json:"curve,omitempty"`
+
+	// KeyEncoding is the format of the private key in the user secret and the kubeconfig. PKCS1 only applies to RSA
+	// keys and SEC1 only to ECDSA keys. If unset, RSA keys are encoded as PKCS1, ECDSA keys as SEC1 and Ed25519 keys
+	// as PKCS8
+	// +optional
+	KeyEncoding KeyEncoding `json:"keyEncoding,omitempty"`
+
+	// PrivateKeyPassphrase references the passphrase that the private key is encrypted with. If set, the private key
+	// is additionally written to the user secret as an encrypted PKCS#8 key, while the kubeconfig keeps the
+	// unencrypted key. The secret must be in the namespace of .spec.target
+	// +optional
+	PrivateKeyPassphrase *SecretKeyRef `json:"privateKeyPassphrase,omitempty"`
 }
 
+// +kubebuilder:validation:Enum=PKCS1;SEC1;PKCS8
+type KeyEncoding string
+
+const (
+	KeyEncodingPKCS1 KeyEncoding = "PKCS1"
+	KeyEncodingSEC1  KeyEncoding = "SEC1"
+	KeyEncodingPKCS8 KeyEncoding = "PKCS8"
+)
+
 // CertificateSigningRequestAdditionalFields contains the name fields of an X.509 certificate
 // Excludes the ExtraNames field because we cannot properly serialize it currently
 type CertificateSigningRequestAdditionalFields struct {
@@ -110,19 +329,109 @@ type KubeconfigStatus struct {
 	// Csr is a name reference to the CSR created by the controller
 	Csr CsrRef `json:"csr,omitempty"`
 
+	// Approvers are the users that approved the current CSR, or the current spec of kubeconfigs in the
+	// ServiceAccountToken and OIDC auth modes, with KubeconfigApprovals
+	// +optional
+	Approvers []string `json:"approvers,omitempty"`
+
+	// TargetSecret is a reference to the secret in the target namespace that the kubeconfig was delivered to
+	// +optional
+	TargetSecret *SecretRef `json:"targetSecret,omitempty"`
+
 	// Condititions are metav1 conditions that track the state of the kubeconfig
 	// +listType=map
 	// +listMapKey=type
 	Conditions []metav1.Condition `json:"condition"`
 
-	// Kubeconfig contains the final kubeconfig for the user as a formatted string
+	// Kubeconfig contains the final kubeconfig for the user as a formatted string with the client key removed.
+	// It is only populated if the operator publishes redacted kubeconfigs, the complete kubeconfig is only
+	// stored in the user secret
 	// +optional
 	Kubeconfig string `json:"kubeconfig,omitempty"`
 
+	// Certificate contains metadata of the client certificate currently in the user secret
+	// +optional
+	Certificate *CertificateStatus `json:"certificate,omitempty"`
+
+	// CertificateDuration is the lifetime granted by the signer to the client certificate currently in the user secret
+	// +optional
+	CertificateDuration *metav1.Duration `json:"certificateDuration,omitempty"`
+
+	// IssuedCertificates are the unexpired client certificates issued for the kubeconfig, including those replaced by
+	// renewals and rotations. They are recorded in the revocation list when the kubeconfig is revoked
+	// +optional
+	IssuedCertificates []IssuedCertificate `json:"issuedCertificates,omitempty"`
+
+	// RenewalTime is the point in time at which the controller starts renewing the client certificate
+	// +optional
+	RenewalTime *metav1.Time `json:"renewalTime,omitempty"`
+
+	// CredentialsGeneration is the generation of the kubeconfig for which the credentials currently in the user
+	// secret were issued
+	// +optional
+	CredentialsGeneration int64 `json:"credentialsGeneration,omitempty"`
+
+	// CredentialsSpecHash is the hash of .spec.csr and .spec.rotationToken that the credentials currently in the user
+	// secret were issued for. Changes to either rotate the credentials
+	// +optional
+	CredentialsSpecHash string `json:"credentialsSpecHash,omitempty"`
+
+	// ApprovedRolesHash is the hash of the username and the roles bound for it that the client certificate currently
+	// in the user secret was approved for. Role bindings are only synced while the spec matches it, any change requests
+	// a new certificate that needs to be approved again
+	// +optional
+	ApprovedRolesHash string `json:"approvedRolesHash,omitempty"`
+
+	// TokenExpirationTime is the point in time at which the ServiceAccount token currently in the user secret expires
+	// +optional
+	TokenExpirationTime *metav1.Time `json:"tokenExpirationTime,omitempty"`
+
+	// Retries is the number of automatic retries after transient failures since the kubeconfig was last finished
+	// +optional
+	Retries int32 `json:"retries,omitempty"`
+
 	// +kubebuilder:default="Unknown"
 	Status string `json:"status,omitempty"`
 }
 
+// CertificateStatus contains metadata of an issued client certificate
+type CertificateStatus struct {
+	// SerialNumber is the certificate's serial number in hexadecimal notation
+	SerialNumber string `json:"serialNumber,omitempty"`
+
+	// Fingerprint is the SHA-256 fingerprint of the DER-encoded certificate
+	Fingerprint string `json:"fingerprint,omitempty"`
+
+	// Issuer is the distinguished name of the certificate's issuer
+	Issuer string `json:"issuer,omitempty"`
+
+	// Subject is the distinguished name of the certificate's subject
+	Subject string `json:"subject,omitempty"`
+
+	// NotBefore is the start of the certificate's validity period
+	NotBefore *metav1.Time `json:"notBefore,omitempty"`
+
+	// NotAfter is the end of the certificate's validity period
+	NotAfter *metav1.Time `json:"notAfter,omitempty"`
+
+	// KeyType is the algorithm and size of the certificate's public key, e.g., RSA-4096 or ECDSA-P-256
+	// +optional
+	KeyType string `json:"keyType,omitempty"`
+}
+
+// IssuedCertificate identifies a client certificate issued for a kubeconfig
+type IssuedCertificate struct {
+	// SerialNumber is the certificate's serial number in hexadecimal notation
+	SerialNumber string `json:"serialNumber"`
+
+	// Fingerprint is the SHA-256 fingerprint of the DER-encoded certificate
+	// +optional
+	Fingerprint string `json:"fingerprint,omitempty"`
+
+	// NotAfter is the end of the certificate's validity period
+	NotAfter metav1.Time `json:"notAfter"`
+}
+
 // +kubebuilder:validation:Enum=SHA256WithRSA;SHA384WithRSA;SHA512WithRSA;ECDSAWithSHA256;ECDSAWithSHA384;ECDSAWithSHA512;SHA256WithRSAPSS;SHA384WithRSAPSS;SHA512WithRSAPSS;PureEd25519
 type SignatureAlgorithm string
 
@@ -141,6 +450,24 @@ const (
 	PureEd25519      SignatureAlgorithm = "PureEd25519"
 )
 
+// IsRSA returns true for the signature algorithms that use RSA keys
+func (a SignatureAlgorithm) IsRSA() bool {
+	switch a {
+	case SHA256WithRSA, SHA384WithRSA, SHA512WithRSA, SHA256WithRSAPSS, SHA384WithRSAPSS, SHA512WithRSAPSS:
+		return true
+	}
+	return false
+}
+
+// IsECDSA returns true for the signature algorithms that use ECDSA keys
+func (a SignatureAlgorithm) IsECDSA() bool {
+	switch a {
+	case ECDSAWithSHA256, ECDSAWithSHA384, ECDSAWithSHA512:
+		return true
+	}
+	return false
+}
+
 // Kubeconfig is the Schema for the kubeconfigs API
 
 // +kubebuilder:object:root=true
@@ -149,6 +476,9 @@ const (
 // +kubebuilder:printcolumn:name="Username",type=string,JSONPath=`.spec.username`
 // +kubebuilder:printcolumn:name="User Secret",type=string,JSONPath=`.status.userSecret.name`
 // +kubebuilder:printcolumn:name="Status",type=string,JSONPath=`.status.status`
+// +kubebuilder:printcolumn:name="Expires",type=date,JSONPath=`.status.certificate.notAfter`
+// +kubebuilder:printcolumn:name="Renewal",type=string,JSONPath=`.status.renewalTime`,priority=1
+// +kubebuilder:printcolumn:name="Serial",type=string,JSONPath=`.status.certificate.serialNumber`,priority=1
 // +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`,description="CreationTimestamp is a timestamp representing the server time when this object was created. It is not guaranteed to be set in happens-before order across separate operations. Clients may not set this value. It is represented in RFC3339 form and is in UTC."
 type Kubeconfig struct {
 	metav1.TypeMeta   `json:",inline"`
@@ -158,6 +488,68 @@ type Kubeconfig struct {
 	Status KubeconfigStatus `json:"status,omitempty"`
 }
 
+// RequestedByAnnotationKey is the annotation that records the user that created a kubeconfig. It is set by the
+// admission webhook from the creating request and cannot be changed afterwards
+const RequestedByAnnotationKey = "kubeconfig.k8s.zoomoid.dev/requested-by"
+
+// IsRequester returns true if the user is the kubeconfig's own user or the user that created it. Neither may approve
+// the kubeconfig
+func (k *Kubeconfig) IsRequester(user string) bool {
+	return user == k.Spec.Username || (user != "" && user == k.Annotations[RequestedByAnnotationKey])
+}
+
+// EffectiveAuthMode returns the kubeconfig's auth mode, kubeconfigs created before auth modes were introduced
+// use client certificates
+func (k *Kubeconfig) EffectiveAuthMode() AuthMode {
+	if k.Spec.AuthMode == "" {
+		return AuthModeClientCertificate
+	}
+	return k.Spec.AuthMode
+}
+
+// UsesClientCertificate returns true if the kubeconfig authenticates with a client certificate, i.e., requests a CSR
+func (k *Kubeconfig) UsesClientCertificate() bool {
+	return k.EffectiveAuthMode() == AuthModeClientCertificate
+}
+
+// UsesServiceAccountToken returns true if the kubeconfig authenticates with a ServiceAccount token instead of
+// a client certificate
+func (k *Kubeconfig) UsesServiceAccountToken() bool {
+	return k.Spec.AuthMode == AuthModeServiceAccountToken
+}
+
+// UsesOIDC returns true if the kubeconfig authenticates with OIDC ID tokens obtained by an exec credential plugin
+func (k *Kubeconfig) UsesOIDC() bool {
+	return k.Spec.AuthMode == AuthModeOIDC
+}
+
+// ApprovalSpecHash returns a hash of the parts of the spec that an approval of the kubeconfig's credentials covers,
+// i.e., the parameters and rotation token of the credentials, and the identity and roles they are issued for.
+// Changes to other fields, e.g., to the target, do not need to be approved again
+func (k *Kubeconfig) ApprovalSpecHash() string {
+	var credentials interface{} = k.Spec.CSR
+	if k.UsesServiceAccountToken() {
+		credentials = k.Spec.ServiceAccountToken
+	} else if k.UsesOIDC() {
+		credentials = k.Spec.OIDC
+	}
+	data, err := json.Marshal(struct {
+		AuthMode      AuthMode            `json:"authMode"`
+		Username      string              `json:"username"`
+		Groups        []string            `json:"groups"`
+		RoleRef       *rbacv1.RoleRef     `json:"roleRef"`
+		Bindings      []KubeconfigBinding `json:"bindings"`
+		Credentials   interface{}         `json:"credentials"`
+		RotationToken string              `json:"rotationToken"`
+	}{k.EffectiveAuthMode(), k.Spec.Username, k.Spec.Groups, k.Spec.RoleRef, k.Spec.Bindings, credentials, k.Spec.RotationToken})
+	if err != nil {
+		// cannot happen for the plain structs, an empty hash matches no approval
+		return ""
+	}
+	h := sha256.Sum256(data)
+	return hex.EncodeToString(h[:])
+}
+
 //+kubebuilder:object:root=true
 
 // KubeconfigList contains a list of Kubeconfig
@@ -18,16 +18,26 @@ package v1alpha1
 
 import (
 	"context"
+	"fmt"
+	"math"
+	"net/url"
 	"reflect"
+	"strings"
+	"time"
 
 	"github.com/zoomoid/kubeconfig-operator/controllers/phases"
 	"github.com/zoomoid/kubeconfig-operator/pkg/utils"
+	admissionv1 "k8s.io/api/admission/v1"
+	certificatesv1 "k8s.io/api/certificates/v1"
 	rbacv1 "k8s.io/api/rbac/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/meta"
+	apivalidation "k8s.io/apimachinery/pkg/api/validation"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	metav1validation "k8s.io/apimachinery/pkg/apis/meta/v1/validation"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"k8s.io/apimachinery/pkg/util/validation/field"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -39,7 +49,35 @@ import (
 // log is for logging in this package.
 var kubeconfiglog = logf.Log.WithName("kubeconfig-resource")
 
-func (r *Kubeconfig) SetupWebhookWithManager(mgr ctrl.Manager) error {
+// MinimumCertificateDuration is the shortest certificate lifetime the kube-apiserver accepts in a CSR's expirationSeconds
+const MinimumCertificateDuration = 10 * time.Minute
+
+// MaximumCertificateDuration is the longest certificate lifetime that fits into a CSR's expirationSeconds
+const MaximumCertificateDuration = math.MaxInt32 * time.Second
+
+// MinimumTokenDuration is the shortest token lifetime the kube-apiserver accepts in a TokenRequest's expirationSeconds
+const MinimumTokenDuration = 10 * time.Minute
+
+// DefaultTokenDuration is the lifetime requested for ServiceAccount tokens if .spec.serviceAccountToken.duration is unset
+const DefaultTokenDuration = 24 * time.Hour
+
+// WebhookOptions contains operator-wide settings for defaulting and validating kubeconfigs
+type WebhookOptions struct {
+	// MinCertificateDuration is the shortest certificate lifetime that may be requested in .spec.csr.duration.
+	// Values below MinimumCertificateDuration are raised to it
+	MinCertificateDuration time.Duration
+	// MaxCertificateDuration is the longest certificate lifetime that may be requested in .spec.csr.duration.
+	// Zero leaves MaximumCertificateDuration as the only upper bound
+	MaxCertificateDuration time.Duration
+	// SignerName is the signer requested by CSRs of kubeconfigs without .spec.csr.signerName, i.e., the operator's
+	// --signer-name. Empty defaults to the kube-apiserver-client signer
+	SignerName string
+}
+
+func (r *Kubeconfig) SetupWebhookWithManager(mgr ctrl.Manager, opts WebhookOptions) error {
+	if opts.MinCertificateDuration < MinimumCertificateDuration {
+		opts.MinCertificateDuration = MinimumCertificateDuration
+	}
 	return ctrl.NewWebhookManagedBy(mgr).
 		For(r).
 		WithDefaulter(&kubeconfigDefaulter{
@@ -47,6 +85,7 @@ func (r *Kubeconfig) SetupWebhookWithManager(mgr ctrl.Manager) error {
 		}).
 		WithValidator(&kubeconfigValidator{
 			client: mgr.GetClient(),
+			opts:   opts,
 		}).
 		Complete()
 }
@@ -71,6 +110,18 @@ func (a *kubeconfigDefaulter) InjectClient(c client.Client) error {
 func (r *kubeconfigDefaulter) Default(ctx context.Context, obj runtime.Object) error {
 	kubeconfig, _ := obj.(*Kubeconfig)
 
+	req, err := admission.RequestFromContext(ctx)
+	if err != nil {
+		return err
+	}
+	if req.Operation == admissionv1.Create {
+		// overwrite anything the client put there, approvals by the requesting user are never counted
+		if kubeconfig.Annotations == nil {
+			kubeconfig.Annotations = map[string]string{}
+		}
+		kubeconfig.Annotations[RequestedByAnnotationKey] = req.UserInfo.Username
+	}
+
 	if kubeconfig.Spec.Cluster == nil {
 		kubeconfig.Spec.Cluster = &Cluster{}
 	}
@@ -89,8 +140,8 @@ func (r *kubeconfigDefaulter) Default(ctx context.Context, obj runtime.Object) e
 		kubeconfig.Spec.Cluster.Name = "kubernetes"
 	}
 
-	// Default the ClusterRole ref if not specified
-	if kubeconfig.Spec.RoleRef == nil {
+	// Default the ClusterRole ref if not specified and the user is granted no other permissions
+	if kubeconfig.Spec.RoleRef == nil && len(kubeconfig.Spec.Bindings) == 0 && len(kubeconfig.Spec.Groups) == 0 {
 		kubeconfig.Spec.RoleRef = &rbacv1.RoleRef{
 			APIGroup: "rbac.authorization.k8s.io",
 			Name:     "cluster-admin",
@@ -98,6 +149,12 @@ func (r *kubeconfigDefaulter) Default(ctx context.Context, obj runtime.Object) e
 		}
 	}
 
+	for i := range kubeconfig.Spec.Bindings {
+		if kubeconfig.Spec.Bindings[i].RoleRef.APIGroup == "" {
+			kubeconfig.Spec.Bindings[i].RoleRef.APIGroup = rbacv1.GroupName
+		}
+	}
+
 	if kubeconfig.Spec.CSR == nil {
 		kubeconfig.Spec.CSR = &CertificateSigningRequest{
 			SignatureAlgorithm: SHA256WithRSA,
@@ -107,6 +164,29 @@ func (r *kubeconfigDefaulter) Default(ctx context.Context, obj runtime.Object) e
 	if kubeconfig.Spec.CSR.SignatureAlgorithm == "" {
 		kubeconfig.Spec.CSR.SignatureAlgorithm = SHA256WithRSA
 	}
+	// the key size and curve are left unset, such that they follow changes of the signature algorithm. The
+	// controller generates keys with the defaults of the algorithm
+
+	if kubeconfig.Spec.AuthMode == "" {
+		kubeconfig.Spec.AuthMode = AuthModeClientCertificate
+	}
+	if kubeconfig.Spec.AuthMode == AuthModeServiceAccountToken {
+		if kubeconfig.Spec.ServiceAccountToken == nil {
+			kubeconfig.Spec.ServiceAccountToken = &ServiceAccountToken{}
+		}
+		if kubeconfig.Spec.ServiceAccountToken.Duration == nil {
+			kubeconfig.Spec.ServiceAccountToken.Duration = &metav1.Duration{Duration: DefaultTokenDuration}
+		}
+	}
+
+	if kubeconfig.Spec.Target != nil {
+		if kubeconfig.Spec.Target.SecretName == "" {
+			kubeconfig.Spec.Target.SecretName = kubeconfig.Name
+		}
+		if kubeconfig.Spec.Target.Key == "" {
+			kubeconfig.Spec.Target.Key = "kubeconfig"
+		}
+	}
 
 	cl := kubeconfig.Status.Conditions
 	if meta.FindStatusCondition(cl, ConditionTypeCSRCreated) == nil {
@@ -165,6 +245,7 @@ func (r *kubeconfigDefaulter) Default(ctx context.Context, obj runtime.Object) e
 
 type kubeconfigValidator struct {
 	client client.Client
+	opts   WebhookOptions
 }
 
 var _ admission.CustomValidator = &kubeconfigValidator{}
@@ -179,9 +260,18 @@ func (a *kubeconfigValidator) InjectClient(c client.Client) error {
 
 // ValidateCreate implements webhook.Validator so a webhook will be registered for the type
 func (r *kubeconfigValidator) ValidateCreate(ctx context.Context, obj runtime.Object) error {
-	// kubeconfig, _ := obj.(*Kubeconfig)
+	kubeconfig, _ := obj.(*Kubeconfig)
 	// kubeconfiglog.Info("validate create", "name", kubeconfig.Name)
-	return nil
+	allErrs := r.validateSpec(kubeconfig)
+	allErrs = append(allErrs, r.validateUsername(ctx, kubeconfig)...)
+	if len(allErrs) == 0 {
+		return nil
+	}
+
+	return apierrors.NewInvalid(schema.GroupKind{
+		Group: "kubeconfig.k8s.zoomoid.dev",
+		Kind:  "Kubeconfig",
+	}, kubeconfig.Name, allErrs)
 }
 
 // ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
@@ -196,8 +286,29 @@ func (r *kubeconfigValidator) ValidateUpdate(ctx context.Context, old runtime.Ob
 	if !reflect.DeepEqual(oldKubeconfig.Spec.ExistingCSR, newKubeconfig.Spec.ExistingCSR) {
 		allErrs = append(allErrs, field.Forbidden(field.NewPath("spec").Child("existingCSR"), ".spec.existingCSR is immutable"))
 	}
-	if !reflect.DeepEqual(oldKubeconfig.Spec.CSR, newKubeconfig.Spec.CSR) {
-		allErrs = append(allErrs, field.Forbidden(field.NewPath("spec").Child("CSR"), ".spec.csr is immutable"))
+	// changes to .spec.csr rotate the credentials, which requires the controller to generate the key
+	if newKubeconfig.Spec.ExistingCSR != nil && !reflect.DeepEqual(oldKubeconfig.Spec.CSR, newKubeconfig.Spec.CSR) {
+		allErrs = append(allErrs, field.Forbidden(field.NewPath("spec").Child("csr"), ".spec.csr is immutable for kubeconfigs with .spec.existingCSR"))
+	}
+	if !reflect.DeepEqual(oldKubeconfig.Spec.Groups, newKubeconfig.Spec.Groups) {
+		allErrs = append(allErrs, field.Forbidden(field.NewPath("spec").Child("groups"), ".spec.groups is immutable"))
+	}
+	if oldKubeconfig.EffectiveAuthMode() != newKubeconfig.EffectiveAuthMode() {
+		allErrs = append(allErrs, field.Forbidden(field.NewPath("spec").Child("authMode"), ".spec.authMode is immutable"))
+	}
+	if oldKubeconfig.Annotations[RequestedByAnnotationKey] != newKubeconfig.Annotations[RequestedByAnnotationKey] {
+		allErrs = append(allErrs, field.Forbidden(field.NewPath("metadata").Child("annotations").Key(RequestedByAnnotationKey), "the requesting user is immutable"))
+	}
+	if oldKubeconfig.Spec.Revoked && !newKubeconfig.Spec.Revoked {
+		allErrs = append(allErrs, field.Forbidden(field.NewPath("spec").Child("revoked"), "revocation cannot be undone"))
+	}
+	// kubeconfigs being deleted only await the removal of their finalizer, which must not fail on operator-wide
+	// settings that changed since they were created
+	if newKubeconfig.DeletionTimestamp == nil {
+		allErrs = append(allErrs, r.validateSpec(newKubeconfig)...)
+		if oldKubeconfig.Spec.Username != newKubeconfig.Spec.Username {
+			allErrs = append(allErrs, r.validateUsername(ctx, newKubeconfig)...)
+		}
 	}
 	if len(allErrs) == 0 {
 		// no errors during validation
@@ -216,3 +327,274 @@ func (r *kubeconfigValidator) ValidateDelete(ctx context.Context, obj runtime.Ob
 	// kubeconfiglog.Info("validate delete", "name", kubeconfig.Name)
 	return nil
 }
+
+// validateSpec checks the fields of a kubeconfig's spec that cannot be expressed in the OpenAPI schema
+func (r *kubeconfigValidator) validateSpec(kubeconfig *Kubeconfig) field.ErrorList {
+	var allErrs field.ErrorList
+	specPath := field.NewPath("spec")
+	if renewBefore := kubeconfig.Spec.RenewBefore; renewBefore != nil && renewBefore.Duration <= 0 {
+		allErrs = append(allErrs, field.Invalid(specPath.Child("renewBefore"), renewBefore.Duration.String(), "must be a positive duration"))
+	}
+	if kubeconfig.UsesServiceAccountToken() {
+		if kubeconfig.Spec.ExistingCSR != nil {
+			allErrs = append(allErrs, field.Forbidden(specPath.Child("existingCSR"), "cannot be used with the ServiceAccountToken auth mode"))
+		}
+		if len(kubeconfig.Spec.Groups) > 0 {
+			allErrs = append(allErrs, field.Forbidden(specPath.Child("groups"), "cannot be used with the ServiceAccountToken auth mode, ServiceAccounts have fixed groups"))
+		}
+		if token := kubeconfig.Spec.ServiceAccountToken; token != nil && token.Duration != nil && token.Duration.Duration < MinimumTokenDuration {
+			allErrs = append(allErrs, field.Invalid(specPath.Child("serviceAccountToken").Child("duration"), token.Duration.Duration.String(), fmt.Sprintf("must be at least %s", MinimumTokenDuration)))
+		}
+	} else if kubeconfig.Spec.ServiceAccountToken != nil {
+		allErrs = append(allErrs, field.Forbidden(specPath.Child("serviceAccountToken"), "can only be used with the ServiceAccountToken auth mode"))
+	}
+	if kubeconfig.UsesOIDC() {
+		allErrs = append(allErrs, validateOIDC(kubeconfig.Spec.OIDC, specPath.Child("oidc"))...)
+		if kubeconfig.Spec.ExistingCSR != nil {
+			allErrs = append(allErrs, field.Forbidden(specPath.Child("existingCSR"), "cannot be used with the OIDC auth mode"))
+		}
+		if kubeconfig.Spec.RotationToken != "" {
+			allErrs = append(allErrs, field.Forbidden(specPath.Child("rotationToken"), "cannot be used with the OIDC auth mode, ID tokens are issued by the identity provider"))
+		}
+	} else if kubeconfig.Spec.OIDC != nil {
+		allErrs = append(allErrs, field.Forbidden(specPath.Child("oidc"), "can only be used with the OIDC auth mode"))
+	}
+	if kubeconfig.Spec.RotationToken != "" && kubeconfig.Spec.ExistingCSR != nil {
+		allErrs = append(allErrs, field.Forbidden(specPath.Child("rotationToken"), "rotating credentials requires the controller to generate the private key, which kubeconfigs with .spec.existingCSR do not"))
+	}
+	if kubeconfig.Spec.CSR != nil && kubeconfig.Spec.CSR.Duration != nil {
+		durationPath := specPath.Child("csr").Child("duration")
+		duration := kubeconfig.Spec.CSR.Duration.Duration
+		if duration < r.opts.MinCertificateDuration {
+			allErrs = append(allErrs, field.Invalid(durationPath, duration.String(), fmt.Sprintf("must be at least %s", r.opts.MinCertificateDuration)))
+		}
+		if duration > MaximumCertificateDuration {
+			allErrs = append(allErrs, field.Invalid(durationPath, duration.String(), fmt.Sprintf("must be at most %s", MaximumCertificateDuration)))
+		} else if r.opts.MaxCertificateDuration > 0 && duration > r.opts.MaxCertificateDuration {
+			allErrs = append(allErrs, field.Invalid(durationPath, duration.String(), fmt.Sprintf("must be at most %s", r.opts.MaxCertificateDuration)))
+		}
+	}
+	if kubeconfig.Spec.CSR != nil {
+		allErrs = append(allErrs, validateSigner(kubeconfig.Spec.CSR, r.opts.SignerName, specPath.Child("csr"))...)
+		allErrs = append(allErrs, validateKeyParameters(kubeconfig.Spec.CSR, kubeconfig.Spec.ExistingCSR != nil, kubeconfig.Spec.Target, specPath.Child("csr"))...)
+	}
+	if target := kubeconfig.Spec.Target; target != nil {
+		targetPath := specPath.Child("target")
+		for _, msg := range validation.IsDNS1123Label(target.Namespace) {
+			allErrs = append(allErrs, field.Invalid(targetPath.Child("namespace"), target.Namespace, msg))
+		}
+		for _, msg := range validation.IsDNS1123Subdomain(target.SecretName) {
+			allErrs = append(allErrs, field.Invalid(targetPath.Child("secretName"), target.SecretName, msg))
+		}
+		for _, msg := range validation.IsConfigMapKey(target.Key) {
+			allErrs = append(allErrs, field.Invalid(targetPath.Child("key"), target.Key, msg))
+		}
+		allErrs = append(allErrs, metav1validation.ValidateLabels(target.Labels, targetPath.Child("labels"))...)
+		allErrs = append(allErrs, apivalidation.ValidateAnnotations(target.Annotations, targetPath.Child("annotations"))...)
+	}
+	allErrs = append(allErrs, validateBindings(kubeconfig.Spec.Bindings, specPath.Child("bindings"))...)
+	seenGroups := map[string]bool{}
+	for i, group := range kubeconfig.Spec.Groups {
+		groupPath := specPath.Child("groups").Index(i)
+		if group == "" {
+			allErrs = append(allErrs, field.Required(groupPath, "group must not be empty"))
+		}
+		if seenGroups[group] {
+			allErrs = append(allErrs, field.Duplicate(groupPath, group))
+		}
+		seenGroups[group] = true
+	}
+	return allErrs
+}
+
+// clientUsages are the key usages that may be requested for client certificates
+var clientUsages = []certificatesv1.KeyUsage{
+	certificatesv1.UsageClientAuth,
+	certificatesv1.UsageDigitalSignature,
+	certificatesv1.UsageKeyEncipherment,
+	certificatesv1.UsageKeyAgreement,
+	certificatesv1.UsageSigning,
+}
+
+// kubeAPIServerClientUsages are the key usages that the kube-apiserver-client signer issues
+var kubeAPIServerClientUsages = []certificatesv1.KeyUsage{
+	certificatesv1.UsageClientAuth,
+	certificatesv1.UsageDigitalSignature,
+	certificatesv1.UsageKeyEncipherment,
+}
+
+// validateSigner checks the signer name and key usages requested for the client certificate. CSRs without a signer
+// name request the default signer
+func validateSigner(csr *CertificateSigningRequest, defaultSignerName string, path *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	signerName := csr.SignerName
+	if signerName != "" {
+		signerPath := path.Child("signerName")
+		domain, _, ok := strings.Cut(signerName, "/")
+		if !ok {
+			allErrs = append(allErrs, field.Invalid(signerPath, signerName, "must be a fully qualified signer name of the form <domain>/<path>"))
+		} else {
+			for _, msg := range validation.IsDNS1123Subdomain(domain) {
+				allErrs = append(allErrs, field.Invalid(signerPath, signerName, msg))
+			}
+		}
+		if domain == "kubernetes.io" && signerName != certificatesv1.KubeAPIServerClientSignerName {
+			allErrs = append(allErrs, field.NotSupported(signerPath, signerName, []string{certificatesv1.KubeAPIServerClientSignerName}))
+		}
+	}
+
+	if signerName == "" {
+		signerName = defaultSignerName
+	}
+	if signerName == "" {
+		signerName = certificatesv1.KubeAPIServerClientSignerName
+	}
+	allowed := clientUsages
+	if signerName == certificatesv1.KubeAPIServerClientSignerName {
+		allowed = kubeAPIServerClientUsages
+	}
+	usagesPath := path.Child("usages")
+	seen := map[certificatesv1.KeyUsage]bool{}
+	for i, usage := range csr.Usages {
+		if seen[usage] {
+			allErrs = append(allErrs, field.Duplicate(usagesPath.Index(i), usage))
+		}
+		seen[usage] = true
+		supported := false
+		for _, u := range allowed {
+			supported = supported || u == usage
+		}
+		if !supported {
+			values := make([]string, 0, len(allowed))
+			for _, u := range allowed {
+				values = append(values, string(u))
+			}
+			allErrs = append(allErrs, field.NotSupported(usagesPath.Index(i), usage, values))
+		}
+	}
+	if len(csr.Usages) > 0 && !seen[certificatesv1.UsageClientAuth] {
+		allErrs = append(allErrs, field.Invalid(usagesPath, csr.Usages, fmt.Sprintf("must include %q", certificatesv1.UsageClientAuth)))
+	}
+	return allErrs
+}
+
+// validateKeyParameters checks that the key size, curve and encoding match the key type of the signature algorithm,
+// and that they are only set if the operator generates the private key. The passphrase secret must be in the target
+// namespace, otherwise the operator would read secrets of any namespace on behalf of the kubeconfig's creator
+func validateKeyParameters(csr *CertificateSigningRequest, existingCSR bool, target *KubeconfigTarget, path *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	algorithm := csr.SignatureAlgorithm
+	if csr.KeySize != 0 {
+		if existingCSR {
+			allErrs = append(allErrs, field.Forbidden(path.Child("keySize"), "cannot be used with .spec.existingCSR, the private key is not generated by the operator"))
+		} else if !algorithm.IsRSA() {
+			allErrs = append(allErrs, field.Forbidden(path.Child("keySize"), fmt.Sprintf("only applies to RSA signature algorithms, not %s", algorithm)))
+		}
+	}
+	if csr.Curve != "" {
+		if existingCSR {
+			allErrs = append(allErrs, field.Forbidden(path.Child("curve"), "cannot be used with .spec.existingCSR, the private key is not generated by the operator"))
+		} else if !algorithm.IsECDSA() {
+			allErrs = append(allErrs, field.Forbidden(path.Child("curve"), fmt.Sprintf("only applies to ECDSA signature algorithms, not %s", algorithm)))
+		}
+	}
+	if encoding := csr.KeyEncoding; encoding != "" {
+		encodingPath := path.Child("keyEncoding")
+		if existingCSR {
+			allErrs = append(allErrs, field.Forbidden(encodingPath, "cannot be used with .spec.existingCSR, the private key is not generated by the operator"))
+		} else if encoding == KeyEncodingPKCS1 && !algorithm.IsRSA() {
+			allErrs = append(allErrs, field.Forbidden(encodingPath, fmt.Sprintf("PKCS1 only applies to RSA signature algorithms, not %s", algorithm)))
+		} else if encoding == KeyEncodingSEC1 && !algorithm.IsECDSA() {
+			allErrs = append(allErrs, field.Forbidden(encodingPath, fmt.Sprintf("SEC1 only applies to ECDSA signature algorithms, not %s", algorithm)))
+		}
+	}
+	if passphrase := csr.PrivateKeyPassphrase; passphrase != nil {
+		passphrasePath := path.Child("privateKeyPassphrase")
+		if existingCSR {
+			allErrs = append(allErrs, field.Forbidden(passphrasePath, "cannot be used with .spec.existingCSR, the private key is not generated by the operator"))
+		}
+		if target == nil {
+			allErrs = append(allErrs, field.Forbidden(passphrasePath, "requires .spec.target, the passphrase secret must be in the target namespace"))
+		} else if passphrase.Namespace != target.Namespace {
+			allErrs = append(allErrs, field.Invalid(passphrasePath.Child("namespace"), passphrase.Namespace, fmt.Sprintf("must be the target namespace %s", target.Namespace)))
+		}
+		for _, msg := range validation.IsDNS1123Subdomain(passphrase.Name) {
+			allErrs = append(allErrs, field.Invalid(passphrasePath.Child("name"), passphrase.Name, msg))
+		}
+		if passphrase.Key != "" {
+			for _, msg := range validation.IsConfigMapKey(passphrase.Key) {
+				allErrs = append(allErrs, field.Invalid(passphrasePath.Child("key"), passphrase.Key, msg))
+			}
+		}
+	}
+	return allErrs
+}
+
+// validateOIDC checks the parameters of the exec credential plugin of a kubeconfig in the OIDC auth mode
+func validateOIDC(oidc *OIDC, path *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	if oidc == nil {
+		return append(allErrs, field.Required(path, "required for the OIDC auth mode"))
+	}
+	issuer, err := url.Parse(oidc.IssuerURL)
+	if err != nil || issuer.Scheme != "https" || issuer.Host == "" {
+		allErrs = append(allErrs, field.Invalid(path.Child("issuerURL"), oidc.IssuerURL, "must be an https URL"))
+	}
+	if oidc.ClientID == "" {
+		allErrs = append(allErrs, field.Required(path.Child("clientID"), "client ID is required"))
+	}
+	return allErrs
+}
+
+// validateUsername refuses usernames of revoked certificates that are not expired yet, since the certificate
+// would be granted the new kubeconfig's access as well
+func (r *kubeconfigValidator) validateUsername(ctx context.Context, kubeconfig *Kubeconfig) field.ErrorList {
+	usernamePath := field.NewPath("spec").Child("username")
+	list := &RevocationList{}
+	err := r.client.Get(ctx, client.ObjectKey{Name: RevocationListName}, list)
+	if apierrors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return field.ErrorList{field.InternalError(usernamePath, err)}
+	}
+	revoked := list.RevokedUsername(kubeconfig.Spec.Username, time.Now())
+	if revoked == nil {
+		return nil
+	}
+	return field.ErrorList{field.Forbidden(usernamePath, fmt.Sprintf("a certificate of username %s was revoked, the username can be reused after %s", kubeconfig.Spec.Username, revoked.NotAfter.Format(time.RFC3339)))}
+}
+
+// validateBindings checks that bindings reference roles that can be bound in their scope and that no binding is listed twice
+func validateBindings(bindings []KubeconfigBinding, bindingsPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	seen := map[KubeconfigBinding]bool{}
+	for i, binding := range bindings {
+		bindingPath := bindingsPath.Index(i)
+		roleRefPath := bindingPath.Child("roleRef")
+		if binding.Namespace != "" {
+			for _, msg := range validation.IsDNS1123Label(binding.Namespace) {
+				allErrs = append(allErrs, field.Invalid(bindingPath.Child("namespace"), binding.Namespace, msg))
+			}
+		}
+		if binding.RoleRef.APIGroup != rbacv1.GroupName {
+			allErrs = append(allErrs, field.NotSupported(roleRefPath.Child("apiGroup"), binding.RoleRef.APIGroup, []string{rbacv1.GroupName}))
+		}
+		switch binding.RoleRef.Kind {
+		case "ClusterRole":
+		case "Role":
+			if binding.Namespace == "" {
+				allErrs = append(allErrs, field.Invalid(roleRefPath.Child("kind"), binding.RoleRef.Kind, "Roles can only be bound in a namespace"))
+			}
+		default:
+			allErrs = append(allErrs, field.NotSupported(roleRefPath.Child("kind"), binding.RoleRef.Kind, []string{"Role", "ClusterRole"}))
+		}
+		if binding.RoleRef.Name == "" {
+			allErrs = append(allErrs, field.Required(roleRefPath.Child("name"), "name of the role to bind is required"))
+		}
+		if seen[binding] {
+			allErrs = append(allErrs, field.Duplicate(bindingPath, binding.RoleRef.Name))
+		}
+		seen[binding] = true
+	}
+	return allErrs
+}
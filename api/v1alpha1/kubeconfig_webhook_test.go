@@ -0,0 +1,406 @@
+/*
+Copyright 2022 zoomoid.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	certificatesv1 "k8s.io/api/certificates/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var _ = Describe("Kubeconfig webhook", func() {
+	duration := func(d time.Duration) *metav1.Duration {
+		return &metav1.Duration{Duration: d}
+	}
+
+	table.DescribeTable("validates the spec of new kubeconfigs",
+		func(mutate func(kubeconfig *Kubeconfig), fields []string) {
+			kubeconfig := newTestKubeconfig("validation")
+			mutate(kubeconfig)
+			err := k8sClient.Create(ctx, kubeconfig)
+			if fields == nil {
+				Expect(err).NotTo(HaveOccurred())
+				return
+			}
+			expectRejected(err)
+			Expect(errorFields(err)).To(Equal(fields))
+		},
+		table.Entry("without optional fields", func(k *Kubeconfig) {}, nil),
+
+		table.Entry("with a certificate lifetime", func(k *Kubeconfig) {
+			k.Spec.CSR.Duration = duration(24 * time.Hour)
+		}, nil),
+		table.Entry("with a certificate lifetime below the minimum", func(k *Kubeconfig) {
+			k.Spec.CSR.Duration = duration(time.Minute)
+		}, []string{"spec.csr.duration"}),
+		table.Entry("with the longest certificate lifetime of expirationSeconds", func(k *Kubeconfig) {
+			k.Spec.CSR.Duration = duration(MaximumCertificateDuration)
+		}, nil),
+		table.Entry("with a certificate lifetime overflowing expirationSeconds", func(k *Kubeconfig) {
+			k.Spec.CSR.Duration = duration(MaximumCertificateDuration + time.Second)
+		}, []string{"spec.csr.duration"}),
+
+		table.Entry("with a target", func(k *Kubeconfig) {
+			k.Spec.Target = &KubeconfigTarget{Namespace: "alice", SecretName: "kubeconfig", Key: "config", Labels: map[string]string{"app": "ci"}}
+		}, nil),
+		table.Entry("with an invalid target key", func(k *Kubeconfig) {
+			k.Spec.Target = &KubeconfigTarget{Namespace: "alice", SecretName: "kubeconfig", Key: "a/b"}
+		}, []string{"spec.target.key"}),
+		table.Entry("with an invalid target secret name", func(k *Kubeconfig) {
+			k.Spec.Target = &KubeconfigTarget{Namespace: "alice", SecretName: "Kubeconfig"}
+		}, []string{"spec.target.secretName"}),
+		table.Entry("with invalid target labels", func(k *Kubeconfig) {
+			k.Spec.Target = &KubeconfigTarget{Namespace: "alice", Labels: map[string]string{"app": "not a label value"}}
+		}, []string{"spec.target.labels"}),
+
+		table.Entry("with a ClusterRole bound in a namespace", func(k *Kubeconfig) {
+			k.Spec.Bindings = []KubeconfigBinding{{Namespace: "dev", RoleRef: rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: "edit"}}}
+		}, nil),
+		table.Entry("with a Role bound cluster-wide", func(k *Kubeconfig) {
+			k.Spec.Bindings = []KubeconfigBinding{{RoleRef: rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "Role", Name: "edit"}}}
+		}, []string{"spec.bindings[0].roleRef.kind"}),
+		table.Entry("with a binding listed twice", func(k *Kubeconfig) {
+			binding := KubeconfigBinding{Namespace: "dev", RoleRef: rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "Role", Name: "edit"}}
+			k.Spec.Bindings = []KubeconfigBinding{binding, binding}
+		}, []string{"spec.bindings[1]"}),
+
+		table.Entry("with a rotation token", func(k *Kubeconfig) {
+			k.Spec.RotationToken = "2022-06-01"
+		}, nil),
+		table.Entry("with a rotation token and an existing CSR", func(k *Kubeconfig) {
+			k.Spec.ExistingCSR = &SecretRef{Namespace: "alice", Name: "csr"}
+			k.Spec.RotationToken = "2022-06-01"
+		}, []string{"spec.rotationToken"}),
+
+		table.Entry("in the ServiceAccountToken auth mode", func(k *Kubeconfig) {
+			k.Spec.AuthMode = AuthModeServiceAccountToken
+			k.Spec.ServiceAccountToken = &ServiceAccountToken{Audiences: []string{"https://kubernetes.default.svc"}, Duration: duration(time.Hour)}
+		}, nil),
+		table.Entry("with a token lifetime below the minimum", func(k *Kubeconfig) {
+			k.Spec.AuthMode = AuthModeServiceAccountToken
+			k.Spec.ServiceAccountToken = &ServiceAccountToken{Duration: duration(time.Minute)}
+		}, []string{"spec.serviceAccountToken.duration"}),
+		table.Entry("with a token and an existing CSR", func(k *Kubeconfig) {
+			k.Spec.AuthMode = AuthModeServiceAccountToken
+			k.Spec.ExistingCSR = &SecretRef{Namespace: "alice", Name: "csr"}
+		}, []string{"spec.existingCSR"}),
+		table.Entry("with token parameters outside of the ServiceAccountToken auth mode", func(k *Kubeconfig) {
+			k.Spec.ServiceAccountToken = &ServiceAccountToken{}
+		}, []string{"spec.serviceAccountToken"}),
+
+		table.Entry("in the OIDC auth mode", func(k *Kubeconfig) {
+			k.Spec.AuthMode = AuthModeOIDC
+			k.Spec.OIDC = &OIDC{IssuerURL: "https://issuer.example.com", ClientID: "kubernetes"}
+		}, nil),
+		table.Entry("in the OIDC auth mode without identity provider", func(k *Kubeconfig) {
+			k.Spec.AuthMode = AuthModeOIDC
+		}, []string{"spec.oidc"}),
+		table.Entry("with a plain HTTP issuer", func(k *Kubeconfig) {
+			k.Spec.AuthMode = AuthModeOIDC
+			k.Spec.OIDC = &OIDC{IssuerURL: "http://issuer.example.com", ClientID: "kubernetes"}
+		}, []string{"spec.oidc.issuerURL"}),
+		table.Entry("with a rotation token in the OIDC auth mode", func(k *Kubeconfig) {
+			k.Spec.AuthMode = AuthModeOIDC
+			k.Spec.OIDC = &OIDC{IssuerURL: "https://issuer.example.com", ClientID: "kubernetes"}
+			k.Spec.RotationToken = "2022-06-01"
+		}, []string{"spec.rotationToken"}),
+		table.Entry("with an identity provider outside of the OIDC auth mode", func(k *Kubeconfig) {
+			k.Spec.OIDC = &OIDC{IssuerURL: "https://issuer.example.com", ClientID: "kubernetes"}
+		}, []string{"spec.oidc"}),
+
+		table.Entry("with a custom signer", func(k *Kubeconfig) {
+			k.Spec.CSR.SignerName = "example.com/client"
+			k.Spec.CSR.Usages = []certificatesv1.KeyUsage{certificatesv1.UsageClientAuth, certificatesv1.UsageSigning}
+		}, nil),
+		table.Entry("with a signer name without path", func(k *Kubeconfig) {
+			k.Spec.CSR.SignerName = "example.com"
+		}, []string{"spec.csr.signerName"}),
+		table.Entry("with an unsupported kubernetes.io signer", func(k *Kubeconfig) {
+			k.Spec.CSR.SignerName = "kubernetes.io/kubelet-serving"
+		}, []string{"spec.csr.signerName"}),
+		table.Entry("with usages without client auth", func(k *Kubeconfig) {
+			k.Spec.CSR.Usages = []certificatesv1.KeyUsage{certificatesv1.UsageDigitalSignature}
+		}, []string{"spec.csr.usages"}),
+		table.Entry("with usages other than for client certificates", func(k *Kubeconfig) {
+			k.Spec.CSR.Usages = []certificatesv1.KeyUsage{certificatesv1.UsageClientAuth, certificatesv1.UsageServerAuth}
+		}, []string{"spec.csr.usages[1]"}),
+		table.Entry("with usages the kube-apiserver-client signer does not issue", func(k *Kubeconfig) {
+			k.Spec.CSR.SignerName = certificatesv1.KubeAPIServerClientSignerName
+			k.Spec.CSR.Usages = []certificatesv1.KeyUsage{certificatesv1.UsageClientAuth, certificatesv1.UsageKeyAgreement}
+		}, []string{"spec.csr.usages[1]"}),
+		table.Entry("with usages the default kube-apiserver-client signer does not issue", func(k *Kubeconfig) {
+			k.Spec.CSR.Usages = []certificatesv1.KeyUsage{certificatesv1.UsageClientAuth, certificatesv1.UsageSigning}
+		}, []string{"spec.csr.usages[1]"}),
+		table.Entry("with a usage listed twice", func(k *Kubeconfig) {
+			k.Spec.CSR.Usages = []certificatesv1.KeyUsage{certificatesv1.UsageClientAuth, certificatesv1.UsageClientAuth}
+		}, []string{"spec.csr.usages[1]"}),
+
+		table.Entry("with an RSA key size", func(k *Kubeconfig) {
+			k.Spec.CSR.KeySize = 3072
+		}, nil),
+		table.Entry("with a key size of an ECDSA key", func(k *Kubeconfig) {
+			k.Spec.CSR.SignatureAlgorithm = ECDSAWithSHA256
+			k.Spec.CSR.KeySize = 3072
+		}, []string{"spec.csr.keySize"}),
+		table.Entry("with a key size of an Ed25519 key", func(k *Kubeconfig) {
+			k.Spec.CSR.SignatureAlgorithm = PureEd25519
+			k.Spec.CSR.KeySize = 3072
+		}, []string{"spec.csr.keySize"}),
+		table.Entry("with a key size and an existing CSR", func(k *Kubeconfig) {
+			k.Spec.ExistingCSR = &SecretRef{Namespace: "alice", Name: "csr"}
+			k.Spec.CSR.KeySize = 3072
+		}, []string{"spec.csr.keySize"}),
+		table.Entry("with an ECDSA curve", func(k *Kubeconfig) {
+			k.Spec.CSR.SignatureAlgorithm = ECDSAWithSHA512
+			k.Spec.CSR.Curve = ECDSACurveP256
+		}, nil),
+		table.Entry("with a curve of an RSA key", func(k *Kubeconfig) {
+			k.Spec.CSR.Curve = ECDSACurveP256
+		}, []string{"spec.csr.curve"}),
+		table.Entry("with a curve of an Ed25519 key", func(k *Kubeconfig) {
+			k.Spec.CSR.SignatureAlgorithm = PureEd25519
+			k.Spec.CSR.Curve = ECDSACurveP256
+		}, []string{"spec.csr.curve"}),
+		table.Entry("with a curve and an existing CSR", func(k *Kubeconfig) {
+			k.Spec.ExistingCSR = &SecretRef{Namespace: "alice", Name: "csr"}
+			k.Spec.CSR.SignatureAlgorithm = ECDSAWithSHA256
+			k.Spec.CSR.Curve = ECDSACurveP256
+		}, []string{"spec.csr.curve"}),
+
+		table.Entry("with the PKCS1 encoding of an RSA key", func(k *Kubeconfig) {
+			k.Spec.CSR.KeyEncoding = KeyEncodingPKCS1
+		}, nil),
+		table.Entry("with the PKCS1 encoding of an ECDSA key", func(k *Kubeconfig) {
+			k.Spec.CSR.SignatureAlgorithm = ECDSAWithSHA256
+			k.Spec.CSR.KeyEncoding = KeyEncodingPKCS1
+		}, []string{"spec.csr.keyEncoding"}),
+		table.Entry("with the SEC1 encoding of an RSA key", func(k *Kubeconfig) {
+			k.Spec.CSR.KeyEncoding = KeyEncodingSEC1
+		}, []string{"spec.csr.keyEncoding"}),
+		table.Entry("with the PKCS8 encoding of an Ed25519 key", func(k *Kubeconfig) {
+			k.Spec.CSR.SignatureAlgorithm = PureEd25519
+			k.Spec.CSR.KeyEncoding = KeyEncodingPKCS8
+		}, nil),
+		table.Entry("with a key encoding and an existing CSR", func(k *Kubeconfig) {
+			k.Spec.ExistingCSR = &SecretRef{Namespace: "alice", Name: "csr"}
+			k.Spec.CSR.KeyEncoding = KeyEncodingPKCS8
+		}, []string{"spec.csr.keyEncoding"}),
+		table.Entry("with a passphrase in the target namespace", func(k *Kubeconfig) {
+			k.Spec.Target = &KubeconfigTarget{Namespace: "alice"}
+			k.Spec.CSR.PrivateKeyPassphrase = &SecretKeyRef{Namespace: "alice", Name: "passphrase", Key: "passphrase"}
+		}, nil),
+		table.Entry("with a passphrase without target", func(k *Kubeconfig) {
+			k.Spec.CSR.PrivateKeyPassphrase = &SecretKeyRef{Namespace: "alice", Name: "passphrase"}
+		}, []string{"spec.csr.privateKeyPassphrase"}),
+		table.Entry("with a passphrase in another namespace", func(k *Kubeconfig) {
+			k.Spec.Target = &KubeconfigTarget{Namespace: "alice"}
+			k.Spec.CSR.PrivateKeyPassphrase = &SecretKeyRef{Namespace: "kube-system", Name: "passphrase"}
+		}, []string{"spec.csr.privateKeyPassphrase.namespace"}),
+		table.Entry("with a passphrase and an existing CSR", func(k *Kubeconfig) {
+			k.Spec.ExistingCSR = &SecretRef{Namespace: "alice", Name: "csr"}
+			k.Spec.Target = &KubeconfigTarget{Namespace: "alice"}
+			k.Spec.CSR.PrivateKeyPassphrase = &SecretKeyRef{Namespace: "alice", Name: "passphrase"}
+		}, []string{"spec.csr.privateKeyPassphrase"}),
+	)
+
+	It("defaults the secret name and key of the target", func() {
+		kubeconfig := newTestKubeconfig("defaults")
+		kubeconfig.Spec.Target = &KubeconfigTarget{Namespace: "alice"}
+		Expect(k8sClient.Create(ctx, kubeconfig)).To(Succeed())
+		Expect(kubeconfig.Spec.Target).To(Equal(&KubeconfigTarget{Namespace: "alice", SecretName: kubeconfig.Name, Key: "kubeconfig"}))
+	})
+
+	It("records the user that created the kubeconfig", func() {
+		kubeconfig := newTestKubeconfig("requester")
+		kubeconfig.Annotations = map[string]string{RequestedByAnnotationKey: "mallory"}
+		Expect(clientAs("alice").Create(ctx, kubeconfig)).To(Succeed())
+		Expect(kubeconfig.Annotations).To(HaveKeyWithValue(RequestedByAnnotationKey, "alice"))
+
+		kubeconfig.Annotations[RequestedByAnnotationKey] = "mallory"
+		expectRejected(k8sClient.Update(ctx, kubeconfig), "requested-by")
+	})
+
+	It("defaults the API group of bound roles", func() {
+		kubeconfig := newTestKubeconfig("defaults")
+		kubeconfig.Spec.Bindings = []KubeconfigBinding{{Namespace: "dev", RoleRef: rbacv1.RoleRef{Kind: "ClusterRole", Name: "edit"}}}
+		Expect(k8sClient.Create(ctx, kubeconfig)).To(Succeed())
+		Expect(kubeconfig.Spec.Bindings[0].RoleRef.APIGroup).To(Equal(rbacv1.GroupName))
+	})
+
+	It("defaults the token lifetime in the ServiceAccountToken auth mode", func() {
+		kubeconfig := newTestKubeconfig("defaults")
+		kubeconfig.Spec.AuthMode = AuthModeServiceAccountToken
+		kubeconfig.Spec.CSR = nil
+		Expect(k8sClient.Create(ctx, kubeconfig)).To(Succeed())
+		Expect(kubeconfig.Spec.ServiceAccountToken).NotTo(BeNil())
+		Expect(kubeconfig.Spec.ServiceAccountToken.Duration).To(Equal(duration(DefaultTokenDuration)))
+	})
+
+	table.DescribeTable("defaults the signature algorithm without persisting key parameters",
+		func(csr *CertificateSigningRequest, algorithm SignatureAlgorithm) {
+			kubeconfig := newTestKubeconfig("defaults")
+			kubeconfig.Spec.CSR = csr
+			Expect(k8sClient.Create(ctx, kubeconfig)).To(Succeed())
+			Expect(kubeconfig.Spec.CSR.SignatureAlgorithm).To(Equal(algorithm))
+			// key parameters follow later changes of the signature algorithm, which persisted defaults would not
+			Expect(kubeconfig.Spec.CSR.KeySize).To(BeZero())
+			Expect(kubeconfig.Spec.CSR.Curve).To(BeEmpty())
+		},
+		table.Entry("without CSR parameters", nil, SHA256WithRSA),
+		table.Entry("without signature algorithm", &CertificateSigningRequest{}, SHA256WithRSA),
+		table.Entry("of an RSA key", &CertificateSigningRequest{SignatureAlgorithm: SHA512WithRSA}, SHA512WithRSA),
+		table.Entry("of an ECDSA key", &CertificateSigningRequest{SignatureAlgorithm: ECDSAWithSHA384}, ECDSAWithSHA384),
+	)
+
+	table.DescribeTable("enforces the configured certificate lifetimes",
+		func(opts WebhookOptions, d time.Duration, valid bool) {
+			opts.MinCertificateDuration = MinimumCertificateDuration
+			validator := &kubeconfigValidator{client: k8sClient, opts: opts}
+			kubeconfig := newTestKubeconfig("validation")
+			kubeconfig.Spec.CSR.Duration = duration(d)
+			errs := validator.validateSpec(kubeconfig)
+			if valid {
+				Expect(errs).To(BeEmpty())
+				return
+			}
+			Expect(errs).To(HaveLen(1))
+			Expect(errs[0].Field).To(Equal("spec.csr.duration"))
+		},
+		table.Entry("below the configured maximum", WebhookOptions{MaxCertificateDuration: 24 * time.Hour}, 12*time.Hour, true),
+		table.Entry("above the configured maximum", WebhookOptions{MaxCertificateDuration: 24 * time.Hour}, 48*time.Hour, false),
+		table.Entry("overflowing expirationSeconds below the configured maximum",
+			WebhookOptions{MaxCertificateDuration: 2 * MaximumCertificateDuration}, MaximumCertificateDuration+time.Second, false),
+	)
+
+	It("allows further usages when the operator's default signer is not kube-apiserver-client", func() {
+		validator := &kubeconfigValidator{client: k8sClient, opts: WebhookOptions{MinCertificateDuration: MinimumCertificateDuration, SignerName: "example.com/client"}}
+		kubeconfig := newTestKubeconfig("validation")
+		kubeconfig.Spec.CSR.Usages = []certificatesv1.KeyUsage{certificatesv1.UsageClientAuth, certificatesv1.UsageSigning}
+		Expect(validator.validateSpec(kubeconfig)).To(BeEmpty())
+	})
+
+	It("lets kubeconfigs created under other settings release their finalizer", func() {
+		validator := &kubeconfigValidator{client: k8sClient, opts: WebhookOptions{MinCertificateDuration: MinimumCertificateDuration, MaxCertificateDuration: time.Hour}}
+		old := newTestKubeconfig("deletion")
+		old.Spec.CSR.Duration = duration(24 * time.Hour)
+		old.Finalizers = []string{"kubeconfig.k8s.zoomoid.dev/cleanup"}
+		updated := old.DeepCopy()
+		updated.Finalizers = nil
+		Expect(validator.ValidateUpdate(ctx, old, updated)).NotTo(Succeed())
+
+		now := metav1.Now()
+		old.DeletionTimestamp = &now
+		updated.DeletionTimestamp = &now
+		Expect(validator.ValidateUpdate(ctx, old, updated)).To(Succeed())
+	})
+
+	table.DescribeTable("validates updates",
+		func(create func(kubeconfig *Kubeconfig), update func(kubeconfig *Kubeconfig), field string) {
+			kubeconfig := newTestKubeconfig("update")
+			create(kubeconfig)
+			Expect(k8sClient.Create(ctx, kubeconfig)).To(Succeed())
+			update(kubeconfig)
+			err := k8sClient.Update(ctx, kubeconfig)
+			if field == "" {
+				Expect(err).NotTo(HaveOccurred())
+				return
+			}
+			expectRejected(err, field)
+		},
+		table.Entry("of the role reference", func(k *Kubeconfig) {}, func(k *Kubeconfig) {
+			k.Spec.RoleRef.Name = "edit"
+		}, ""),
+		table.Entry("of the CSR parameters, which rotates the credentials", func(k *Kubeconfig) {}, func(k *Kubeconfig) {
+			k.Spec.CSR.SignatureAlgorithm = ECDSAWithSHA256
+		}, ""),
+		table.Entry("adding an existing CSR", func(k *Kubeconfig) {}, func(k *Kubeconfig) {
+			k.Spec.ExistingCSR = &SecretRef{Namespace: "alice", Name: "csr"}
+		}, "spec.existingCSR"),
+		table.Entry("of the CSR parameters of a kubeconfig with an existing CSR", func(k *Kubeconfig) {
+			k.Spec.ExistingCSR = &SecretRef{Namespace: "alice", Name: "csr"}
+		}, func(k *Kubeconfig) {
+			k.Spec.CSR.SignatureAlgorithm = ECDSAWithSHA256
+		}, "spec.csr"),
+		table.Entry("of the key size", func(k *Kubeconfig) {}, func(k *Kubeconfig) {
+			k.Spec.CSR.KeySize = 2048
+		}, ""),
+		table.Entry("of the signature algorithm, which invalidates the key size", func(k *Kubeconfig) {
+			k.Spec.CSR.KeySize = 2048
+		}, func(k *Kubeconfig) {
+			k.Spec.CSR.SignatureAlgorithm = ECDSAWithSHA256
+		}, "spec.csr.keySize"),
+		table.Entry("of the auth mode", func(k *Kubeconfig) {}, func(k *Kubeconfig) {
+			k.Spec.AuthMode = AuthModeServiceAccountToken
+		}, "spec.authMode"),
+	)
+
+	Context("revocation", func() {
+		// revoke records a certificate of the username in the RevocationList that expires at notAfter
+		revoke := func(username string, notAfter time.Time) {
+			entry := RevocationEntry{Username: username, RevokedAt: metav1.Now(), NotAfter: metav1.NewTime(notAfter)}
+			list := &RevocationList{}
+			err := k8sClient.Get(ctx, client.ObjectKey{Name: RevocationListName}, list)
+			if apierrors.IsNotFound(err) {
+				list = &RevocationList{
+					ObjectMeta: metav1.ObjectMeta{Name: RevocationListName},
+					Spec:       RevocationListSpec{Entries: []RevocationEntry{entry}},
+				}
+				Expect(k8sClient.Create(ctx, list)).To(Succeed())
+				return
+			}
+			Expect(err).NotTo(HaveOccurred())
+			list.Spec.Entries = append(list.Spec.Entries, entry)
+			Expect(k8sClient.Update(ctx, list)).To(Succeed())
+		}
+
+		It("refuses to undo a revocation", func() {
+			kubeconfig := newTestKubeconfig("revocation")
+			Expect(k8sClient.Create(ctx, kubeconfig)).To(Succeed())
+			kubeconfig.Spec.Revoked = true
+			Expect(k8sClient.Update(ctx, kubeconfig)).To(Succeed())
+			kubeconfig.Spec.Revoked = false
+			expectRejected(k8sClient.Update(ctx, kubeconfig), "spec.revoked")
+		})
+
+		It("refuses usernames of revoked certificates until they expire", func() {
+			revoked := newTestKubeconfig("revocation")
+			revoke(revoked.Spec.Username, time.Now().Add(time.Hour))
+			err := k8sClient.Create(ctx, revoked)
+			expectRejected(err)
+			Expect(errorFields(err)).To(Equal([]string{"spec.username"}))
+
+			By("refusing to rename a kubeconfig to the username")
+			renamed := newTestKubeconfig("revocation")
+			Expect(k8sClient.Create(ctx, renamed)).To(Succeed())
+			renamed.Spec.Username = revoked.Spec.Username
+			expectRejected(k8sClient.Update(ctx, renamed), "spec.username")
+
+			By("accepting usernames of expired certificates")
+			expired := newTestKubeconfig("revocation")
+			revoke(expired.Spec.Username, time.Now().Add(-time.Hour))
+			Expect(k8sClient.Create(ctx, expired)).To(Succeed())
+		})
+	})
+})
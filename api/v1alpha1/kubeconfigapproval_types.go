@@ -0,0 +1,91 @@
+/*
+Copyright 2022 zoomoid.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// KubeconfigApprovalSpec records the approval of a kubeconfig's pending CSR by a single user, or of the current
+// spec of a kubeconfig whose credentials are issued without a CSR
+type KubeconfigApprovalSpec struct {
+	// Kubeconfig is the name of the Kubeconfig whose pending CSR or current spec is approved
+	Kubeconfig string `json:"kubeconfig"`
+
+	// Comment is an optional justification of the approval
+	// +optional
+	Comment string `json:"comment,omitempty"`
+
+	// CSRName is the name of the approved CSR. It is set by the admission webhook to the kubeconfig's current CSR
+	// +optional
+	CSRName string `json:"csrName,omitempty"`
+
+	// CSRUID is the UID of the approved CSR. It is set by the admission webhook to the kubeconfig's current CSR,
+	// such that approvals do not carry over to CSRs requested later, e.g., for renewals
+	// +optional
+	CSRUID types.UID `json:"csrUID,omitempty"`
+
+	// Generation is the generation of a kubeconfig in the ServiceAccountToken or OIDC auth mode, which request no
+	// CSR, at the time of the approval. It is set by the admission webhook
+	// +optional
+	Generation int64 `json:"generation,omitempty"`
+
+	// SpecHash is the hash of the approved credential parameters, rotation token, username, groups and roles of a
+	// kubeconfig in the ServiceAccountToken or OIDC auth mode. It is set by the admission webhook, such that approvals
+	// do not carry over to changes of what the kubeconfig grants, while changes to, e.g., its target keep them valid
+	// +optional
+	SpecHash string `json:"specHash,omitempty"`
+
+	// Approver is the name of the user who created the approval. It is set by the admission webhook from the
+	// request's user info and cannot be chosen by the client
+	// +optional
+	Approver string `json:"approver,omitempty"`
+
+	// ApproverGroups are the groups of the user who created the approval, set by the admission webhook
+	// +optional
+	ApproverGroups []string `json:"approverGroups,omitempty"`
+}
+
+// KubeconfigApproval is the Schema for the kubeconfigapprovals API
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster
+// +kubebuilder:printcolumn:name="Kubeconfig",type=string,JSONPath=`.spec.kubeconfig`
+// +kubebuilder:printcolumn:name="Approver",type=string,JSONPath=`.spec.approver`
+// +kubebuilder:printcolumn:name="CSR",type=string,JSONPath=`.spec.csrName`,priority=1
+// +kubebuilder:printcolumn:name="Generation",type=integer,JSONPath=`.spec.generation`,priority=1
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+type KubeconfigApproval struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec KubeconfigApprovalSpec `json:"spec"`
+}
+
+//+kubebuilder:object:root=true
+
+// KubeconfigApprovalList contains a list of KubeconfigApproval
+type KubeconfigApprovalList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []KubeconfigApproval `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&KubeconfigApproval{}, &KubeconfigApprovalList{})
+}
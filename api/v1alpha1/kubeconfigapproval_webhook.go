@@ -0,0 +1,176 @@
+/*
+Copyright 2022 zoomoid.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	certificatesv1 "k8s.io/api/certificates/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/runtime/inject"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func (r *KubeconfigApproval) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		WithDefaulter(&kubeconfigApprovalDefaulter{
+			client: mgr.GetClient(),
+		}).
+		WithValidator(&kubeconfigApprovalValidator{
+			client: mgr.GetClient(),
+		}).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/mutate-kubeconfig-k8s-zoomoid-dev-v1alpha1-kubeconfigapproval,mutating=true,failurePolicy=fail,sideEffects=None,groups=kubeconfig.k8s.zoomoid.dev,resources=kubeconfigapprovals,verbs=create,versions=v1alpha1,name=mkubeconfigapproval.kb.io,admissionReviewVersions=v1
+
+type kubeconfigApprovalDefaulter struct {
+	client client.Client
+}
+
+var _ admission.CustomDefaulter = &kubeconfigApprovalDefaulter{}
+var _ inject.Client = &kubeconfigApprovalDefaulter{}
+
+// InjectClient injects the client into the kubeconfigApprovalDefaulter
+func (a *kubeconfigApprovalDefaulter) InjectClient(c client.Client) error {
+	a.client = c
+	return nil
+}
+
+// Default records the identity of the requesting user and the kubeconfig's current CSR, or the hash of its current
+// spec if it requests no CSR, in a new approval, overwriting anything the client put there
+func (r *kubeconfigApprovalDefaulter) Default(ctx context.Context, obj runtime.Object) error {
+	approval, _ := obj.(*KubeconfigApproval)
+	req, err := admission.RequestFromContext(ctx)
+	if err != nil {
+		return err
+	}
+	if req.Operation != admissionv1.Create {
+		return nil
+	}
+	approval.Spec.Approver = req.UserInfo.Username
+	approval.Spec.ApproverGroups = req.UserInfo.Groups
+	approval.Spec.CSRName = ""
+	approval.Spec.CSRUID = ""
+	approval.Spec.Generation = 0
+	approval.Spec.SpecHash = ""
+
+	kubeconfig := &Kubeconfig{}
+	err = r.client.Get(ctx, client.ObjectKey{Name: approval.Spec.Kubeconfig}, kubeconfig)
+	if err != nil {
+		// the validator refuses approvals of missing kubeconfigs
+		return client.IgnoreNotFound(err)
+	}
+	if kubeconfig.UsesClientCertificate() {
+		csr := &certificatesv1.CertificateSigningRequest{}
+		err = r.client.Get(ctx, client.ObjectKey{Name: kubeconfig.Status.Csr.Name}, csr)
+		if err == nil && metav1.IsControlledBy(csr, kubeconfig) {
+			approval.Spec.CSRName = csr.Name
+			approval.Spec.CSRUID = csr.UID
+		}
+	} else {
+		approval.Spec.Generation = kubeconfig.Generation
+		approval.Spec.SpecHash = kubeconfig.ApprovalSpecHash()
+	}
+	// approvals are removed alongside their kubeconfig
+	approval.OwnerReferences = []metav1.OwnerReference{
+		{
+			APIVersion: GroupVersion.String(),
+			Kind:       "Kubeconfig",
+			Name:       kubeconfig.Name,
+			UID:        kubeconfig.UID,
+		},
+	}
+	return nil
+}
+
+//+kubebuilder:webhook:path=/validate-kubeconfig-k8s-zoomoid-dev-v1alpha1-kubeconfigapproval,mutating=false,failurePolicy=fail,sideEffects=None,groups=kubeconfig.k8s.zoomoid.dev,resources=kubeconfigapprovals,verbs=create;update,versions=v1alpha1,name=vkubeconfigapproval.kb.io,admissionReviewVersions=v1
+
+type kubeconfigApprovalValidator struct {
+	client client.Client
+}
+
+var _ admission.CustomValidator = &kubeconfigApprovalValidator{}
+var _ inject.Client = &kubeconfigApprovalValidator{}
+
+// InjectClient injects the client into the kubeconfigApprovalValidator
+func (a *kubeconfigApprovalValidator) InjectClient(c client.Client) error {
+	a.client = c
+	return nil
+}
+
+// ValidateCreate refuses approvals of kubeconfigs without a pending CSR or spec hash, and approvals by the
+// kubeconfig's own user or the user that created it
+func (r *kubeconfigApprovalValidator) ValidateCreate(ctx context.Context, obj runtime.Object) error {
+	approval, _ := obj.(*KubeconfigApproval)
+	specPath := field.NewPath("spec")
+	var allErrs field.ErrorList
+
+	kubeconfig := &Kubeconfig{}
+	err := r.client.Get(ctx, client.ObjectKey{Name: approval.Spec.Kubeconfig}, kubeconfig)
+	if apierrors.IsNotFound(err) {
+		allErrs = append(allErrs, field.NotFound(specPath.Child("kubeconfig"), approval.Spec.Kubeconfig))
+	} else if err != nil {
+		allErrs = append(allErrs, field.InternalError(specPath.Child("kubeconfig"), err))
+	} else {
+		if approval.Spec.CSRUID == "" && approval.Spec.SpecHash == "" {
+			allErrs = append(allErrs, field.Invalid(specPath.Child("kubeconfig"), approval.Spec.Kubeconfig, "kubeconfig has no pending CSR"))
+		}
+		if kubeconfig.IsRequester(approval.Spec.Approver) {
+			allErrs = append(allErrs, field.Forbidden(specPath.Child("approver"), "users cannot approve their own kubeconfig or kubeconfigs they created"))
+		}
+	}
+	if approval.Spec.Approver == "" {
+		allErrs = append(allErrs, field.Required(specPath.Child("approver"), "approver could not be determined from the request"))
+	}
+
+	if len(allErrs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(schema.GroupKind{
+		Group: "kubeconfig.k8s.zoomoid.dev",
+		Kind:  "KubeconfigApproval",
+	}, approval.Name, allErrs)
+}
+
+// ValidateUpdate refuses any change to the spec, since approvals are a record of a user's decision
+func (r *kubeconfigApprovalValidator) ValidateUpdate(ctx context.Context, old runtime.Object, new runtime.Object) error {
+	oldApproval, _ := old.(*KubeconfigApproval)
+	newApproval, _ := new.(*KubeconfigApproval)
+	if reflect.DeepEqual(oldApproval.Spec, newApproval.Spec) {
+		return nil
+	}
+	return apierrors.NewForbidden(schema.GroupResource{
+		Group:    "kubeconfig.k8s.zoomoid.dev",
+		Resource: "KubeconfigApproval",
+	}, oldApproval.Name, fmt.Errorf(".spec is immutable"))
+}
+
+// ValidateDelete implements webhook.Validator so a webhook will be registered for the type
+func (r *kubeconfigApprovalValidator) ValidateDelete(ctx context.Context, obj runtime.Object) error {
+	return nil
+}
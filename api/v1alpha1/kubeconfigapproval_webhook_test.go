@@ -0,0 +1,93 @@
+/*
+Copyright 2022 zoomoid.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("KubeconfigApproval webhook", func() {
+	It("binds approvals of kubeconfigs without a CSR to the approved spec", func() {
+		kubeconfig := newTestKubeconfig("approval")
+		kubeconfig.Spec.AuthMode = AuthModeServiceAccountToken
+		kubeconfig.Spec.CSR = nil
+		Expect(k8sClient.Create(ctx, kubeconfig)).To(Succeed())
+
+		approval := &KubeconfigApproval{
+			ObjectMeta: metav1.ObjectMeta{Name: uniqueName("approval")},
+			Spec: KubeconfigApprovalSpec{
+				Kubeconfig: kubeconfig.Name,
+				Approver:   "mallory",
+				Generation: 42,
+				SpecHash:   "forged",
+			},
+		}
+		Expect(clientAs("alice").Create(ctx, approval)).To(Succeed())
+		Expect(approval.Spec.Approver).To(Equal("alice"))
+		Expect(approval.Spec.Generation).To(Equal(kubeconfig.Generation))
+		Expect(approval.Spec.SpecHash).To(Equal(kubeconfig.ApprovalSpecHash()))
+		Expect(approval.OwnerReferences).To(HaveLen(1))
+		Expect(approval.OwnerReferences[0].UID).To(Equal(kubeconfig.UID))
+
+		By("keeping the hash when the target changes")
+		kubeconfig.Spec.Target = &KubeconfigTarget{Namespace: "default", SecretName: kubeconfig.Name, Key: "config"}
+		Expect(k8sClient.Update(ctx, kubeconfig)).To(Succeed())
+		Expect(kubeconfig.ApprovalSpecHash()).To(Equal(approval.Spec.SpecHash))
+
+		By("changing the hash when the bound roles change")
+		kubeconfig.Spec.RoleRef = &rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: "edit"}
+		Expect(k8sClient.Update(ctx, kubeconfig)).To(Succeed())
+		Expect(kubeconfig.ApprovalSpecHash()).NotTo(Equal(approval.Spec.SpecHash))
+
+		By("refusing changes to the approval")
+		approval.Spec.SpecHash = kubeconfig.ApprovalSpecHash()
+		expectRejected(k8sClient.Update(ctx, approval), ".spec is immutable")
+	})
+
+	It("refuses approvals of kubeconfigs without a pending CSR", func() {
+		kubeconfig := newTestKubeconfig("approval")
+		Expect(k8sClient.Create(ctx, kubeconfig)).To(Succeed())
+		approval := &KubeconfigApproval{
+			ObjectMeta: metav1.ObjectMeta{Name: uniqueName("approval")},
+			Spec:       KubeconfigApprovalSpec{Kubeconfig: kubeconfig.Name},
+		}
+		expectRejected(clientAs("alice").Create(ctx, approval), "spec.kubeconfig")
+	})
+
+	It("refuses approvals by the kubeconfig's requesters", func() {
+		kubeconfig := newTestKubeconfig("approval")
+		kubeconfig.Spec.AuthMode = AuthModeServiceAccountToken
+		kubeconfig.Spec.CSR = nil
+		Expect(clientAs("bob").Create(ctx, kubeconfig)).To(Succeed())
+
+		By("refusing approvals by the user that created the kubeconfig")
+		approval := &KubeconfigApproval{
+			ObjectMeta: metav1.ObjectMeta{Name: uniqueName("approval")},
+			Spec:       KubeconfigApprovalSpec{Kubeconfig: kubeconfig.Name},
+		}
+		expectRejected(clientAs("bob").Create(ctx, approval), "spec.approver")
+
+		By("refusing approvals by the kubeconfig's own user")
+		expectRejected(clientAs(kubeconfig.Spec.Username).Create(ctx, approval), "spec.approver")
+
+		Expect(clientAs("carol").Create(ctx, approval)).To(Succeed())
+	})
+})
@@ -0,0 +1,80 @@
+/*
+Copyright 2022 zoomoid.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// KubeconfigGroupSpec defines the desired state of KubeconfigGroup
+type KubeconfigGroupSpec struct {
+	// Group is the name of the group as it appears in the organizations of the certificates of kubeconfigs
+	// listing it in their .spec.groups. Defaults to the name of the KubeconfigGroup
+	// +optional
+	Group string `json:"group,omitempty"`
+
+	// Bindings are the role bindings for the group. Bindings with a namespace create RoleBindings in that namespace
+	// and may reference Roles or ClusterRoles, bindings without a namespace create ClusterRoleBindings
+	// +optional
+	Bindings []KubeconfigBinding `json:"bindings,omitempty"`
+}
+
+// KubeconfigGroupStatus defines the observed state of KubeconfigGroup
+type KubeconfigGroupStatus struct {
+	// Condititions are metav1 conditions that track the state of the group's role bindings
+	// +listType=map
+	// +listMapKey=type
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// KubeconfigGroup is the Schema for the kubeconfiggroups API
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Group",type=string,JSONPath=`.spec.group`
+// +kubebuilder:printcolumn:name="Synced",type=string,JSONPath=`.status.conditions[?(@.type=="RBACSynced")].status`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+type KubeconfigGroup struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   KubeconfigGroupSpec   `json:"spec"`
+	Status KubeconfigGroupStatus `json:"status,omitempty"`
+}
+
+// GroupName returns the name of the group as it appears in certificates
+func (g *KubeconfigGroup) GroupName() string {
+	if g.Spec.Group != "" {
+		return g.Spec.Group
+	}
+	return g.Name
+}
+
+//+kubebuilder:object:root=true
+
+// KubeconfigGroupList contains a list of KubeconfigGroup
+type KubeconfigGroupList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []KubeconfigGroup `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&KubeconfigGroup{}, &KubeconfigGroupList{})
+}
@@ -0,0 +1,79 @@
+/*
+Copyright 2022 zoomoid.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func (r *KubeconfigGroup) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		WithValidator(&kubeconfigGroupValidator{}).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/validate-kubeconfig-k8s-zoomoid-dev-v1alpha1-kubeconfiggroup,mutating=false,failurePolicy=fail,sideEffects=None,groups=kubeconfig.k8s.zoomoid.dev,resources=kubeconfiggroups,verbs=create;update,versions=v1alpha1,name=vkubeconfiggroup.kb.io,admissionReviewVersions=v1
+
+type kubeconfigGroupValidator struct{}
+
+var _ admission.CustomValidator = &kubeconfigGroupValidator{}
+
+// ValidateCreate refuses groups with bindings that cannot be created
+func (r *kubeconfigGroupValidator) ValidateCreate(ctx context.Context, obj runtime.Object) error {
+	group, _ := obj.(*KubeconfigGroup)
+	return r.validate(group)
+}
+
+// ValidateUpdate refuses groups with bindings that cannot be created
+func (r *kubeconfigGroupValidator) ValidateUpdate(ctx context.Context, old runtime.Object, new runtime.Object) error {
+	group, _ := new.(*KubeconfigGroup)
+	return r.validate(group)
+}
+
+// ValidateDelete implements webhook.Validator so a webhook will be registered for the type
+func (r *kubeconfigGroupValidator) ValidateDelete(ctx context.Context, obj runtime.Object) error {
+	return nil
+}
+
+// validate checks the group's bindings the same way as the bindings of kubeconfigs. Groups are not defaulted, so
+// role refs without an API group are checked as the controller binds them, i.e., in rbac.authorization.k8s.io
+func (r *kubeconfigGroupValidator) validate(group *KubeconfigGroup) error {
+	bindings := make([]KubeconfigBinding, len(group.Spec.Bindings))
+	for i, binding := range group.Spec.Bindings {
+		if binding.RoleRef.APIGroup == "" {
+			binding.RoleRef.APIGroup = rbacv1.GroupName
+		}
+		bindings[i] = binding
+	}
+	allErrs := validateBindings(bindings, field.NewPath("spec").Child("bindings"))
+	if len(allErrs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(schema.GroupKind{
+		Group: "kubeconfig.k8s.zoomoid.dev",
+		Kind:  "KubeconfigGroup",
+	}, group.Name, allErrs)
+}
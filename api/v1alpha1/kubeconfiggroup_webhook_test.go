@@ -0,0 +1,114 @@
+/*
+Copyright 2022 zoomoid.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var _ = Describe("KubeconfigGroup webhook", func() {
+	table.DescribeTable("validates the group's bindings",
+		func(bindings []KubeconfigBinding, valid bool) {
+			group := &KubeconfigGroup{
+				ObjectMeta: metav1.ObjectMeta{Name: uniqueName("group")},
+				Spec:       KubeconfigGroupSpec{Bindings: bindings},
+			}
+			err := k8sClient.Create(ctx, group)
+			if !valid {
+				expectRejected(err, "spec.bindings")
+				return
+			}
+			Expect(err).NotTo(HaveOccurred())
+
+			By("leaving the bindings as they were submitted")
+			created := &KubeconfigGroup{}
+			Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(group), created)).To(Succeed())
+			Expect(created.Spec.Bindings).To(Equal(group.Spec.Bindings))
+		},
+		table.Entry("without bindings", nil, true),
+		table.Entry("of a ClusterRole without API group",
+			[]KubeconfigBinding{{RoleRef: rbacv1.RoleRef{Kind: "ClusterRole", Name: "view"}}}, true),
+		table.Entry("of a Role in a namespace",
+			[]KubeconfigBinding{{Namespace: "dev", RoleRef: rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "Role", Name: "edit"}}}, true),
+		table.Entry("of a Role without namespace",
+			[]KubeconfigBinding{{RoleRef: rbacv1.RoleRef{Kind: "Role", Name: "edit"}}}, false),
+		table.Entry("of an unknown API group",
+			[]KubeconfigBinding{{RoleRef: rbacv1.RoleRef{APIGroup: "example.com", Kind: "ClusterRole", Name: "view"}}}, false),
+		table.Entry("of an unknown kind",
+			[]KubeconfigBinding{{RoleRef: rbacv1.RoleRef{Kind: "User", Name: "view"}}}, false),
+		table.Entry("without role name",
+			[]KubeconfigBinding{{RoleRef: rbacv1.RoleRef{Kind: "ClusterRole"}}}, false),
+		table.Entry("in an invalid namespace",
+			[]KubeconfigBinding{{Namespace: "Dev", RoleRef: rbacv1.RoleRef{Kind: "ClusterRole", Name: "view"}}}, false),
+		table.Entry("duplicated by defaulting the API group", []KubeconfigBinding{
+			{RoleRef: rbacv1.RoleRef{Kind: "ClusterRole", Name: "view"}},
+			{RoleRef: rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: "view"}},
+		}, false),
+	)
+
+	It("validates the bindings on updates", func() {
+		group := &KubeconfigGroup{
+			ObjectMeta: metav1.ObjectMeta{Name: uniqueName("group")},
+			Spec: KubeconfigGroupSpec{Bindings: []KubeconfigBinding{
+				{RoleRef: rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: "view"}},
+			}},
+		}
+		Expect(k8sClient.Create(ctx, group)).To(Succeed())
+		group.Spec.Bindings[0].RoleRef.Kind = "Role"
+		expectRejected(k8sClient.Update(ctx, group), "spec.bindings[0]")
+	})
+})
+
+var _ = Describe("Kubeconfig groups", func() {
+	It("accepts groups of kubeconfigs with client certificates", func() {
+		kubeconfig := newTestKubeconfig("groups")
+		kubeconfig.Spec.Groups = []string{"dev", "ops"}
+		Expect(k8sClient.Create(ctx, kubeconfig)).To(Succeed())
+	})
+
+	It("refuses duplicate groups", func() {
+		kubeconfig := newTestKubeconfig("groups")
+		kubeconfig.Spec.Groups = []string{"dev", "dev"}
+		err := k8sClient.Create(ctx, kubeconfig)
+		expectRejected(err)
+		Expect(errorFields(err)).To(Equal([]string{"spec.groups[1]"}))
+	})
+
+	It("refuses groups in the ServiceAccountToken auth mode", func() {
+		kubeconfig := newTestKubeconfig("groups")
+		kubeconfig.Spec.AuthMode = AuthModeServiceAccountToken
+		kubeconfig.Spec.CSR = nil
+		kubeconfig.Spec.Groups = []string{"dev"}
+		err := k8sClient.Create(ctx, kubeconfig)
+		expectRejected(err)
+		Expect(errorFields(err)).To(Equal([]string{"spec.groups"}))
+	})
+
+	It("keeps the groups immutable, since they are part of the certificate", func() {
+		kubeconfig := newTestKubeconfig("groups")
+		kubeconfig.Spec.Groups = []string{"dev"}
+		Expect(k8sClient.Create(ctx, kubeconfig)).To(Succeed())
+		kubeconfig.Spec.Groups = []string{"dev", "ops"}
+		expectRejected(k8sClient.Update(ctx, kubeconfig), "spec.groups")
+	})
+})
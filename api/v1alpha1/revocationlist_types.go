@@ -0,0 +1,99 @@
+/*
+Copyright 2022 zoomoid.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// RevocationListName is the name of the singleton RevocationList maintained by the operator
+const RevocationListName = "kubeconfig-operator"
+
+// RevocationListSpec defines the revoked client certificates
+type RevocationListSpec struct {
+	// Entries are the revoked client certificates that are not expired yet
+	// +optional
+	Entries []RevocationEntry `json:"entries,omitempty"`
+}
+
+// RevocationEntry records a revoked client certificate. Since Kubernetes cannot revoke client certificates,
+// the certificate's username must not be granted access again until the certificate expired
+type RevocationEntry struct {
+	// Username is the common name of the revoked certificate
+	Username string `json:"username"`
+
+	// Kubeconfig is the name of the Kubeconfig the certificate was issued for
+	// +optional
+	Kubeconfig string `json:"kubeconfig,omitempty"`
+
+	// SerialNumber is the revoked certificate's serial number in hexadecimal notation
+	// +optional
+	SerialNumber string `json:"serialNumber,omitempty"`
+
+	// Fingerprint is the SHA-256 fingerprint of the revoked certificate
+	// +optional
+	Fingerprint string `json:"fingerprint,omitempty"`
+
+	// RevokedAt is the point in time at which the certificate was revoked
+	RevokedAt metav1.Time `json:"revokedAt"`
+
+	// NotAfter is the end of the revoked certificate's validity period, after which the entry is removed
+	NotAfter metav1.Time `json:"notAfter"`
+}
+
+// RevocationList is the Schema for the cluster-wide list of revoked kubeconfig client certificates
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+type RevocationList struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec RevocationListSpec `json:"spec,omitempty"`
+}
+
+// RevokedUsername returns the entry that revokes the given username at the given point in time with
+// the latest expiry, or nil if the username is not revoked
+func (l *RevocationList) RevokedUsername(username string, now time.Time) *RevocationEntry {
+	var revoked *RevocationEntry
+	for i := range l.Spec.Entries {
+		entry := &l.Spec.Entries[i]
+		if entry.Username != username || !now.Before(entry.NotAfter.Time) {
+			continue
+		}
+		if revoked == nil || entry.NotAfter.After(revoked.NotAfter.Time) {
+			revoked = entry
+		}
+	}
+	return revoked
+}
+
+//+kubebuilder:object:root=true
+
+// RevocationListList contains a list of RevocationList
+type RevocationListList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []RevocationList `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&RevocationList{}, &RevocationListList{})
+}
@@ -102,7 +102,13 @@ var _ = BeforeSuite(func() {
 	})
 	Expect(err).NotTo(HaveOccurred())
 
-	err = (&Kubeconfig{}).SetupWebhookWithManager(mgr)
+	err = (&Kubeconfig{}).SetupWebhookWithManager(mgr, WebhookOptions{})
+	Expect(err).NotTo(HaveOccurred())
+
+	err = (&KubeconfigApproval{}).SetupWebhookWithManager(mgr)
+	Expect(err).NotTo(HaveOccurred())
+
+	err = (&KubeconfigGroup{}).SetupWebhookWithManager(mgr)
 	Expect(err).NotTo(HaveOccurred())
 
 	//+kubebuilder:scaffold:webhook
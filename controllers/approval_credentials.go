@@ -0,0 +1,179 @@
+/*
+Copyright 2022 zoomoid.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+
+	kubeconfigv1alpha1 "github.com/zoomoid/kubeconfig-operator/api/v1alpha1"
+	"github.com/zoomoid/kubeconfig-operator/controllers/phases"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// credentialsDecision decides whether the credentials of a kubeconfig in the ServiceAccountToken or OIDC auth mode,
+// which request no CSR, may be issued. It follows the CSR controller's decision about CSRs: without approval policies,
+// kubeconfigs with automaticApproval are approved unless they request system:masters, otherwise the policies decide
+// about kubeconfigs with automaticApproval. All other kubeconfigs are approved with KubeconfigApprovals of their
+// current spec, privileged kubeconfigs always by a quorum of users. It also returns the users that approved the
+// current spec
+func (r *KubeconfigReconciler) credentialsDecision(ctx context.Context, kubeconfig *kubeconfigv1alpha1.Kubeconfig) (policyAction, string, []string, error) {
+	policies := &kubeconfigv1alpha1.ApprovalPolicyList{}
+	err := r.List(ctx, policies)
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	var action policyAction
+	var message string
+	if len(policies.Items) == 0 {
+		action, message = automaticApprovalDecision(kubeconfig)
+	} else {
+		roleRefs, err := boundRoleRefs(ctx, r.Client, kubeconfig)
+		if err != nil {
+			return "", "", nil, err
+		}
+		action, message = evaluateCredentialPolicies(policies.Items, kubeconfig, roleRefs)
+		// policies only approve kubeconfigs that opted into automatic approval
+		if action == policyActionApprove && !kubeconfig.Spec.AutoApproveCSR {
+			action, message = policyActionPending, ""
+		}
+	}
+	if action == policyActionDeny {
+		return action, message, nil, nil
+	}
+
+	privileged, err := r.Approval.requiresQuorum(ctx, r.Client, kubeconfig)
+	if err != nil {
+		return "", "", nil, err
+	}
+	approvers, err := specApprovers(ctx, r.Client, kubeconfig)
+	if err != nil {
+		return "", "", nil, err
+	}
+	if action == policyActionApprove && !privileged {
+		return action, message, approvers, nil
+	}
+	required := 1
+	if privileged {
+		required = r.Approval.Quorum
+	}
+	switch {
+	case len(approvers) >= required:
+		action, message = policyActionApprove, fmt.Sprintf("The kubeconfig was approved by %s", strings.Join(approvers, ", "))
+	case privileged:
+		action, message = policyActionPending, fmt.Sprintf("The kubeconfig is privileged and needs to be approved by %d users, approved by %d so far", r.Approval.Quorum, len(approvers))
+	case message == "":
+		action, message = policyActionPending, "The kubeconfig needs to be approved with a KubeconfigApproval"
+	}
+	return action, message, approvers, nil
+}
+
+// automaticApprovalDecision approves kubeconfigs with automaticApproval as long as no ApprovalPolicy exists, except
+// for kubeconfigs requesting system:masters
+func automaticApprovalDecision(kubeconfig *kubeconfigv1alpha1.Kubeconfig) (policyAction, string) {
+	if !kubeconfig.Spec.AutoApproveCSR {
+		return policyActionPending, ""
+	}
+	for _, group := range kubeconfig.Spec.Groups {
+		if group == SystemMastersGroup {
+			return policyActionPending, "Kubeconfigs requesting the system:masters group are never approved automatically"
+		}
+	}
+	return policyActionApprove, "The kubeconfig was auto-approved by the kubeconfig operator"
+}
+
+// reconcileApproval records the decision about issuing the credentials of a kubeconfig in the ServiceAccountToken or
+// OIDC auth mode in its Approved condition. Kubeconfigs that are pending approval or were denied are neither issued
+// credentials nor bound to their roles. It returns true if the reconciliation may continue
+func (r *KubeconfigReconciler) reconcileApproval(ctx context.Context, kubeconfig *kubeconfigv1alpha1.Kubeconfig) (bool, error) {
+	action, message, approvers, err := r.credentialsDecision(ctx, kubeconfig)
+	if err != nil {
+		klog.ErrorS(err, "failed to decide about approval of kubeconfig", "name", kubeconfig.Name)
+		return false, err
+	}
+	klog.V(2).InfoS("Decided about approval of kubeconfig", "name", kubeconfig.Name, "action", action, "message", message)
+
+	status := kubeconfig.Status.DeepCopy()
+	if len(approvers) > 0 && !reflect.DeepEqual(approvers, kubeconfig.Status.Approvers) {
+		r.Recorder.Eventf(kubeconfig, "Normal", "ApprovalRecorded", "Kubeconfig was approved by %s", strings.Join(approvers, ", "))
+	}
+	kubeconfig.Status.Approvers = approvers
+
+	condition := metav1.Condition{
+		Type:               kubeconfigv1alpha1.ConditionTypeApproved,
+		ObservedGeneration: kubeconfig.Generation,
+		Message:            message,
+	}
+	switch action {
+	case policyActionApprove:
+		condition.Status, condition.Reason = metav1.ConditionTrue, "Approved"
+		if meta.SetStatusCondition(&kubeconfig.Status.Conditions, condition) {
+			r.Recorder.Event(kubeconfig, "Normal", "Approved", message)
+		}
+		// the status is persisted together with the issued credentials
+		return true, nil
+	case policyActionDeny:
+		condition.Status, condition.Reason = metav1.ConditionFalse, "ApprovalPolicyViolated"
+		if meta.SetStatusCondition(&kubeconfig.Status.Conditions, condition) {
+			r.Recorder.Event(kubeconfig, "Warning", "Denied", message)
+		}
+		kubeconfig.Status.Status = phases.PhaseDenied
+	default:
+		condition.Status, condition.Reason = metav1.ConditionFalse, "PendingApproval"
+		if meta.SetStatusCondition(&kubeconfig.Status.Conditions, condition) {
+			r.Recorder.Event(kubeconfig, "Normal", "PendingApproval", message)
+		}
+		kubeconfig.Status.Status = phases.PhaseAwaitingApproval
+	}
+	if !equality.Semantic.DeepEqual(status, &kubeconfig.Status) {
+		err = r.Status().Update(ctx, kubeconfig)
+		if err != nil {
+			klog.ErrorS(err, "failed to update kubeconfig status", "name", kubeconfig.Name)
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+// credentialKubeconfigs maps changes of approval policies to all kubeconfigs whose credentials are issued without a
+// CSR, such that the approval of their credentials is decided again
+func (r *KubeconfigReconciler) credentialKubeconfigs(obj client.Object) []reconcile.Request {
+	kubeconfigs := &kubeconfigv1alpha1.KubeconfigList{}
+	err := r.List(context.Background(), kubeconfigs)
+	if err != nil {
+		klog.ErrorS(err, "failed to list kubeconfigs for approval policy", "name", obj.GetName())
+		return nil
+	}
+	var requests []reconcile.Request
+	for i := range kubeconfigs.Items {
+		kubeconfig := &kubeconfigs.Items[i]
+		if kubeconfig.UsesClientCertificate() {
+			continue
+		}
+		requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: kubeconfig.Name}})
+	}
+	return requests
+}
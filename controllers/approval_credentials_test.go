@@ -0,0 +1,175 @@
+/*
+Copyright 2022 zoomoid.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	kubeconfigv1alpha1 "github.com/zoomoid/kubeconfig-operator/api/v1alpha1"
+	"github.com/zoomoid/kubeconfig-operator/controllers/phases"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("Approval of credentials issued without a CSR", func() {
+	clusterAdmin := rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: "cluster-admin"}
+
+	var r *KubeconfigReconciler
+	var kubeconfig *kubeconfigv1alpha1.Kubeconfig
+	var policies []*kubeconfigv1alpha1.ApprovalPolicy
+
+	BeforeEach(func() {
+		r = newTestReconciler()
+		r.Approval = ApprovalOptions{Quorum: 2, PrivilegedClusterRoles: []string{"cluster-admin"}}
+		kubeconfig = newTestKubeconfig("credentials")
+		kubeconfig.Spec.AuthMode = kubeconfigv1alpha1.AuthModeServiceAccountToken
+		kubeconfig.Spec.CSR = nil
+		policies = nil
+	})
+
+	AfterEach(func() {
+		// policies change the decisions about the kubeconfigs of all other specs
+		for _, policy := range policies {
+			Expect(k8sClient.Delete(ctx, policy)).To(Succeed())
+		}
+	})
+
+	// createPolicy creates an approval policy for the kubeconfig's username
+	createPolicy := func(spec kubeconfigv1alpha1.ApprovalPolicySpec) {
+		spec.Usernames = []string{kubeconfig.Spec.Username}
+		policy := &kubeconfigv1alpha1.ApprovalPolicy{ObjectMeta: metav1.ObjectMeta{Name: uniqueName("policy")}, Spec: spec}
+		Expect(k8sClient.Create(ctx, policy)).To(Succeed())
+		policies = append(policies, policy)
+	}
+
+	// expectApproved asserts the Approved condition and phase of the kubeconfig, which is only persisted right away
+	// if the decision stops the reconciliation
+	expectApproved := func(approved bool, reason string, phase string) {
+		continues, err := r.reconcileApproval(ctx, kubeconfig)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(continues).To(Equal(approved))
+		if !approved {
+			kubeconfig = getKubeconfig(kubeconfig)
+		}
+		condition := meta.FindStatusCondition(kubeconfig.Status.Conditions, kubeconfigv1alpha1.ConditionTypeApproved)
+		Expect(condition).NotTo(BeNil())
+		Expect(condition.Reason).To(Equal(reason))
+		Expect(condition.ObservedGeneration).To(Equal(kubeconfig.Generation))
+		if approved {
+			Expect(condition.Status).To(Equal(metav1.ConditionTrue))
+		} else {
+			Expect(condition.Status).To(Equal(metav1.ConditionFalse))
+		}
+		Expect(kubeconfig.Status.Status).To(Equal(phase))
+	}
+
+	table.DescribeTable("decides about the credentials",
+		func(customize func(), approvers []string, approved bool, reason string, phase string) {
+			customize()
+			createTestKubeconfig(kubeconfig)
+			for _, approver := range approvers {
+				createTestApproval(kubeconfig, approver, "")
+			}
+			expectApproved(approved, reason, phase)
+			if len(approvers) > 0 && reason != "ApprovalPolicyViolated" {
+				Expect(kubeconfig.Status.Approvers).To(Equal(approvers))
+			}
+		},
+		table.Entry("approving kubeconfigs with automaticApproval", func() {
+			kubeconfig.Spec.AutoApproveCSR = true
+		}, nil, true, "Approved", ""),
+		table.Entry("leaving other kubeconfigs pending", func() {}, nil, false, "PendingApproval", phases.PhaseAwaitingApproval),
+		table.Entry("approving kubeconfigs with an approval", func() {}, []string{"bob"}, true, "Approved", ""),
+		table.Entry("never approving system:masters automatically", func() {
+			kubeconfig.Spec.AutoApproveCSR = true
+			kubeconfig.Spec.Groups = []string{SystemMastersGroup}
+		}, nil, false, "PendingApproval", phases.PhaseAwaitingApproval),
+		table.Entry("approving kubeconfigs with automaticApproval matching a policy", func() {
+			kubeconfig.Spec.AutoApproveCSR = true
+			createPolicy(kubeconfigv1alpha1.ApprovalPolicySpec{})
+		}, nil, true, "Approved", ""),
+		table.Entry("leaving kubeconfigs without automaticApproval matching a policy pending", func() {
+			createPolicy(kubeconfigv1alpha1.ApprovalPolicySpec{})
+		}, nil, false, "PendingApproval", phases.PhaseAwaitingApproval),
+		table.Entry("denying kubeconfigs violating a policy despite approvals", func() {
+			kubeconfig.Spec.AutoApproveCSR = true
+			kubeconfig.Spec.Groups = []string{"ops"}
+			createPolicy(kubeconfigv1alpha1.ApprovalPolicySpec{
+				ForbiddenOrganizations: []string{"ops"},
+				OnViolation:            kubeconfigv1alpha1.ViolationActionDeny,
+			})
+		}, []string{"bob"}, false, "ApprovalPolicyViolated", phases.PhaseDenied),
+		table.Entry("leaving privileged kubeconfigs pending below the quorum", func() {
+			kubeconfig.Spec.AutoApproveCSR = true
+			kubeconfig.Spec.RoleRef = &clusterAdmin
+		}, []string{"bob"}, false, "PendingApproval", phases.PhaseAwaitingApproval),
+		table.Entry("approving privileged kubeconfigs with a quorum", func() {
+			kubeconfig.Spec.RoleRef = &clusterAdmin
+		}, []string{"bob", "carol"}, true, "Approved", ""),
+	)
+
+	It("never counts approvals by the kubeconfig's own user", func() {
+		createTestKubeconfig(kubeconfig)
+		createTestApproval(kubeconfig, kubeconfig.Spec.Username, "")
+		expectApproved(false, "PendingApproval", phases.PhaseAwaitingApproval)
+	})
+
+	It("keeps the approval across changes to fields that approvals do not cover", func() {
+		createTestKubeconfig(kubeconfig)
+		createTestApproval(kubeconfig, "bob", "")
+		expectApproved(true, "Approved", "")
+		Expect(k8sClient.Status().Update(ctx, kubeconfig)).To(Succeed())
+
+		By("changing the target")
+		kubeconfig = getKubeconfig(kubeconfig)
+		generation := kubeconfig.Generation
+		kubeconfig.Spec.Target = &kubeconfigv1alpha1.KubeconfigTarget{Namespace: "default", SecretName: kubeconfig.Name, Key: "config"}
+		Expect(k8sClient.Update(ctx, kubeconfig)).To(Succeed())
+		Expect(kubeconfig.Generation).To(BeNumerically(">", generation))
+		expectApproved(true, "Approved", "")
+		Expect(kubeconfig.Status.Approvers).To(Equal([]string{"bob"}))
+		Expect(k8sClient.Status().Update(ctx, kubeconfig)).To(Succeed())
+
+		By("changing the bound roles")
+		kubeconfig = getKubeconfig(kubeconfig)
+		kubeconfig.Spec.RoleRef = &rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: "edit"}
+		Expect(k8sClient.Update(ctx, kubeconfig)).To(Succeed())
+		expectApproved(false, "PendingApproval", phases.PhaseAwaitingApproval)
+		Expect(kubeconfig.Status.Approvers).To(BeEmpty())
+	})
+
+	It("decides again about all kubeconfigs without a CSR when policies change", func() {
+		createTestKubeconfig(kubeconfig)
+		oidc := newTestKubeconfig("credentials")
+		oidc.Spec.AuthMode = kubeconfigv1alpha1.AuthModeOIDC
+		oidc.Spec.CSR = nil
+		oidc.Spec.OIDC = &kubeconfigv1alpha1.OIDC{IssuerURL: "https://issuer.example.com", ClientID: "kubernetes"}
+		createTestKubeconfig(oidc)
+		certificate := newTestKubeconfig("credentials")
+		createTestKubeconfig(certificate)
+
+		var names []string
+		for _, request := range r.credentialKubeconfigs(&kubeconfigv1alpha1.ApprovalPolicy{ObjectMeta: metav1.ObjectMeta{Name: "all"}}) {
+			names = append(names, request.Name)
+		}
+		Expect(names).To(ContainElements(kubeconfig.Name, oidc.Name))
+		Expect(names).NotTo(ContainElement(certificate.Name))
+	})
+})
@@ -0,0 +1,285 @@
+/*
+Copyright 2022 zoomoid.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	kubeconfigv1alpha1 "github.com/zoomoid/kubeconfig-operator/api/v1alpha1"
+	certificatesv1 "k8s.io/api/certificates/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// SystemMastersGroup is the group that bypasses all authorization in the kube-apiserver. CSRs requesting it
+// are never approved automatically
+const SystemMastersGroup = "system:masters"
+
+// policyAction is the outcome of evaluating the approval policies for a CSR
+type policyAction string
+
+const (
+	policyActionApprove policyAction = "Approve"
+	policyActionPending policyAction = "Pending"
+	policyActionDeny    policyAction = "Deny"
+)
+
+// evaluatePolicies evaluates all approval policies matching the CSR's username against the CSR and the role references
+// bound for its kubeconfig, see boundRoleRefs. Policies without usernames match no CSR. The CSR is approved if at
+// least one policy matches and all matching policies are satisfied. Violations deny the CSR if any violated policy
+// demands it, otherwise the CSR is left pending for manual approval
+func evaluatePolicies(policies []kubeconfigv1alpha1.ApprovalPolicy, request *x509.CertificateRequest, csr *certificatesv1.CertificateSigningRequest, kubeconfig *kubeconfigv1alpha1.Kubeconfig, roleRefs []rbacv1.RoleRef) (policyAction, string) {
+	return decidePolicies(policies, "CSR", request.Subject.CommonName, func(policy *kubeconfigv1alpha1.ApprovalPolicy) []string {
+		return policyViolations(policy, request, csr, kubeconfig, roleRefs)
+	})
+}
+
+// evaluateCredentialPolicies evaluates all approval policies matching the username of a kubeconfig whose credentials
+// are issued without a CSR, i.e., in the ServiceAccountToken and OIDC auth modes, the same way as evaluatePolicies.
+// Requirements on the private key do not apply, since there is none
+func evaluateCredentialPolicies(policies []kubeconfigv1alpha1.ApprovalPolicy, kubeconfig *kubeconfigv1alpha1.Kubeconfig, roleRefs []rbacv1.RoleRef) (policyAction, string) {
+	return decidePolicies(policies, "kubeconfig", kubeconfig.Spec.Username, func(policy *kubeconfigv1alpha1.ApprovalPolicy) []string {
+		return credentialPolicyViolations(policy, kubeconfig, roleRefs)
+	})
+}
+
+// decidePolicies combines the violations of all policies matching the username into a decision about the subject,
+// i.e., a CSR or a kubeconfig
+func decidePolicies(policies []kubeconfigv1alpha1.ApprovalPolicy, subject string, username string, violationsOf func(*kubeconfigv1alpha1.ApprovalPolicy) []string) (policyAction, string) {
+	var matched []string
+	var violations []string
+	deny := false
+	for i := range policies {
+		policy := &policies[i]
+		if !matchesAny(policy.Spec.Usernames, username) {
+			continue
+		}
+		matched = append(matched, policy.Name)
+		policyViolations := violationsOf(policy)
+		for _, violation := range policyViolations {
+			violations = append(violations, fmt.Sprintf("%s: %s", policy.Name, violation))
+		}
+		if len(policyViolations) > 0 && policy.Spec.OnViolation == kubeconfigv1alpha1.ViolationActionDeny {
+			deny = true
+		}
+	}
+
+	if len(matched) == 0 {
+		return policyActionPending, fmt.Sprintf("No approval policy matches username %s", username)
+	}
+	if len(violations) > 0 {
+		message := fmt.Sprintf("The %s violates approval policies, %s", subject, strings.Join(violations, "; "))
+		if deny {
+			return policyActionDeny, message
+		}
+		return policyActionPending, message
+	}
+	return policyActionApprove, fmt.Sprintf("The %s was auto-approved by the kubeconfig operator according to approval policies %s", subject, strings.Join(matched, ", "))
+}
+
+// policyViolations returns a description of every requirement of the policy that the CSR does not meet
+func policyViolations(policy *kubeconfigv1alpha1.ApprovalPolicy, request *x509.CertificateRequest, csr *certificatesv1.CertificateSigningRequest, kubeconfig *kubeconfigv1alpha1.Kubeconfig, roleRefs []rbacv1.RoleRef) []string {
+	spec := policy.Spec
+	violations := organizationViolations(spec, request.Subject.Organization)
+
+	algorithm := keyAlgorithm(request)
+	if len(spec.AllowedKeyAlgorithms) > 0 && !containsKeyAlgorithm(spec.AllowedKeyAlgorithms, algorithm) {
+		violations = append(violations, fmt.Sprintf("key algorithm %s is not allowed", algorithm))
+	}
+	switch key := request.PublicKey.(type) {
+	case *rsa.PublicKey:
+		if size := key.N.BitLen(); size < int(spec.MinRSAKeySize) {
+			violations = append(violations, fmt.Sprintf("RSA key size %d is smaller than %d", size, spec.MinRSAKeySize))
+		}
+	case *ecdsa.PublicKey:
+		curve := kubeconfigv1alpha1.ECDSACurve(key.Curve.Params().Name)
+		if len(spec.AllowedECDSACurves) > 0 && !containsCurve(spec.AllowedECDSACurves, curve) {
+			violations = append(violations, fmt.Sprintf("ECDSA curve %s is not allowed", curve))
+		}
+	}
+
+	if spec.MaxDuration != nil {
+		if csr.Spec.ExpirationSeconds == nil {
+			violations = append(violations, fmt.Sprintf("the CSR does not request a lifetime, at most %s is allowed", spec.MaxDuration.Duration))
+		} else if duration := time.Duration(*csr.Spec.ExpirationSeconds) * time.Second; duration > spec.MaxDuration.Duration {
+			violations = append(violations, fmt.Sprintf("requested lifetime %s exceeds %s", duration, spec.MaxDuration.Duration))
+		}
+	}
+
+	if len(spec.AllowedRoleRefs) > 0 {
+		if kubeconfig == nil {
+			violations = append(violations, "the CSR's kubeconfig could not be found to check its role references")
+		} else {
+			violations = append(violations, roleRefViolations(spec, roleRefs)...)
+		}
+	}
+	return violations
+}
+
+// credentialPolicyViolations returns a description of every requirement of the policy that a kubeconfig in the
+// ServiceAccountToken or OIDC auth mode does not meet. The maximum duration applies to ServiceAccount tokens, the
+// lifetime of ID tokens is chosen by the identity provider
+func credentialPolicyViolations(policy *kubeconfigv1alpha1.ApprovalPolicy, kubeconfig *kubeconfigv1alpha1.Kubeconfig, roleRefs []rbacv1.RoleRef) []string {
+	spec := policy.Spec
+	violations := organizationViolations(spec, kubeconfig.Spec.Groups)
+	if spec.MaxDuration != nil && kubeconfig.UsesServiceAccountToken() {
+		duration := kubeconfigv1alpha1.DefaultTokenDuration
+		if token := kubeconfig.Spec.ServiceAccountToken; token != nil && token.Duration != nil {
+			duration = token.Duration.Duration
+		}
+		if duration > spec.MaxDuration.Duration {
+			violations = append(violations, fmt.Sprintf("requested token lifetime %s exceeds %s", duration, spec.MaxDuration.Duration))
+		}
+	}
+	return append(violations, roleRefViolations(spec, roleRefs)...)
+}
+
+// organizationViolations checks the requested organizations, i.e., groups, against the allowed and forbidden
+// organizations of the policy. system:masters is always forbidden
+func organizationViolations(spec kubeconfigv1alpha1.ApprovalPolicySpec, organizations []string) []string {
+	var violations []string
+	for _, organization := range organizations {
+		if organization == SystemMastersGroup || matchesAny(spec.ForbiddenOrganizations, organization) {
+			violations = append(violations, fmt.Sprintf("organization %s is forbidden", organization))
+		} else if len(spec.AllowedOrganizations) > 0 && !matchesAny(spec.AllowedOrganizations, organization) {
+			violations = append(violations, fmt.Sprintf("organization %s is not allowed", organization))
+		}
+	}
+	return violations
+}
+
+// roleRefViolations checks the role references bound for a kubeconfig against the allowed role references of the policy
+func roleRefViolations(spec kubeconfigv1alpha1.ApprovalPolicySpec, roleRefs []rbacv1.RoleRef) []string {
+	if len(spec.AllowedRoleRefs) == 0 {
+		return nil
+	}
+	var violations []string
+	for _, roleRef := range roleRefs {
+		if !matchesRoleRef(spec.AllowedRoleRefs, roleRef) {
+			violations = append(violations, fmt.Sprintf("%s %s is not allowed", roleRef.Kind, roleRef.Name))
+		}
+	}
+	return violations
+}
+
+// kubeconfigRoleRefs returns all role references that the kubeconfig binds its user to
+func kubeconfigRoleRefs(kubeconfig *kubeconfigv1alpha1.Kubeconfig) []rbacv1.RoleRef {
+	var roleRefs []rbacv1.RoleRef
+	if kubeconfig.Spec.RoleRef != nil {
+		roleRefs = append(roleRefs, *kubeconfig.Spec.RoleRef)
+	}
+	for _, binding := range kubeconfig.Spec.Bindings {
+		roleRefs = append(roleRefs, binding.RoleRef)
+	}
+	return roleRefs
+}
+
+// boundRoleRefs returns all role references that the kubeconfig's user gains, i.e., those of the kubeconfig itself
+// and those of the KubeconfigGroups of the certificate's organizations
+func boundRoleRefs(ctx context.Context, c client.Client, kubeconfig *kubeconfigv1alpha1.Kubeconfig) ([]rbacv1.RoleRef, error) {
+	roleRefs := kubeconfigRoleRefs(kubeconfig)
+	organizations := certificateOrganizations(kubeconfig)
+	if len(organizations) == 0 {
+		return roleRefs, nil
+	}
+	groups := map[string]bool{}
+	for _, organization := range organizations {
+		groups[organization] = true
+	}
+	kubeconfigGroups := &kubeconfigv1alpha1.KubeconfigGroupList{}
+	err := c.List(ctx, kubeconfigGroups)
+	if err != nil {
+		return nil, err
+	}
+	for _, group := range kubeconfigGroups.Items {
+		if !groups[group.GroupName()] {
+			continue
+		}
+		for _, binding := range group.Spec.Bindings {
+			roleRefs = append(roleRefs, binding.RoleRef)
+		}
+	}
+	return roleRefs, nil
+}
+
+// keyAlgorithm returns the algorithm of the CSR's public key
+func keyAlgorithm(request *x509.CertificateRequest) kubeconfigv1alpha1.KeyAlgorithm {
+	switch request.PublicKeyAlgorithm {
+	case x509.RSA:
+		return kubeconfigv1alpha1.KeyAlgorithmRSA
+	case x509.ECDSA:
+		return kubeconfigv1alpha1.KeyAlgorithmECDSA
+	case x509.Ed25519:
+		return kubeconfigv1alpha1.KeyAlgorithmEd25519
+	}
+	return kubeconfigv1alpha1.KeyAlgorithm(request.PublicKeyAlgorithm.String())
+}
+
+// matchesAny returns true if the value matches any of the glob patterns
+func matchesAny(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if ok, err := path.Match(pattern, value); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesRoleRef returns true if the role reference matches any of the patterns
+func matchesRoleRef(patterns []kubeconfigv1alpha1.RoleRefPattern, roleRef rbacv1.RoleRef) bool {
+	for _, pattern := range patterns {
+		if pattern.Kind == roleRef.Kind && matchesAny([]string{pattern.Name}, roleRef.Name) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsKeyAlgorithm(algorithms []kubeconfigv1alpha1.KeyAlgorithm, algorithm kubeconfigv1alpha1.KeyAlgorithm) bool {
+	for _, a := range algorithms {
+		if a == algorithm {
+			return true
+		}
+	}
+	return false
+}
+
+func containsCurve(curves []kubeconfigv1alpha1.ECDSACurve, curve kubeconfigv1alpha1.ECDSACurve) bool {
+	for _, c := range curves {
+		if c == curve {
+			return true
+		}
+	}
+	return false
+}
+
+// requestsSystemMasters returns true if the CSR requests membership in system:masters
+func requestsSystemMasters(request *x509.CertificateRequest) bool {
+	for _, organization := range request.Subject.Organization {
+		if organization == SystemMastersGroup {
+			return true
+		}
+	}
+	return false
+}
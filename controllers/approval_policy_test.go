@@ -0,0 +1,164 @@
+/*
+Copyright 2022 zoomoid.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	kubeconfigv1alpha1 "github.com/zoomoid/kubeconfig-operator/api/v1alpha1"
+	certificatesv1 "k8s.io/api/certificates/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("Approval policies", func() {
+	view := rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: "view"}
+	edit := rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: "edit"}
+	clusterAdmin := rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: "cluster-admin"}
+	hour := int32(3600)
+
+	policy := func(spec kubeconfigv1alpha1.ApprovalPolicySpec) []kubeconfigv1alpha1.ApprovalPolicy {
+		return []kubeconfigv1alpha1.ApprovalPolicy{{ObjectMeta: metav1.ObjectMeta{Name: "policy"}, Spec: spec}}
+	}
+
+	// request returns a certificate request of alice with the given organizations
+	request := func(organizations ...string) *x509.CertificateRequest {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		Expect(err).NotTo(HaveOccurred())
+		return &x509.CertificateRequest{
+			Subject:            pkix.Name{CommonName: "alice", Organization: organizations},
+			PublicKeyAlgorithm: x509.ECDSA,
+			PublicKey:          &key.PublicKey,
+		}
+	}
+
+	table.DescribeTable("decide about CSRs",
+		func(policies []kubeconfigv1alpha1.ApprovalPolicy, request *x509.CertificateRequest, expirationSeconds *int32, roleRefs []rbacv1.RoleRef, want policyAction) {
+			csr := &certificatesv1.CertificateSigningRequest{Spec: certificatesv1.CertificateSigningRequestSpec{ExpirationSeconds: expirationSeconds}}
+			kubeconfig := newTestKubeconfig("alice")
+			got, message := evaluatePolicies(policies, request, csr, kubeconfig, roleRefs)
+			Expect(got).To(Equal(want), message)
+		},
+		table.Entry("without matching policy",
+			policy(kubeconfigv1alpha1.ApprovalPolicySpec{Usernames: []string{"bob"}}), request(), nil, nil, policyActionPending),
+		table.Entry("with a policy without usernames",
+			policy(kubeconfigv1alpha1.ApprovalPolicySpec{}), request(), nil, nil, policyActionPending),
+		table.Entry("with a satisfied policy",
+			policy(kubeconfigv1alpha1.ApprovalPolicySpec{Usernames: []string{"*"}}), request(), nil, nil, policyActionApprove),
+		table.Entry("with a forbidden organization",
+			policy(kubeconfigv1alpha1.ApprovalPolicySpec{Usernames: []string{"ali*"}, ForbiddenOrganizations: []string{"ops-*"}}), request("ops-team"), nil, nil, policyActionPending),
+		table.Entry("with an organization that is not allowed",
+			policy(kubeconfigv1alpha1.ApprovalPolicySpec{Usernames: []string{"*"}, AllowedOrganizations: []string{"dev"}}), request("ops"), nil, nil, policyActionPending),
+		table.Entry("requesting system:masters from a denying policy",
+			policy(kubeconfigv1alpha1.ApprovalPolicySpec{Usernames: []string{"*"}, OnViolation: kubeconfigv1alpha1.ViolationActionDeny}), request(SystemMastersGroup), nil, nil, policyActionDeny),
+		table.Entry("with one of several violated policies denying", []kubeconfigv1alpha1.ApprovalPolicy{
+			{ObjectMeta: metav1.ObjectMeta{Name: "curves"}, Spec: kubeconfigv1alpha1.ApprovalPolicySpec{Usernames: []string{"*"}, AllowedECDSACurves: []kubeconfigv1alpha1.ECDSACurve{kubeconfigv1alpha1.ECDSACurveP384}}},
+			{ObjectMeta: metav1.ObjectMeta{Name: "algorithms"}, Spec: kubeconfigv1alpha1.ApprovalPolicySpec{Usernames: []string{"*"}, AllowedKeyAlgorithms: []kubeconfigv1alpha1.KeyAlgorithm{kubeconfigv1alpha1.KeyAlgorithmRSA}, OnViolation: kubeconfigv1alpha1.ViolationActionDeny}},
+		}, request(), nil, nil, policyActionDeny),
+		table.Entry("with a curve that is not allowed",
+			policy(kubeconfigv1alpha1.ApprovalPolicySpec{Usernames: []string{"*"}, AllowedECDSACurves: []kubeconfigv1alpha1.ECDSACurve{kubeconfigv1alpha1.ECDSACurveP384}}), request(), nil, nil, policyActionPending),
+		table.Entry("with a lifetime within the maximum",
+			policy(kubeconfigv1alpha1.ApprovalPolicySpec{Usernames: []string{"*"}, MaxDuration: &metav1.Duration{Duration: time.Hour}}), request(), &hour, nil, policyActionApprove),
+		table.Entry("without a requested lifetime",
+			policy(kubeconfigv1alpha1.ApprovalPolicySpec{Usernames: []string{"*"}, MaxDuration: &metav1.Duration{Duration: time.Hour}}), request(), nil, nil, policyActionPending),
+		table.Entry("with a lifetime exceeding the maximum",
+			policy(kubeconfigv1alpha1.ApprovalPolicySpec{Usernames: []string{"*"}, MaxDuration: &metav1.Duration{Duration: time.Minute}, OnViolation: kubeconfigv1alpha1.ViolationActionDeny}), request(), &hour, nil, policyActionDeny),
+		table.Entry("with an allowed role reference",
+			policy(kubeconfigv1alpha1.ApprovalPolicySpec{Usernames: []string{"*"}, AllowedRoleRefs: []kubeconfigv1alpha1.RoleRefPattern{{Kind: "ClusterRole", Name: "cluster-*"}}}), request(), nil, []rbacv1.RoleRef{clusterAdmin}, policyActionApprove),
+		table.Entry("with a role reference that is not allowed",
+			policy(kubeconfigv1alpha1.ApprovalPolicySpec{Usernames: []string{"*"}, AllowedRoleRefs: []kubeconfigv1alpha1.RoleRefPattern{{Kind: "ClusterRole", Name: "view"}}}), request(), nil, []rbacv1.RoleRef{view, clusterAdmin}, policyActionPending),
+	)
+
+	table.DescribeTable("decide about kubeconfigs without CSR",
+		func(authMode kubeconfigv1alpha1.AuthMode, token *kubeconfigv1alpha1.ServiceAccountToken, groups []string, spec kubeconfigv1alpha1.ApprovalPolicySpec, want policyAction) {
+			kubeconfig := newTestKubeconfig("alice")
+			kubeconfig.Spec.AuthMode = authMode
+			kubeconfig.Spec.CSR = nil
+			kubeconfig.Spec.ServiceAccountToken = token
+			kubeconfig.Spec.Groups = groups
+			roleRefs := []rbacv1.RoleRef{view}
+			got, message := evaluateCredentialPolicies(policy(spec), kubeconfig, roleRefs)
+			Expect(got).To(Equal(want), message)
+		},
+		table.Entry("with a satisfied policy", kubeconfigv1alpha1.AuthModeServiceAccountToken,
+			&kubeconfigv1alpha1.ServiceAccountToken{Duration: &metav1.Duration{Duration: time.Hour}}, nil,
+			kubeconfigv1alpha1.ApprovalPolicySpec{Usernames: []string{"*"}, MaxDuration: &metav1.Duration{Duration: time.Hour}}, policyActionApprove),
+		table.Entry("with a policy without usernames", kubeconfigv1alpha1.AuthModeServiceAccountToken, nil, nil,
+			kubeconfigv1alpha1.ApprovalPolicySpec{}, policyActionPending),
+		table.Entry("with a token lifetime exceeding the maximum", kubeconfigv1alpha1.AuthModeServiceAccountToken,
+			&kubeconfigv1alpha1.ServiceAccountToken{Duration: &metav1.Duration{Duration: 2 * time.Hour}}, nil,
+			kubeconfigv1alpha1.ApprovalPolicySpec{Usernames: []string{"*"}, MaxDuration: &metav1.Duration{Duration: time.Hour}, OnViolation: kubeconfigv1alpha1.ViolationActionDeny}, policyActionDeny),
+		table.Entry("with a default token lifetime exceeding the maximum", kubeconfigv1alpha1.AuthModeServiceAccountToken, nil, nil,
+			kubeconfigv1alpha1.ApprovalPolicySpec{Usernames: []string{"*"}, MaxDuration: &metav1.Duration{Duration: kubeconfigv1alpha1.DefaultTokenDuration - time.Second}}, policyActionPending),
+		table.Entry("ignoring the maximum lifetime in the OIDC auth mode", kubeconfigv1alpha1.AuthModeOIDC, nil, nil,
+			kubeconfigv1alpha1.ApprovalPolicySpec{Usernames: []string{"*"}, MaxDuration: &metav1.Duration{Duration: time.Second}}, policyActionApprove),
+		table.Entry("requesting system:masters", kubeconfigv1alpha1.AuthModeOIDC, nil, []string{SystemMastersGroup},
+			kubeconfigv1alpha1.ApprovalPolicySpec{Usernames: []string{"*"}, OnViolation: kubeconfigv1alpha1.ViolationActionDeny}, policyActionDeny),
+		table.Entry("with a group that is not allowed", kubeconfigv1alpha1.AuthModeOIDC, nil, []string{"ops"},
+			kubeconfigv1alpha1.ApprovalPolicySpec{Usernames: []string{"*"}, AllowedOrganizations: []string{"dev"}}, policyActionPending),
+		table.Entry("with a binding that is not allowed", kubeconfigv1alpha1.AuthModeOIDC, nil, nil,
+			kubeconfigv1alpha1.ApprovalPolicySpec{Usernames: []string{"*"}, AllowedRoleRefs: []kubeconfigv1alpha1.RoleRefPattern{{Kind: "Role", Name: "*"}}}, policyActionPending),
+	)
+
+	It("check the role references gained through KubeconfigGroups", func() {
+		group := createTestKubeconfigGroup(clusterAdmin)
+		// the bindings of groups the kubeconfig is not a member of do not count
+		createTestKubeconfigGroup(edit)
+		kubeconfig := newTestKubeconfig("alice")
+		kubeconfig.Spec.RoleRef = &view
+		kubeconfig.Spec.Groups = []string{group.GroupName()}
+
+		roleRefs, err := boundRoleRefs(ctx, k8sClient, kubeconfig)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(roleRefs).To(ConsistOf(view, clusterAdmin))
+
+		By("finding no group role references for kubeconfigs without groups")
+		kubeconfig.Spec.Groups = nil
+		roleRefs, err = boundRoleRefs(ctx, k8sClient, kubeconfig)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(roleRefs).To(ConsistOf(view))
+		kubeconfig.Spec.Groups = []string{group.GroupName()}
+
+		By("refusing the CSR of a kubeconfig whose group binds a role that is not allowed")
+		roleRefs, err = boundRoleRefs(ctx, k8sClient, kubeconfig)
+		Expect(err).NotTo(HaveOccurred())
+		onlyView := policy(kubeconfigv1alpha1.ApprovalPolicySpec{
+			Usernames:       []string{"*"},
+			AllowedRoleRefs: []kubeconfigv1alpha1.RoleRefPattern{{Kind: "ClusterRole", Name: "view"}},
+			OnViolation:     kubeconfigv1alpha1.ViolationActionDeny,
+		})
+		csr := &certificatesv1.CertificateSigningRequest{}
+		action, message := evaluatePolicies(onlyView, request(group.GroupName()), csr, kubeconfig, roleRefs)
+		Expect(action).To(Equal(policyActionDeny))
+		Expect(message).To(ContainSubstring("ClusterRole cluster-admin is not allowed"))
+
+		By("refusing kubeconfigs without CSR the same way")
+		kubeconfig.Spec.AuthMode = kubeconfigv1alpha1.AuthModeOIDC
+		action, message = evaluateCredentialPolicies(onlyView, kubeconfig, roleRefs)
+		Expect(action).To(Equal(policyActionDeny))
+		Expect(message).To(ContainSubstring("ClusterRole cluster-admin is not allowed"))
+	})
+})
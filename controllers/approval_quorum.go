@@ -0,0 +1,181 @@
+/*
+Copyright 2022 zoomoid.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	kubeconfigv1alpha1 "github.com/zoomoid/kubeconfig-operator/api/v1alpha1"
+	"github.com/zoomoid/kubeconfig-operator/controllers/phases"
+	certificatesv1 "k8s.io/api/certificates/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// ApprovalOptions configure the multi-party approval of privileged kubeconfigs
+type ApprovalOptions struct {
+	// Quorum is the number of distinct users that need to approve the CSR of a privileged kubeconfig
+	// with a KubeconfigApproval. Zero disables multi-party approval
+	Quorum int
+	// PrivilegedClusterRoles are the ClusterRoles that make a kubeconfig privileged when referenced in its roleRef or
+	// bindings, or in the bindings of a KubeconfigGroup of one of its groups
+	PrivilegedClusterRoles []string
+}
+
+// requiresQuorum returns true if the kubeconfig needs multi-party approval, i.e., if it binds a privileged ClusterRole
+// either itself or through the KubeconfigGroups of its groups, or if it requests the system:masters group
+func (o ApprovalOptions) requiresQuorum(ctx context.Context, c client.Client, kubeconfig *kubeconfigv1alpha1.Kubeconfig) (bool, error) {
+	if o.Quorum <= 0 {
+		return false, nil
+	}
+	for _, organization := range certificateOrganizations(kubeconfig) {
+		if organization == SystemMastersGroup {
+			return true, nil
+		}
+	}
+	roleRefs, err := boundRoleRefs(ctx, c, kubeconfig)
+	if err != nil {
+		return false, err
+	}
+	for _, roleRef := range roleRefs {
+		if roleRef.Kind != "ClusterRole" {
+			continue
+		}
+		for _, privileged := range o.PrivilegedClusterRoles {
+			if roleRef.Name == privileged {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// csrApprovers returns the sorted distinct users that approved the CSR with the given UID through KubeconfigApprovals.
+// Approvals by the kubeconfig's own user or by the user that created it are never counted
+func csrApprovers(ctx context.Context, c client.Client, kubeconfig *kubeconfigv1alpha1.Kubeconfig, csrUID types.UID) ([]string, error) {
+	return listApprovers(ctx, c, kubeconfig, func(spec kubeconfigv1alpha1.KubeconfigApprovalSpec) bool {
+		return spec.CSRUID == csrUID
+	})
+}
+
+// specApprovers returns the sorted distinct users that approved the current spec of a kubeconfig whose credentials
+// are issued without a CSR, see ApprovalSpecHash. Approvals recorded without a hash by previous versions of the
+// operator only approve the generation they were created for. Approvals by the kubeconfig's own user or by the user
+// that created it are never counted
+func specApprovers(ctx context.Context, c client.Client, kubeconfig *kubeconfigv1alpha1.Kubeconfig) ([]string, error) {
+	hash := kubeconfig.ApprovalSpecHash()
+	return listApprovers(ctx, c, kubeconfig, func(spec kubeconfigv1alpha1.KubeconfigApprovalSpec) bool {
+		if spec.CSRUID != "" {
+			return false
+		}
+		if spec.SpecHash == "" {
+			return spec.Generation == kubeconfig.Generation
+		}
+		return spec.SpecHash == hash
+	})
+}
+
+// listApprovers returns the sorted distinct users of the kubeconfig's approvals that the filter accepts, except for
+// the kubeconfig's requesters, see Kubeconfig.IsRequester
+func listApprovers(ctx context.Context, c client.Client, kubeconfig *kubeconfigv1alpha1.Kubeconfig, approves func(kubeconfigv1alpha1.KubeconfigApprovalSpec) bool) ([]string, error) {
+	approvals := &kubeconfigv1alpha1.KubeconfigApprovalList{}
+	err := c.List(ctx, approvals)
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	approvers := []string{}
+	for _, approval := range approvals.Items {
+		spec := approval.Spec
+		if spec.Kubeconfig != kubeconfig.Name || !approves(spec) || spec.Approver == "" || kubeconfig.IsRequester(spec.Approver) {
+			continue
+		}
+		if !seen[spec.Approver] {
+			seen[spec.Approver] = true
+			approvers = append(approvers, spec.Approver)
+		}
+	}
+	sort.Strings(approvers)
+	return approvers, nil
+}
+
+// reconcileApprovers records the users that approved the CSR of a privileged kubeconfig in its status. Certificates of
+// CSRs that were approved without reaching the quorum, e.g., by a single `kubectl certificate approve`, are never
+// handed out, and the kubeconfig fails instead. It returns true if the reconciliation must not continue
+func (r *KubeconfigReconciler) reconcileApprovers(ctx context.Context, kubeconfig *kubeconfigv1alpha1.Kubeconfig, csr *certificatesv1.CertificateSigningRequest, approved bool) (bool, error) {
+	approvers, err := csrApprovers(ctx, r.Client, kubeconfig, csr.UID)
+	if err != nil {
+		klog.ErrorS(err, "failed to list approvals of kubeconfig", "name", kubeconfig.Name)
+		return true, err
+	}
+	changed := !reflect.DeepEqual(approvers, kubeconfig.Status.Approvers)
+	if changed {
+		kubeconfig.Status.Approvers = approvers
+		r.Recorder.Eventf(kubeconfig, "Normal", "ApprovalRecorded", "CSR was approved by %s (%d of %d required approvals)", strings.Join(approvers, ", "), len(approvers), r.Approval.Quorum)
+	}
+
+	if approved && len(approvers) < r.Approval.Quorum {
+		message := fmt.Sprintf("CSR was approved with %d of %d required approvals, the certificate is not used", len(approvers), r.Approval.Quorum)
+		klog.V(0).InfoS("CSR of privileged kubeconfig was approved without quorum", "name", kubeconfig.Name, "approvers", approvers)
+		r.Recorder.Event(kubeconfig, "Warning", "ApprovalQuorumNotMet", message)
+		meta.SetStatusCondition(&kubeconfig.Status.Conditions, metav1.Condition{
+			Type:    kubeconfigv1alpha1.ConditionTypeCSRApproved,
+			Status:  metav1.ConditionFalse,
+			Reason:  "ApprovalQuorumNotMet",
+			Message: message,
+		})
+		meta.SetStatusCondition(&kubeconfig.Status.Conditions, metav1.Condition{
+			Type:    kubeconfigv1alpha1.ConditionTypeKubeconfigFinished,
+			Status:  metav1.ConditionFalse,
+			Reason:  "Failed",
+			Message: "Kubeconfig creation failed in CSR stage",
+		})
+		kubeconfig.Status.Status = phases.PhaseFailed
+		return true, r.Status().Update(ctx, kubeconfig)
+	}
+
+	if changed && !approved {
+		return false, r.Status().Update(ctx, kubeconfig)
+	}
+	return false, nil
+}
+
+// approvalKubeconfig maps KubeconfigApprovals to the kubeconfig they approve
+func approvalKubeconfig(obj client.Object) []reconcile.Request {
+	approval, ok := obj.(*kubeconfigv1alpha1.KubeconfigApproval)
+	if !ok {
+		return nil
+	}
+	return []reconcile.Request{{NamespacedName: types.NamespacedName{Name: approval.Spec.Kubeconfig}}}
+}
+
+// approvalCSR maps KubeconfigApprovals to the CSR they approve
+func approvalCSR(obj client.Object) []reconcile.Request {
+	approval, ok := obj.(*kubeconfigv1alpha1.KubeconfigApproval)
+	if !ok || approval.Spec.CSRName == "" {
+		return nil
+	}
+	return []reconcile.Request{{NamespacedName: types.NamespacedName{Name: approval.Spec.CSRName}}}
+}
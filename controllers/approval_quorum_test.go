@@ -0,0 +1,191 @@
+/*
+Copyright 2022 zoomoid.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	kubeconfigv1alpha1 "github.com/zoomoid/kubeconfig-operator/api/v1alpha1"
+	certificatesv1 "k8s.io/api/certificates/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("Approval quorum", func() {
+	clusterAdmin := rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: "cluster-admin"}
+	view := rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: "view"}
+
+	Context("requiresQuorum", func() {
+		var admins, renamed, viewers *kubeconfigv1alpha1.KubeconfigGroup
+
+		BeforeEach(func() {
+			admins = createTestKubeconfigGroup(clusterAdmin)
+			viewers = createTestKubeconfigGroup(view)
+			renamed = &kubeconfigv1alpha1.KubeconfigGroup{
+				ObjectMeta: metav1.ObjectMeta{Name: uniqueName("renamed")},
+				Spec: kubeconfigv1alpha1.KubeconfigGroupSpec{
+					Group:    uniqueName("operators"),
+					Bindings: []kubeconfigv1alpha1.KubeconfigBinding{{RoleRef: clusterAdmin}},
+				},
+			}
+			Expect(k8sClient.Create(ctx, renamed)).To(Succeed())
+		})
+
+		table.DescribeTable("decides whether a kubeconfig needs multi-party approval",
+			func(quorum int, customize func(*kubeconfigv1alpha1.Kubeconfig), want bool) {
+				kubeconfig := newTestKubeconfig("quorum")
+				kubeconfig.Spec.RoleRef = nil
+				customize(kubeconfig)
+
+				options := ApprovalOptions{Quorum: quorum, PrivilegedClusterRoles: []string{"cluster-admin"}}
+				Expect(options.requiresQuorum(ctx, k8sClient, kubeconfig)).To(Equal(want))
+			},
+			table.Entry("with the quorum disabled", 0, func(k *kubeconfigv1alpha1.Kubeconfig) {
+				k.Spec.RoleRef = &clusterAdmin
+			}, false),
+			table.Entry("without privileged roles", 2, func(k *kubeconfigv1alpha1.Kubeconfig) {
+				k.Spec.RoleRef = &view
+			}, false),
+			table.Entry("with a privileged roleRef", 2, func(k *kubeconfigv1alpha1.Kubeconfig) {
+				k.Spec.RoleRef = &clusterAdmin
+			}, true),
+			table.Entry("with a privileged binding", 2, func(k *kubeconfigv1alpha1.Kubeconfig) {
+				k.Spec.Bindings = []kubeconfigv1alpha1.KubeconfigBinding{{RoleRef: clusterAdmin}}
+			}, true),
+			table.Entry("with a namespaced Role of the same name", 2, func(k *kubeconfigv1alpha1.Kubeconfig) {
+				k.Spec.Bindings = []kubeconfigv1alpha1.KubeconfigBinding{
+					{Namespace: "default", RoleRef: rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "Role", Name: "cluster-admin"}},
+				}
+			}, false),
+			table.Entry("with the system:masters group", 2, func(k *kubeconfigv1alpha1.Kubeconfig) {
+				k.Spec.Groups = []string{SystemMastersGroup}
+			}, true),
+			table.Entry("with the system:masters organization", 2, func(k *kubeconfigv1alpha1.Kubeconfig) {
+				k.Spec.CSR.AdditionalFields.Organization = []string{SystemMastersGroup}
+			}, true),
+			table.Entry("with a privileged KubeconfigGroup", 2, func(k *kubeconfigv1alpha1.Kubeconfig) {
+				k.Spec.Groups = []string{admins.Name}
+			}, true),
+			table.Entry("with a privileged KubeconfigGroup by its group name", 2, func(k *kubeconfigv1alpha1.Kubeconfig) {
+				k.Spec.Groups = []string{renamed.Spec.Group}
+			}, true),
+			table.Entry("with a group named like a KubeconfigGroup with another group name", 2, func(k *kubeconfigv1alpha1.Kubeconfig) {
+				k.Spec.Groups = []string{renamed.Name}
+			}, false),
+			table.Entry("with a privileged KubeconfigGroup as organization", 2, func(k *kubeconfigv1alpha1.Kubeconfig) {
+				k.Spec.CSR.AdditionalFields.Organization = []string{admins.Name}
+			}, true),
+			table.Entry("with an unprivileged KubeconfigGroup", 2, func(k *kubeconfigv1alpha1.Kubeconfig) {
+				k.Spec.Groups = []string{viewers.Name}
+			}, false),
+		)
+	})
+
+	Context("approvers", func() {
+		var kubeconfig *kubeconfigv1alpha1.Kubeconfig
+
+		BeforeEach(func() {
+			kubeconfig = newTestKubeconfig("approvers")
+			kubeconfig.Spec.AuthMode = kubeconfigv1alpha1.AuthModeServiceAccountToken
+			kubeconfig.Spec.CSR = nil
+			kubeconfig.Generation = 2
+			kubeconfig.Annotations = map[string]string{kubeconfigv1alpha1.RequestedByAnnotationKey: "ivan"}
+
+			createTestApproval(kubeconfig, "bob", "")
+			createTestApproval(kubeconfig, "bob", "")
+			createTestApproval(kubeconfig, "carol", "")
+			createTestApproval(kubeconfig, "erin", "csr-uid")
+			createTestApproval(kubeconfig, kubeconfig.Spec.Username, "")
+			createTestApproval(kubeconfig, "ivan", "")
+			createTestApproval(kubeconfig, "ivan", "csr-uid")
+			createTestApproval(kubeconfig, "", "")
+			createTestApproval(newTestKubeconfig("approvers"), "frank", "")
+			// an approval of the kubeconfig before it was bound to another role
+			previous := kubeconfig.DeepCopy()
+			previous.Spec.RoleRef = &clusterAdmin
+			createTestApproval(previous, "dave", "")
+			// approvals recorded by previous versions of the operator, which carry the approved generation only
+			for approver, generation := range map[string]int64{"grace": 2, "heidi": 1} {
+				approval := &kubeconfigv1alpha1.KubeconfigApproval{
+					ObjectMeta: metav1.ObjectMeta{Name: uniqueName("approval")},
+					Spec:       kubeconfigv1alpha1.KubeconfigApprovalSpec{Kubeconfig: kubeconfig.Name, Approver: approver, Generation: generation},
+				}
+				Expect(k8sClient.Create(ctx, approval)).To(Succeed())
+			}
+		})
+
+		It("counts distinct users that approved the current spec", func() {
+			Expect(specApprovers(ctx, k8sClient, kubeconfig)).To(Equal([]string{"bob", "carol", "grace"}))
+		})
+
+		It("keeps approvals of specs that only changed in fields not covered by approvals", func() {
+			kubeconfig.Generation++
+			kubeconfig.Spec.Target = &kubeconfigv1alpha1.KubeconfigTarget{Namespace: "default", SecretName: "kubeconfig", Key: "config"}
+			Expect(specApprovers(ctx, k8sClient, kubeconfig)).To(Equal([]string{"bob", "carol"}))
+		})
+
+		It("counts distinct users that approved the CSR", func() {
+			Expect(csrApprovers(ctx, k8sClient, kubeconfig, "csr-uid")).To(Equal([]string{"erin"}))
+			Expect(csrApprovers(ctx, k8sClient, kubeconfig, "other-uid")).To(BeEmpty())
+		})
+	})
+
+	Context("CSRs of privileged kubeconfigs", func() {
+		var r *CertificateSigningRequestReconciler
+		var kubeconfig *kubeconfigv1alpha1.Kubeconfig
+
+		BeforeEach(func() {
+			r = newTestCSRReconciler()
+			kubeconfig = newTestKubeconfig("privileged")
+			kubeconfig.Spec.RoleRef = &clusterAdmin
+			kubeconfig.Spec.AutoApproveCSR = true
+			kubeconfig.Annotations = map[string]string{kubeconfigv1alpha1.RequestedByAnnotationKey: "mallory"}
+			Expect(k8sClient.Create(ctx, kubeconfig)).To(Succeed())
+		})
+
+		It("stay pending until a quorum of users approved them", func() {
+			csr := createTestCSR(kubeconfig, kubeconfig)
+			decision, _ := reconcileCSR(r, csr)
+			Expect(decision).To(BeEmpty())
+
+			By("not counting approvals of the kubeconfig's own user and the user that created it")
+			createTestApproval(kubeconfig, "bob", csr.UID)
+			createTestApproval(kubeconfig, kubeconfig.Spec.Username, csr.UID)
+			createTestApproval(kubeconfig, "mallory", csr.UID)
+			decision, _ = reconcileCSR(r, csr)
+			Expect(decision).To(BeEmpty())
+
+			createTestApproval(kubeconfig, "carol", csr.UID)
+			decision, reason := reconcileCSR(r, csr)
+			Expect(decision).To(Equal(certificatesv1.CertificateApproved))
+			Expect(reason).To(Equal("KubeconfigControllerApprove"))
+		})
+
+		It("do not count approvals of other CSRs", func() {
+			previous := createTestCSR(kubeconfig, kubeconfig)
+			createTestApproval(kubeconfig, "bob", previous.UID)
+			createTestApproval(kubeconfig, "carol", previous.UID)
+
+			renewed := kubeconfig.DeepCopy()
+			renewed.Generation++
+			decision, _ := reconcileCSR(r, createTestCSR(renewed, kubeconfig))
+			Expect(decision).To(BeEmpty())
+		})
+	})
+})
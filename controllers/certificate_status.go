@@ -0,0 +1,113 @@
+/*
+Copyright 2022 zoomoid.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"fmt"
+	"strings"
+	"time"
+
+	kubeconfigv1alpha1 "github.com/zoomoid/kubeconfig-operator/api/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// setCertificateStatus publishes the properties of the issued client certificate in the kubeconfig's status and
+// records it among the issued certificates. It returns whether the status was changed
+func setCertificateStatus(kubeconfig *kubeconfigv1alpha1.Kubeconfig, cert *x509.Certificate) bool {
+	notBefore := metav1.NewTime(cert.NotBefore)
+	notAfter := metav1.NewTime(cert.NotAfter)
+	certificate := &kubeconfigv1alpha1.CertificateStatus{
+		SerialNumber: strings.ToUpper(cert.SerialNumber.Text(16)),
+		Fingerprint:  certificateFingerprint(cert),
+		Issuer:       cert.Issuer.String(),
+		Subject:      cert.Subject.String(),
+		NotBefore:    &notBefore,
+		NotAfter:     &notAfter,
+		KeyType:      publicKeyType(cert.PublicKey),
+	}
+	// signers may shorten the requested lifetime, so record the lifetime that was actually granted
+	granted := &metav1.Duration{Duration: cert.NotAfter.Sub(cert.NotBefore)}
+
+	recorded := recordIssuedCertificate(kubeconfig, cert, time.Now())
+	if equality.Semantic.DeepEqual(kubeconfig.Status.Certificate, certificate) &&
+		equality.Semantic.DeepEqual(kubeconfig.Status.CertificateDuration, granted) {
+		return recorded
+	}
+	kubeconfig.Status.Certificate = certificate
+	kubeconfig.Status.CertificateDuration = granted
+	return true
+}
+
+// publicKeyType returns the algorithm and size, or curve, of a public key, e.g., RSA-4096 or ECDSA-P-256
+func publicKeyType(publicKey crypto.PublicKey) string {
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		return fmt.Sprintf("RSA-%d", key.N.BitLen())
+	case *ecdsa.PublicKey:
+		return fmt.Sprintf("ECDSA-%s", key.Curve.Params().Name)
+	case ed25519.PublicKey:
+		return "Ed25519"
+	default:
+		return "Unknown"
+	}
+}
+
+// certificateFingerprint returns the SHA-256 fingerprint of the certificate in the colon-separated notation used by openssl
+func certificateFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(parts, ":")
+}
+
+// recordIssuedCertificate adds the certificate to the kubeconfig's issued certificates, such that it is still revoked
+// after renewals or rotations replaced it, and removes expired certificates. It returns whether the status was changed
+func recordIssuedCertificate(kubeconfig *kubeconfigv1alpha1.Kubeconfig, cert *x509.Certificate, now time.Time) bool {
+	serialNumber := strings.ToUpper(cert.SerialNumber.Text(16))
+	changed := false
+	found := false
+	issued := []kubeconfigv1alpha1.IssuedCertificate{}
+	for _, certificate := range kubeconfig.Status.IssuedCertificates {
+		if !now.Before(certificate.NotAfter.Time) {
+			changed = true
+			continue
+		}
+		found = found || certificate.SerialNumber == serialNumber
+		issued = append(issued, certificate)
+	}
+	if !found && now.Before(cert.NotAfter) {
+		issued = append(issued, kubeconfigv1alpha1.IssuedCertificate{
+			SerialNumber: serialNumber,
+			Fingerprint:  certificateFingerprint(cert),
+			NotAfter:     metav1.NewTime(cert.NotAfter),
+		})
+		changed = true
+	}
+	if changed {
+		kubeconfig.Status.IssuedCertificates = issued
+	}
+	return changed
+}
@@ -0,0 +1,84 @@
+/*
+Copyright 2022 zoomoid.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	kubeconfigv1alpha1 "github.com/zoomoid/kubeconfig-operator/api/v1alpha1"
+)
+
+var _ = Describe("Certificate status", func() {
+	table.DescribeTable("publishes the issued certificate",
+		func(generateKey func() (crypto.Signer, error), keyType string) {
+			key, err := generateKey()
+			Expect(err).NotTo(HaveOccurred())
+			notBefore := time.Now().Truncate(time.Second)
+			template := &x509.Certificate{
+				SerialNumber: big.NewInt(0xC0FFEE),
+				Subject:      pkix.Name{CommonName: "alice", Organization: []string{"dev"}},
+				NotBefore:    notBefore,
+				NotAfter:     notBefore.Add(36 * time.Hour),
+				ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+			}
+			der, err := x509.CreateCertificate(rand.Reader, template, clusterCA.cert, key.Public(), clusterCA.key)
+			Expect(err).NotTo(HaveOccurred())
+			cert, err := x509.ParseCertificate(der)
+			Expect(err).NotTo(HaveOccurred())
+
+			kubeconfig := &kubeconfigv1alpha1.Kubeconfig{}
+			Expect(setCertificateStatus(kubeconfig, cert)).To(BeTrue())
+
+			sum := sha256.Sum256(der)
+			status := kubeconfig.Status.Certificate
+			Expect(status).NotTo(BeNil())
+			Expect(status.SerialNumber).To(Equal("C0FFEE"))
+			Expect(status.Fingerprint).To(Equal(strings.ReplaceAll(fmt.Sprintf("% X", sum[:]), " ", ":")))
+			Expect(status.KeyType).To(Equal(keyType))
+			Expect(status.Subject).To(Equal("CN=alice,O=dev"))
+			Expect(status.Issuer).To(Equal("CN=kubeconfig-operator-test-ca"))
+			Expect(status.NotBefore.Time).To(BeTemporally("==", notBefore))
+			Expect(status.NotAfter.Time).To(BeTemporally("==", notBefore.Add(36*time.Hour)))
+			Expect(kubeconfig.Status.CertificateDuration).NotTo(BeNil())
+			Expect(kubeconfig.Status.CertificateDuration.Duration).To(Equal(36 * time.Hour))
+
+			By("leaving the status unchanged for the same certificate")
+			Expect(setCertificateStatus(kubeconfig, cert)).To(BeFalse())
+		},
+		table.Entry("of an RSA key", func() (crypto.Signer, error) {
+			return rsa.GenerateKey(rand.Reader, 2048)
+		}, "RSA-2048"),
+		table.Entry("of an ECDSA key", func() (crypto.Signer, error) {
+			return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+		}, "ECDSA-P-384"),
+	)
+})
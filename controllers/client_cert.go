@@ -19,7 +19,10 @@ package controllers
 import (
 	"context"
 	"errors"
+	"fmt"
+	"strings"
 
+	kubeconfigv1alpha1 "github.com/zoomoid/kubeconfig-operator/api/v1alpha1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/klog/v2"
@@ -47,3 +50,23 @@ func (r *KubeconfigReconciler) ClientData(ctx context.Context, object *corev1.Se
 	}
 	return string(clientKey), string(clientCert), nil
 }
+
+// verifyExistingCSR checks that a user-provided CSR is a valid PEM-encoded certificate signing request,
+// that it is signed by the private key of its public key, and that it requests a certificate for the kubeconfig's identity
+func verifyExistingCSR(kubeconfig *kubeconfigv1alpha1.Kubeconfig, pemBytes []byte) error {
+	if len(pemBytes) == 0 {
+		return fmt.Errorf("secret does not contain a CSR in key %s", CertificateSecretCSRKey)
+	}
+	csr, err := parseCSR(pemBytes)
+	if err != nil {
+		return err
+	}
+	err = csr.CheckSignature()
+	if err != nil {
+		return fmt.Errorf("invalid CSR signature, %w", err)
+	}
+	if mismatches := verifyRequestSubject(csr, kubeconfig); len(mismatches) > 0 {
+		return errors.New(strings.Join(mismatches, "; "))
+	}
+	return nil
+}
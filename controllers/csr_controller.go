@@ -20,6 +20,8 @@ import (
 	"context"
 	"crypto/x509"
 	"encoding/pem"
+	"fmt"
+	"strings"
 	"time"
 
 	errs "errors"
@@ -31,9 +33,16 @@ import (
 
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
+	kubeconfigv1alpha1 "github.com/zoomoid/kubeconfig-operator/api/v1alpha1"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
 	certificatesv1 "k8s.io/api/certificates/v1"
 	corev1 "k8s.io/api/core/v1"
 )
@@ -43,18 +52,30 @@ type CertificateSigningRequestReconciler struct {
 	client.Client
 	Scheme    *runtime.Scheme
 	Recorder  record.EventRecorder
-	ClientSet *clientset.Clientset
+	ClientSet clientset.Interface
+	// Approval configures the multi-party approval of privileged kubeconfigs
+	Approval ApprovalOptions
+	// SignerName is the signer name that the CSRs of kubeconfigs without .spec.csr.signerName must request,
+	// defaults to KubeconfigSignerName
+	SignerName string
+	// ApprovableSignerNames are the signers that the operator approves and denies CSRs for, defaults to SignerName.
+	// The operator's ClusterRole needs to grant the approve verb on each of them
+	ApprovableSignerNames []string
 }
 
 const (
 	CSRAutoApproveAnnotationKey          string = "kubeconfig.k8s.zoomoid.dev/auto-approve"
 	x509TypeCerticateRequest             string = "CERTIFICATE REQUEST"
+	x509TypeCertificate                  string = "CERTIFICATE"
 	KubeconfigOperatorAPIVersionV1Alpha1 string = "kubeconfig.k8s.zoomoid.dev/v1alpha1"
 )
 
 //+kubebuilder:rbac:groups=certificates.k8s.io,resources=certificatesigningrequests,verbs=get;watch;list
+//+kubebuilder:rbac:groups=kubeconfig.k8s.zoomoid.dev,resources=approvalpolicies,verbs=get;list;watch
+//+kubebuilder:rbac:groups=kubeconfig.k8s.zoomoid.dev,resources=kubeconfigapprovals,verbs=get;list;watch
 //+kubebuilder:rbac:groups=certificates.k8s.io,resources=certificatesigningrequests/approval,verbs=update
 //+kubebuilder:rbac:groups=certificates.k8s.io,resources=signers,resourceNames="kubernetes.io/kube-apiserver-client",verbs=approve
+//+kubebuilder:rbac:groups=certificates.k8s.io,resources=signers,resourceNames="kubeconfig.k8s.zoomoid.dev/client",verbs=approve
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -84,18 +105,18 @@ func (r *CertificateSigningRequestReconciler) Reconcile(ctx context.Context, req
 		return ctrl.Result{}, nil
 	}
 
+	if !r.approvesFor(csr.Spec.SignerName) {
+		// approving or denying requires the approve verb on the signer, leave the CSR to the signer's own approver
+		klog.V(2).InfoS("CSR requests a signer the operator does not approve for, skipping reconciliation", "name", req.Name, "signer", csr.Spec.SignerName)
+		return ctrl.Result{}, nil
+	}
+
 	approved, denied, failed := getCertApprovalCondition(csr.Status.Conditions)
 	isPending := (!approved && !denied && !failed)
 	klog.V(5).InfoS("parsed CSR status", "name", req.Name, "approved", approved, "denied", denied, "failed", failed)
 
-	if hasAutoApproveAnnotation(csr) && isPending {
-		err = r.ApproveCSR(ctx, csr)
-		if err != nil {
-			r.Recorder.Eventf(csr, "Warning", "Failed", "Failed to approve CSR, %v", err)
-			_ = r.Status().Update(ctx, csr)
-			return ctrl.Result{}, err
-		}
-		return ctrl.Result{}, nil
+	if isPending {
+		return r.reconcilePending(ctx, csr)
 	}
 
 	if !approved {
@@ -107,24 +128,155 @@ func (r *CertificateSigningRequestReconciler) Reconcile(ctx context.Context, req
 	return ctrl.Result{}, nil
 }
 
-func (r *CertificateSigningRequestReconciler) ApproveCSR(ctx context.Context, csr *certificatesv1.CertificateSigningRequest) error {
+// reconcilePending decides about the approval of a pending CSR. CSRs that do not match their owning kubeconfig are denied.
+// If no ApprovalPolicy exists, CSRs annotated for auto-approval are approved, otherwise the policies matching the CSR's
+// username decide. CSRs of privileged kubeconfigs are only approved once a quorum of users approved them
+func (r *CertificateSigningRequestReconciler) reconcilePending(ctx context.Context, csr *certificatesv1.CertificateSigningRequest) (ctrl.Result, error) {
+	request, err := parseCSR(csr.Spec.Request)
+	if err != nil {
+		return ctrl.Result{}, r.DenyCSR(ctx, csr, "InvalidRequest", fmt.Sprintf("Failed to parse x509 CSR from request field, %v", err))
+	}
+	kubeconfig, err := ownerKubeconfig(ctx, r.Client, csr)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if kubeconfig == nil {
+		return ctrl.Result{}, r.DenyCSR(ctx, csr, "KubeconfigMismatch", "The CSR's owning kubeconfig does not exist")
+	}
+	if mismatches := verifyCSR(csr, request, kubeconfig, signerOrDefault(r.SignerName)); len(mismatches) > 0 {
+		message := fmt.Sprintf("The CSR does not match kubeconfig %s, %s", kubeconfig.Name, strings.Join(mismatches, "; "))
+		return ctrl.Result{}, r.DenyCSR(ctx, csr, "KubeconfigMismatch", message)
+	}
+
+	policies := &kubeconfigv1alpha1.ApprovalPolicyList{}
+	err = r.List(ctx, policies)
+	if err != nil {
+		klog.ErrorS(err, "failed to list approval policies")
+		return ctrl.Result{}, err
+	}
+
+	var action policyAction
+	var message string
+	if len(policies.Items) == 0 {
+		action, message = annotationDecision(csr, request)
+	} else {
+		roleRefs, err := boundRoleRefs(ctx, r.Client, kubeconfig)
+		if err != nil {
+			klog.ErrorS(err, "failed to list kubeconfig groups")
+			return ctrl.Result{}, err
+		}
+		action, message = evaluatePolicies(policies.Items, request, csr, kubeconfig, roleRefs)
+		// policies only approve the CSRs of kubeconfigs that opted into automatic approval
+		if action == policyActionApprove && !hasAutoApproveAnnotation(csr) {
+			action, message = policyActionPending, ""
+		}
+	}
+	privileged, err := r.Approval.requiresQuorum(ctx, r.Client, kubeconfig)
+	if err != nil {
+		klog.ErrorS(err, "failed to list kubeconfig groups")
+		return ctrl.Result{}, err
+	}
+	if action != policyActionDeny && privileged {
+		// privileged kubeconfigs are approved by a quorum of users instead of policies or annotations
+		approvers, err := csrApprovers(ctx, r.Client, kubeconfig, csr.UID)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if len(approvers) >= r.Approval.Quorum {
+			action, message = policyActionApprove, fmt.Sprintf("The CSR was approved by %s", strings.Join(approvers, ", "))
+		} else {
+			action, message = policyActionPending, fmt.Sprintf("The kubeconfig is privileged and needs to be approved by %d users, approved by %d so far", r.Approval.Quorum, len(approvers))
+		}
+	}
+	klog.V(2).InfoS("Decided about approval of CSR", "name", csr.Name, "action", action, "message", message)
+	switch action {
+	case policyActionApprove:
+		err = r.ApproveCSR(ctx, csr, message)
+		if err != nil {
+			r.Recorder.Eventf(csr, "Warning", "Failed", "Failed to approve CSR, %v", err)
+			return ctrl.Result{}, err
+		}
+	case policyActionDeny:
+		err = r.DenyCSR(ctx, csr, "ApprovalPolicyViolated", message)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+	default:
+		if message != "" {
+			r.Recorder.Event(csr, "Normal", "PendingApproval", message)
+		}
+	}
+	return ctrl.Result{}, nil
+}
+
+// approvesFor returns true if the operator is configured to approve CSRs of the given signer
+func (r *CertificateSigningRequestReconciler) approvesFor(signerName string) bool {
+	if len(r.ApprovableSignerNames) == 0 {
+		return signerName == signerOrDefault(r.SignerName)
+	}
+	for _, approvable := range r.ApprovableSignerNames {
+		if signerName == approvable {
+			return true
+		}
+	}
+	return false
+}
+
+// MissingSignerPermissions returns the signers for which the operator lacks the given verb, e.g., approve, as
+// reported by SelfSubjectAccessReviews
+func MissingSignerPermissions(ctx context.Context, clientSet clientset.Interface, verb string, signerNames []string) ([]string, error) {
+	var missing []string
+	for _, signerName := range signerNames {
+		review := &authorizationv1.SelfSubjectAccessReview{
+			Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+				ResourceAttributes: &authorizationv1.ResourceAttributes{
+					Group:    certificatesv1.GroupName,
+					Resource: "signers",
+					Name:     signerName,
+					Verb:     verb,
+				},
+			},
+		}
+		review, err := clientSet.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, review, metav1.CreateOptions{})
+		if err != nil {
+			return nil, err
+		}
+		if !review.Status.Allowed {
+			missing = append(missing, signerName)
+		}
+	}
+	return missing, nil
+}
+
+// annotationDecision approves CSRs annotated for auto-approval as long as no ApprovalPolicy exists,
+// except for CSRs requesting system:masters
+func annotationDecision(csr *certificatesv1.CertificateSigningRequest, request *x509.CertificateRequest) (policyAction, string) {
+	if !hasAutoApproveAnnotation(csr) {
+		return policyActionPending, ""
+	}
+	if requestsSystemMasters(request) {
+		return policyActionPending, "CSRs requesting the system:masters group are never approved automatically"
+	}
+	return policyActionApprove, "The CSR was auto-approved by the kubeconfig operator"
+}
+
+// ApproveCSR approves the CSR with the given message
+func (r *CertificateSigningRequestReconciler) ApproveCSR(ctx context.Context, csr *certificatesv1.CertificateSigningRequest, message string) error {
 	_, err := parseCSR(csr.Spec.Request)
 	if err != nil {
 		klog.V(0).ErrorS(err, "Failed to parse x509 CSR from request field")
-		setStatusCondition(&csr.Status.Conditions, certificatesv1.CertificateSigningRequestCondition{
-			Type:    certificatesv1.CertificateFailed,
-			Status:  corev1.ConditionTrue,
-			Reason:  "Failed",
-			Message: err.Error(),
-		})
-		return err
+		err = r.DenyCSR(ctx, csr, "InvalidRequest", fmt.Sprintf("Failed to parse x509 CSR from request field, %v", err))
+		if err != nil {
+			return err
+		}
+		return ErrCertificateSigningRequestDenied
 	}
 
 	setStatusCondition(&csr.Status.Conditions, certificatesv1.CertificateSigningRequestCondition{
 		Type:    certificatesv1.CertificateApproved,
 		Status:  corev1.ConditionTrue,
 		Reason:  "KubeconfigControllerApprove",
-		Message: "The CSR was auto-approved by the kubeconfig operator",
+		Message: message,
 	})
 
 	csr, err = r.ClientSet.CertificatesV1().CertificateSigningRequests().UpdateApproval(ctx, csr.Name, csr, metav1.UpdateOptions{})
@@ -135,9 +287,73 @@ func (r *CertificateSigningRequestReconciler) ApproveCSR(ctx context.Context, cs
 	return nil
 }
 
+// DenyCSR denies the CSR with the given reason and message
+func (r *CertificateSigningRequestReconciler) DenyCSR(ctx context.Context, csr *certificatesv1.CertificateSigningRequest, reason string, message string) error {
+	setStatusCondition(&csr.Status.Conditions, certificatesv1.CertificateSigningRequestCondition{
+		Type:    certificatesv1.CertificateDenied,
+		Status:  corev1.ConditionTrue,
+		Reason:  reason,
+		Message: message,
+	})
+
+	_, err := r.ClientSet.CertificatesV1().CertificateSigningRequests().UpdateApproval(ctx, csr.Name, csr, metav1.UpdateOptions{})
+	if err != nil {
+		klog.ErrorS(err, "failed to deny CSR", "name", csr.Name)
+		r.Recorder.Eventf(csr, "Warning", "Failed", "Failed to deny CSR, %v", err)
+		return err
+	}
+	klog.InfoS("Denied CSR", "name", csr.Name, "reason", reason)
+	r.Recorder.Event(csr, "Warning", reason, message)
+	return nil
+}
+
+// ownerKubeconfig returns the kubeconfig owning the CSR, or nil if it does not exist anymore
+func ownerKubeconfig(ctx context.Context, c client.Reader, csr *certificatesv1.CertificateSigningRequest) (*kubeconfigv1alpha1.Kubeconfig, error) {
+	for _, o := range csr.OwnerReferences {
+		if o.Kind != "Kubeconfig" || o.APIVersion != KubeconfigOperatorAPIVersionV1Alpha1 {
+			continue
+		}
+		kubeconfig := &kubeconfigv1alpha1.Kubeconfig{}
+		err := c.Get(ctx, types.NamespacedName{Name: o.Name}, kubeconfig)
+		if errors.IsNotFound(err) {
+			return nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if kubeconfig.UID != o.UID {
+			// the CSR belongs to a deleted kubeconfig of the same name
+			return nil, nil
+		}
+		return kubeconfig, nil
+	}
+	return nil, nil
+}
+
+// pendingCSRs maps changes of approval policies to all pending CSRs of kubeconfigs, such that they are evaluated again
+func (r *CertificateSigningRequestReconciler) pendingCSRs(obj client.Object) []reconcile.Request {
+	csrs := &certificatesv1.CertificateSigningRequestList{}
+	err := r.List(context.Background(), csrs)
+	if err != nil {
+		klog.ErrorS(err, "failed to list CSRs for approval policy", "name", obj.GetName())
+		return nil
+	}
+	var requests []reconcile.Request
+	for i := range csrs.Items {
+		csr := &csrs.Items[i]
+		approved, denied, failed := getCertApprovalCondition(csr.Status.Conditions)
+		if !isKubeconfigV1Alpha1CSR(csr) || approved || denied || failed {
+			continue
+		}
+		requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: csr.Name}})
+	}
+	return requests
+}
+
 func (r *CertificateSigningRequestReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&certificatesv1.CertificateSigningRequest{}).
+		Watches(&source.Kind{Type: &kubeconfigv1alpha1.ApprovalPolicy{}}, handler.EnqueueRequestsFromMapFunc(r.pendingCSRs)).
+		Watches(&source.Kind{Type: &kubeconfigv1alpha1.KubeconfigApproval{}}, handler.EnqueueRequestsFromMapFunc(approvalCSR)).
 		Complete(r)
 }
 
@@ -177,6 +393,20 @@ func parseCSR(pemBytes []byte) (*x509.CertificateRequest, error) {
 	return csr, nil
 }
 
+// parseCertificate unwraps the first PEM block of a certificate chain
+func parseCertificate(pemBytes []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil || block.Type != x509TypeCertificate {
+		return nil, errs.New("PEM block type must be CERTIFICATE")
+	}
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	return cert, nil
+}
+
 func setStatusCondition(conditions *[]certificatesv1.CertificateSigningRequestCondition, newCondition certificatesv1.CertificateSigningRequestCondition) {
 	if conditions == nil {
 		return
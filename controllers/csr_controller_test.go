@@ -0,0 +1,171 @@
+/*
+Copyright 2022 zoomoid.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	kubeconfigv1alpha1 "github.com/zoomoid/kubeconfig-operator/api/v1alpha1"
+	"github.com/zoomoid/kubeconfig-operator/controllers/phases"
+	certificatesv1 "k8s.io/api/certificates/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("CSR approval", func() {
+	var r *CertificateSigningRequestReconciler
+	var kubeconfig *kubeconfigv1alpha1.Kubeconfig
+
+	BeforeEach(func() {
+		r = newTestCSRReconciler()
+		kubeconfig = newTestKubeconfig("csr")
+		kubeconfig.Spec.AutoApproveCSR = true
+		Expect(k8sClient.Create(ctx, kubeconfig)).To(Succeed())
+	})
+
+	It("approves CSRs that match their kubeconfig", func() {
+		decision, reason := reconcileCSR(r, createTestCSR(kubeconfig, kubeconfig))
+		Expect(decision).To(Equal(certificatesv1.CertificateApproved))
+		Expect(reason).To(Equal("KubeconfigControllerApprove"))
+	})
+
+	It("leaves CSRs of kubeconfigs without automatic approval pending", func() {
+		kubeconfig.Spec.AutoApproveCSR = false
+		decision, _ := reconcileCSR(r, createTestCSR(kubeconfig, kubeconfig))
+		Expect(decision).To(BeEmpty())
+	})
+
+	It("denies CSRs requested for another user", func() {
+		mallory := kubeconfig.DeepCopy()
+		mallory.Spec.Username = "mallory"
+		decision, reason := reconcileCSR(r, createTestCSR(kubeconfig, mallory))
+		Expect(decision).To(Equal(certificatesv1.CertificateDenied))
+		Expect(reason).To(Equal("KubeconfigMismatch"))
+	})
+
+	It("denies CSRs requesting other groups than their kubeconfig", func() {
+		requester := kubeconfig.DeepCopy()
+		requester.Spec.Groups = []string{"system:masters"}
+		decision, reason := reconcileCSR(r, createTestCSR(kubeconfig, requester))
+		Expect(decision).To(Equal(certificatesv1.CertificateDenied))
+		Expect(reason).To(Equal("KubeconfigMismatch"))
+	})
+
+	It("denies CSRs requesting other usages than their kubeconfig", func() {
+		requester := newTestReconciler()
+		material, err := requester.createCSR(ctx, kubeconfig)
+		Expect(err).NotTo(HaveOccurred())
+		csr := requester.createCsr(kubeconfig, material.CSR)
+		csr.Spec.Usages = append(csr.Spec.Usages, certificatesv1.UsageServerAuth)
+		Expect(k8sClient.Create(ctx, csr)).To(Succeed())
+
+		decision, reason := reconcileCSR(r, csr)
+		Expect(decision).To(Equal(certificatesv1.CertificateDenied))
+		Expect(reason).To(Equal("KubeconfigMismatch"))
+	})
+
+	It("denies CSRs whose kubeconfig no longer exists", func() {
+		csr := createTestCSR(kubeconfig, kubeconfig)
+		Expect(k8sClient.Delete(ctx, kubeconfig)).To(Succeed())
+		decision, reason := reconcileCSR(r, csr)
+		Expect(decision).To(Equal(certificatesv1.CertificateDenied))
+		Expect(reason).To(Equal("KubeconfigMismatch"))
+	})
+})
+
+var _ = Describe("CSR denial", func() {
+	var r *CertificateSigningRequestReconciler
+	var kubeconfig *kubeconfigv1alpha1.Kubeconfig
+	var policy *kubeconfigv1alpha1.ApprovalPolicy
+
+	BeforeEach(func() {
+		r = newTestCSRReconciler()
+		kubeconfig = newTestKubeconfig("denial")
+		kubeconfig.Spec.AutoApproveCSR = true
+		Expect(k8sClient.Create(ctx, kubeconfig)).To(Succeed())
+		// the test kubeconfigs request ECDSA keys, which violate this policy
+		policy = &kubeconfigv1alpha1.ApprovalPolicy{
+			ObjectMeta: metav1.ObjectMeta{Name: uniqueName("rsa-only")},
+			Spec: kubeconfigv1alpha1.ApprovalPolicySpec{
+				Usernames:            []string{kubeconfig.Spec.Username},
+				AllowedKeyAlgorithms: []kubeconfigv1alpha1.KeyAlgorithm{kubeconfigv1alpha1.KeyAlgorithmRSA},
+				OnViolation:          kubeconfigv1alpha1.ViolationActionDeny,
+			},
+		}
+		Expect(k8sClient.Create(ctx, policy)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		// policies change the decisions about the CSRs of all other specs
+		Expect(k8sClient.Delete(ctx, policy)).To(Succeed())
+	})
+
+	It("denies CSRs violating an approval policy", func() {
+		decision, reason := reconcileCSR(r, createTestCSR(kubeconfig, kubeconfig))
+		Expect(decision).To(Equal(certificatesv1.CertificateDenied))
+		Expect(reason).To(Equal("ApprovalPolicyViolated"))
+	})
+
+	It("propagates the denial to the kubeconfig until a fresh CSR is requested", func() {
+		kr := newTestReconciler()
+		_, err := reconcileKubeconfig(kr, kubeconfig)
+		Expect(err).NotTo(HaveOccurred())
+		denied, err := clientSet.CertificatesV1().CertificateSigningRequests().Get(ctx, getKubeconfig(kubeconfig).Status.Csr.Name, metav1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		decision, _ := reconcileCSR(r, denied)
+		Expect(decision).To(Equal(certificatesv1.CertificateDenied))
+
+		_, err = reconcileKubeconfig(kr, kubeconfig)
+		Expect(err).NotTo(HaveOccurred())
+		current := getKubeconfig(kubeconfig)
+		Expect(current.Status.Status).To(Equal(phases.PhaseDenied))
+		approved := meta.FindStatusCondition(current.Status.Conditions, kubeconfigv1alpha1.ConditionTypeCSRApproved)
+		Expect(approved).NotTo(BeNil())
+		Expect(approved.Status).To(Equal(metav1.ConditionFalse))
+		Expect(approved.Reason).To(Equal("ApprovalPolicyViolated"))
+		Expect(approved.Message).To(ContainSubstring(policy.Name))
+		finished := meta.FindStatusCondition(current.Status.Conditions, kubeconfigv1alpha1.ConditionTypeKubeconfigFinished)
+		Expect(finished).NotTo(BeNil())
+		Expect(finished.Reason).To(Equal("CsrDenied"))
+		Expect(finished.Message).To(ContainSubstring(ReRequestAnnotationKey))
+
+		By("not retrying denied CSRs automatically")
+		kr.Retry = RetryOptions{MaxRetries: 3}
+		_, err = reconcileKubeconfig(kr, kubeconfig)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(getKubeconfig(kubeconfig).Status.Status).To(Equal(phases.PhaseDenied))
+
+		By("requesting a fresh CSR when annotated")
+		current = getKubeconfig(kubeconfig)
+		current.Annotations = map[string]string{ReRequestAnnotationKey: ""}
+		Expect(k8sClient.Update(ctx, current)).To(Succeed())
+		_, err = reconcileKubeconfig(kr, kubeconfig)
+		Expect(err).NotTo(HaveOccurred())
+		current = getKubeconfig(kubeconfig)
+		Expect(current.Annotations).NotTo(HaveKey(ReRequestAnnotationKey))
+		Expect(current.Status.Status).To(Equal(phases.PhasePending))
+		_, err = reconcileKubeconfig(kr, kubeconfig)
+		Expect(err).NotTo(HaveOccurred())
+		current = getKubeconfig(kubeconfig)
+		Expect(current.Status.Status).To(Equal(phases.PhaseAwaitingApproval))
+		fresh, err := clientSet.CertificatesV1().CertificateSigningRequests().Get(ctx, current.Status.Csr.Name, metav1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fresh.UID).NotTo(Equal(denied.UID))
+		Expect(fresh.Status.Conditions).To(BeEmpty())
+	})
+})
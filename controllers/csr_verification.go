@@ -0,0 +1,111 @@
+/*
+Copyright 2022 zoomoid.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"crypto/x509"
+	"fmt"
+	"sort"
+	"strings"
+
+	kubeconfigv1alpha1 "github.com/zoomoid/kubeconfig-operator/api/v1alpha1"
+	certificatesv1 "k8s.io/api/certificates/v1"
+)
+
+// KubeconfigSignerName is the default signer that issues the client certificates of kubeconfigs
+const KubeconfigSignerName = certificatesv1.KubeAPIServerClientSignerName
+
+// signerOrDefault returns the configured signer name, or KubeconfigSignerName if none is configured
+func signerOrDefault(signerName string) string {
+	if signerName == "" {
+		return KubeconfigSignerName
+	}
+	return signerName
+}
+
+// signerNameFor returns the signer requested by the kubeconfig's CSRs, which is .spec.csr.signerName if set and the
+// operator's default signer otherwise
+func signerNameFor(kubeconfig *kubeconfigv1alpha1.Kubeconfig, defaultSignerName string) string {
+	if kubeconfig.Spec.CSR != nil && kubeconfig.Spec.CSR.SignerName != "" {
+		return kubeconfig.Spec.CSR.SignerName
+	}
+	return signerOrDefault(defaultSignerName)
+}
+
+// usagesFor returns the key usages requested by the kubeconfig's CSRs, which default to client auth only
+func usagesFor(kubeconfig *kubeconfigv1alpha1.Kubeconfig) []certificatesv1.KeyUsage {
+	if kubeconfig.Spec.CSR != nil && len(kubeconfig.Spec.CSR.Usages) > 0 {
+		return kubeconfig.Spec.CSR.Usages
+	}
+	return []certificatesv1.KeyUsage{certificatesv1.UsageClientAuth}
+}
+
+// usageStrings converts key usages to their string values
+func usageStrings(usages []certificatesv1.KeyUsage) []string {
+	values := make([]string, 0, len(usages))
+	for _, usage := range usages {
+		values = append(values, string(usage))
+	}
+	return values
+}
+
+// verifyRequestSubject checks that the subject of a certificate request carries the kubeconfig's identity, i.e.,
+// its username as common name and exactly the organizations, i.e., groups, declared in the kubeconfig's spec
+func verifyRequestSubject(request *x509.CertificateRequest, kubeconfig *kubeconfigv1alpha1.Kubeconfig) []string {
+	var mismatches []string
+	if request.Subject.CommonName != kubeconfig.Spec.Username {
+		mismatches = append(mismatches, fmt.Sprintf("common name %q does not match username %q", request.Subject.CommonName, kubeconfig.Spec.Username))
+	}
+	requested := sortedSet(request.Subject.Organization)
+	declared := sortedSet(certificateOrganizations(kubeconfig))
+	if strings.Join(requested, ",") != strings.Join(declared, ",") {
+		mismatches = append(mismatches, fmt.Sprintf("organizations [%s] do not match the kubeconfig's organizations and groups [%s]", strings.Join(requested, ", "), strings.Join(declared, ", ")))
+	}
+	return mismatches
+}
+
+// verifyCSR cross-checks a CSR against the kubeconfig owning it, such that no other identity, signer or usage can be
+// requested in the kubeconfig's name
+func verifyCSR(csr *certificatesv1.CertificateSigningRequest, request *x509.CertificateRequest, kubeconfig *kubeconfigv1alpha1.Kubeconfig, defaultSignerName string) []string {
+	mismatches := verifyRequestSubject(request, kubeconfig)
+	if signerName := signerNameFor(kubeconfig, defaultSignerName); csr.Spec.SignerName != signerName {
+		mismatches = append(mismatches, fmt.Sprintf("signer %s is not %s", csr.Spec.SignerName, signerName))
+	}
+	requested := sortedSet(usageStrings(csr.Spec.Usages))
+	declared := sortedSet(usageStrings(usagesFor(kubeconfig)))
+	if strings.Join(requested, ",") != strings.Join(declared, ",") {
+		mismatches = append(mismatches, fmt.Sprintf("usages [%s] do not match the kubeconfig's usages [%s]", strings.Join(requested, ", "), strings.Join(declared, ", ")))
+	}
+	if len(request.DNSNames) > 0 || len(request.EmailAddresses) > 0 || len(request.IPAddresses) > 0 || len(request.URIs) > 0 {
+		mismatches = append(mismatches, "subject alternative names are not allowed in client certificates")
+	}
+	return mismatches
+}
+
+// sortedSet returns the sorted unique values
+func sortedSet(values []string) []string {
+	seen := map[string]bool{}
+	set := []string{}
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			set = append(set, value)
+		}
+	}
+	sort.Strings(set)
+	return set
+}
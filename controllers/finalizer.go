@@ -0,0 +1,126 @@
+/*
+Copyright 2022 zoomoid.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	kubeconfigv1alpha1 "github.com/zoomoid/kubeconfig-operator/api/v1alpha1"
+	certificatesv1 "k8s.io/api/certificates/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// KubeconfigFinalizer is added to every kubeconfig such that the user's credentials and access are removed
+// before the kubeconfig is released, instead of relying on garbage collection to eventually catch up
+const KubeconfigFinalizer = "kubeconfig.k8s.zoomoid.dev/cleanup"
+
+// finalize removes the role bindings, CSRs and secrets created for the kubeconfig and then releases
+// the kubeconfig by removing the finalizer
+func (r *KubeconfigReconciler) finalize(ctx context.Context, kubeconfig *kubeconfigv1alpha1.Kubeconfig) (ctrl.Result, error) {
+	if !controllerutil.ContainsFinalizer(kubeconfig, KubeconfigFinalizer) {
+		return ctrl.Result{}, nil
+	}
+	klog.V(0).InfoS("Cleaning up kubeconfig", "name", kubeconfig.Name)
+
+	// syncing an empty binding set prunes all bindings of the kubeconfig
+	bindings := r.createBindings(kubeconfig)
+	bindings.bindings = nil
+	err := syncBindings(ctx, r.Client, r.Scheme, bindings)
+	if err != nil {
+		r.Recorder.Eventf(kubeconfig, "Warning", "CleanupFailed", "Failed to remove role bindings, %v", err)
+		return ctrl.Result{}, err
+	}
+
+	err = r.deleteCSRs(ctx, kubeconfig)
+	if err != nil {
+		r.Recorder.Eventf(kubeconfig, "Warning", "CleanupFailed", "Failed to remove certificate signing requests, %v", err)
+		return ctrl.Result{}, err
+	}
+
+	if kubeconfig.UsesServiceAccountToken() {
+		err = r.deleteServiceAccount(ctx, kubeconfig)
+		if err != nil {
+			r.Recorder.Eventf(kubeconfig, "Warning", "CleanupFailed", "Failed to remove service account, %v", err)
+			return ctrl.Result{}, err
+		}
+	}
+
+	var secrets []kubeconfigv1alpha1.SecretRef
+	if kubeconfig.Status.UserSecret.Name != "" {
+		secrets = append(secrets, kubeconfig.Status.UserSecret)
+	}
+	if kubeconfig.Spec.ExistingCSR != nil && *kubeconfig.Spec.ExistingCSR != kubeconfig.Status.UserSecret {
+		secrets = append(secrets, *kubeconfig.Spec.ExistingCSR)
+	}
+	err = r.deleteSecrets(ctx, kubeconfig, secrets)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if kubeconfig.Status.TargetSecret != nil {
+		err = r.deleteTargetSecret(ctx, kubeconfig, *kubeconfig.Status.TargetSecret)
+		if err != nil {
+			r.Recorder.Eventf(kubeconfig, "Warning", "CleanupFailed", "Failed to remove target secret, %v", err)
+			return ctrl.Result{}, err
+		}
+	}
+
+	r.Recorder.Eventf(kubeconfig, "Normal", "CleanedUp", "Removed role bindings, CSRs and secrets of user %s", kubeconfig.Spec.Username)
+	controllerutil.RemoveFinalizer(kubeconfig, KubeconfigFinalizer)
+	err = r.Update(ctx, kubeconfig)
+	if err != nil {
+		klog.ErrorS(err, "failed to remove finalizer from kubeconfig", "name", kubeconfig.Name)
+		return ctrl.Result{}, err
+	}
+	klog.V(2).InfoS("Released kubeconfig", "name", kubeconfig.Name)
+	return ctrl.Result{}, nil
+}
+
+// deleteCSRs removes all CSRs created for the kubeconfig
+func (r *KubeconfigReconciler) deleteCSRs(ctx context.Context, kubeconfig *kubeconfigv1alpha1.Kubeconfig) error {
+	err := r.DeleteAllOf(ctx, &certificatesv1.CertificateSigningRequest{}, client.MatchingLabels{
+		"kubeconfig-operator.k8s.zoomoid.dev/for": kubeconfig.Name,
+	})
+	if err != nil && !apierrors.IsNotFound(err) {
+		klog.ErrorS(err, "failed to delete CSRs of kubeconfig", "name", kubeconfig.Name)
+		return err
+	}
+	return nil
+}
+
+// deleteSecrets removes the referenced secrets, ignoring secrets that are already gone
+func (r *KubeconfigReconciler) deleteSecrets(ctx context.Context, kubeconfig *kubeconfigv1alpha1.Kubeconfig, refs []kubeconfigv1alpha1.SecretRef) error {
+	for _, ref := range refs {
+		err := r.Delete(ctx, &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: ref.Namespace,
+				Name:      ref.Name,
+			},
+		})
+		if err != nil && !apierrors.IsNotFound(err) {
+			klog.ErrorS(err, "failed to delete secret of kubeconfig", "namespace", ref.Namespace, "name", ref.Name)
+			r.Recorder.Eventf(kubeconfig, "Warning", "CleanupFailed", "Failed to remove secret %s/%s, %v", ref.Namespace, ref.Name, err)
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+/*
+Copyright 2022 zoomoid.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	kubeconfigv1alpha1 "github.com/zoomoid/kubeconfig-operator/api/v1alpha1"
+	certificatesv1 "k8s.io/api/certificates/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+var _ = Describe("Kubeconfig finalizer", func() {
+	var r *KubeconfigReconciler
+	var namespace string
+
+	BeforeEach(func() {
+		r = newTestReconciler()
+		namespace = newTestNamespace("finalizer")
+	})
+
+	// deleteKubeconfig deletes the kubeconfig and reconciles it once to run the finalizer
+	deleteKubeconfig := func(kubeconfig *kubeconfigv1alpha1.Kubeconfig) {
+		Expect(k8sClient.Delete(ctx, kubeconfig)).To(Succeed())
+		_, err := reconcileKubeconfig(r, kubeconfig)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(apierrors.IsNotFound(k8sClient.Get(ctx, client.ObjectKeyFromObject(kubeconfig), &kubeconfigv1alpha1.Kubeconfig{}))).To(BeTrue())
+	}
+
+	// expectCSRsDeleted asserts that no CSR labelled for the kubeconfig remains
+	expectCSRsDeleted := func(kubeconfig *kubeconfigv1alpha1.Kubeconfig) {
+		csrs := &certificatesv1.CertificateSigningRequestList{}
+		Expect(k8sClient.List(ctx, csrs, client.MatchingLabels{"kubeconfig-operator.k8s.zoomoid.dev/for": kubeconfig.Name})).To(Succeed())
+		Expect(csrs.Items).To(BeEmpty())
+	}
+
+	It("removes everything created for a reconciled kubeconfig", func() {
+		kubeconfig := newTestKubeconfig("finalizer")
+		kubeconfig.Spec.Bindings = []kubeconfigv1alpha1.KubeconfigBinding{
+			{Namespace: namespace, RoleRef: rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: "edit"}},
+		}
+		kubeconfig.Spec.Target = &kubeconfigv1alpha1.KubeconfigTarget{Namespace: namespace, SecretName: "kubeconfig", Key: "config"}
+		Expect(k8sClient.Create(ctx, kubeconfig)).To(Succeed())
+		now := time.Now()
+		kubeconfig = reconcileIssued(r, kubeconfig, now, now.Add(24*time.Hour))
+		Expect(controllerutil.ContainsFinalizer(kubeconfig, KubeconfigFinalizer)).To(BeTrue())
+
+		clusterRoleBinding := types.NamespacedName{Name: fmt.Sprintf("%s-kubeconfig", kubeconfig.Spec.Username)}
+		roleBinding := types.NamespacedName{Namespace: namespace, Name: fmt.Sprintf("%s-clusterrole-edit", kubeconfig.Name)}
+		userSecret := types.NamespacedName{Namespace: kubeconfig.Status.UserSecret.Namespace, Name: kubeconfig.Status.UserSecret.Name}
+		targetSecret := types.NamespacedName{Namespace: namespace, Name: "kubeconfig"}
+		Expect(k8sClient.Get(ctx, clusterRoleBinding, &rbacv1.ClusterRoleBinding{})).To(Succeed())
+		Expect(k8sClient.Get(ctx, roleBinding, &rbacv1.RoleBinding{})).To(Succeed())
+		Expect(k8sClient.Get(ctx, userSecret, &corev1.Secret{})).To(Succeed())
+		Expect(k8sClient.Get(ctx, targetSecret, &corev1.Secret{})).To(Succeed())
+
+		deleteKubeconfig(kubeconfig)
+		Expect(apierrors.IsNotFound(k8sClient.Get(ctx, clusterRoleBinding, &rbacv1.ClusterRoleBinding{}))).To(BeTrue())
+		Expect(apierrors.IsNotFound(k8sClient.Get(ctx, roleBinding, &rbacv1.RoleBinding{}))).To(BeTrue())
+		expectCSRsDeleted(kubeconfig)
+		Expect(apierrors.IsNotFound(k8sClient.Get(ctx, userSecret, &corev1.Secret{}))).To(BeTrue())
+		Expect(apierrors.IsNotFound(k8sClient.Get(ctx, targetSecret, &corev1.Secret{}))).To(BeTrue())
+	})
+
+	It("leaves a target secret in place that is not managed by the kubeconfig", func() {
+		kubeconfig := newTestKubeconfig("finalizer")
+		kubeconfig.Spec.Target = &kubeconfigv1alpha1.KubeconfigTarget{Namespace: namespace, SecretName: "kubeconfig", Key: "config"}
+		Expect(k8sClient.Create(ctx, kubeconfig)).To(Succeed())
+		now := time.Now()
+		kubeconfig = reconcileIssued(r, kubeconfig, now, now.Add(24*time.Hour))
+
+		By("replacing the target secret with one created by someone else")
+		targetSecret := types.NamespacedName{Namespace: namespace, Name: "kubeconfig"}
+		Expect(k8sClient.Delete(ctx, &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: "kubeconfig"}})).To(Succeed())
+		unmanaged := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: "kubeconfig"},
+			Data:       map[string][]byte{"config": []byte("unrelated")},
+		}
+		Expect(k8sClient.Create(ctx, unmanaged)).To(Succeed())
+
+		deleteKubeconfig(kubeconfig)
+		secret := &corev1.Secret{}
+		Expect(k8sClient.Get(ctx, targetSecret, secret)).To(Succeed())
+		Expect(secret.UID).To(Equal(unmanaged.UID))
+		Expect(secret.Data).To(HaveKeyWithValue("config", []byte("unrelated")))
+		Expect(apierrors.IsNotFound(k8sClient.Get(ctx, types.NamespacedName{Namespace: kubeconfig.Status.UserSecret.Namespace, Name: kubeconfig.Status.UserSecret.Name}, &corev1.Secret{}))).To(BeTrue())
+	})
+
+	It("removes the secret of an existing CSR", func() {
+		kubeconfig := newTestKubeconfig("finalizer")
+		material, err := r.createCSR(ctx, kubeconfig)
+		Expect(err).NotTo(HaveOccurred())
+		existing := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: "existing-csr"},
+			Data:       map[string][]byte{CertificateSecretCSRKey: material.CSR},
+		}
+		Expect(k8sClient.Create(ctx, existing)).To(Succeed())
+		kubeconfig.Spec.ExistingCSR = &kubeconfigv1alpha1.SecretRef{Namespace: namespace, Name: existing.Name}
+		createTestKubeconfig(kubeconfig)
+		_, err = reconcileKubeconfig(r, kubeconfig)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(getKubeconfig(kubeconfig).Status.Csr.Name).NotTo(BeEmpty())
+
+		deleteKubeconfig(getKubeconfig(kubeconfig))
+		expectCSRsDeleted(kubeconfig)
+		Expect(apierrors.IsNotFound(k8sClient.Get(ctx, client.ObjectKeyFromObject(existing), &corev1.Secret{}))).To(BeTrue())
+	})
+
+	It("removes the ServiceAccount of a kubeconfig in the ServiceAccountToken auth mode", func() {
+		kubeconfig := newTestKubeconfig("finalizer")
+		kubeconfig.Spec.AuthMode = kubeconfigv1alpha1.AuthModeServiceAccountToken
+		kubeconfig.Spec.CSR = nil
+		createTestKubeconfig(kubeconfig)
+		// adds the finalizer, the token is only requested once the kubeconfig is approved
+		_, err := reconcileKubeconfig(r, kubeconfig)
+		Expect(err).NotTo(HaveOccurred())
+		kubeconfig = getKubeconfig(kubeconfig)
+		Expect(controllerutil.ContainsFinalizer(kubeconfig, KubeconfigFinalizer)).To(BeTrue())
+
+		name := serviceAccountFor(kubeconfig)
+		serviceAccount := &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Namespace: name.Namespace, Name: name.Name}}
+		Expect(controllerutil.SetControllerReference(kubeconfig, serviceAccount, r.Scheme)).To(Succeed())
+		Expect(k8sClient.Create(ctx, serviceAccount)).To(Succeed())
+
+		deleteKubeconfig(kubeconfig)
+		Expect(apierrors.IsNotFound(k8sClient.Get(ctx, name, &corev1.ServiceAccount{}))).To(BeTrue())
+	})
+})
@@ -0,0 +1,286 @@
+/*
+Copyright 2022 zoomoid.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"time"
+
+	. "github.com/onsi/gomega"
+
+	kubeconfigv1alpha1 "github.com/zoomoid/kubeconfig-operator/api/v1alpha1"
+	certificatesv1 "k8s.io/api/certificates/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// These are the fixtures shared by all specs of the controller suite. Kubeconfigs and most of the objects created
+// for them are cluster-scoped, so every spec uses unique names instead of cleaning up after itself
+
+// clusterCA is the CA published in kube-public/kube-root-ca.crt, which also issues the certificates of CSRs in
+// place of the kube-controller-manager's signer
+var clusterCA *testCA
+
+var names int
+
+// uniqueName returns a name that no other spec uses
+func uniqueName(prefix string) string {
+	names++
+	return fmt.Sprintf("%s-%d", prefix, names)
+}
+
+// newTestReconciler returns a kubeconfig reconciler on the test environment
+func newTestReconciler() *KubeconfigReconciler {
+	return &KubeconfigReconciler{
+		Client:    k8sClient,
+		Scheme:    scheme.Scheme,
+		Recorder:  record.NewFakeRecorder(1024),
+		ClientSet: clientSet,
+	}
+}
+
+// newTestCSRReconciler returns a CSR reconciler on the test environment that requires a quorum of two approvals for
+// kubeconfigs binding cluster-admin
+func newTestCSRReconciler() *CertificateSigningRequestReconciler {
+	return &CertificateSigningRequestReconciler{
+		Client:    k8sClient,
+		Scheme:    scheme.Scheme,
+		Recorder:  record.NewFakeRecorder(1024),
+		ClientSet: clientSet,
+		Approval:  ApprovalOptions{Quorum: 2, PrivilegedClusterRoles: []string{"cluster-admin"}},
+	}
+}
+
+// newTestKubeconfig returns a kubeconfig whose name and username are unique. It requests an ECDSA key, which is
+// generated considerably faster than the default RSA key
+func newTestKubeconfig(prefix string) *kubeconfigv1alpha1.Kubeconfig {
+	name := uniqueName(prefix)
+	return &kubeconfigv1alpha1.Kubeconfig{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: kubeconfigv1alpha1.KubeconfigSpec{
+			Username: name,
+			AuthMode: kubeconfigv1alpha1.AuthModeClientCertificate,
+			Cluster:  &kubeconfigv1alpha1.Cluster{Name: "kubernetes", Server: "https://127.0.0.1:6443"},
+			CSR:      &kubeconfigv1alpha1.CertificateSigningRequest{SignatureAlgorithm: kubeconfigv1alpha1.ECDSAWithSHA256},
+			RoleRef:  &rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: "view"},
+		},
+	}
+}
+
+// createTestKubeconfig creates the kubeconfig with a pending KubeconfigFinished condition, such that its status can
+// be updated by functions that do not set any conditions themselves
+func createTestKubeconfig(kubeconfig *kubeconfigv1alpha1.Kubeconfig) {
+	Expect(k8sClient.Create(ctx, kubeconfig)).To(Succeed())
+	meta.SetStatusCondition(&kubeconfig.Status.Conditions, metav1.Condition{
+		Type:    kubeconfigv1alpha1.ConditionTypeKubeconfigFinished,
+		Status:  metav1.ConditionUnknown,
+		Reason:  "Pending",
+		Message: "Created by the test suite",
+	})
+	Expect(k8sClient.Status().Update(ctx, kubeconfig)).To(Succeed())
+}
+
+// createTestKubeconfigGroup creates a KubeconfigGroup with a unique name that binds the given cluster-wide roles
+func createTestKubeconfigGroup(roleRefs ...rbacv1.RoleRef) *kubeconfigv1alpha1.KubeconfigGroup {
+	group := &kubeconfigv1alpha1.KubeconfigGroup{ObjectMeta: metav1.ObjectMeta{Name: uniqueName("group")}}
+	for _, roleRef := range roleRefs {
+		group.Spec.Bindings = append(group.Spec.Bindings, kubeconfigv1alpha1.KubeconfigBinding{RoleRef: roleRef})
+	}
+	Expect(k8sClient.Create(ctx, group)).To(Succeed())
+	return group
+}
+
+// createTestApproval creates an approval of the kubeconfig by the approver, either of the CSR with the given UID or,
+// without a UID, of the kubeconfig's current spec. Approvals are completed as the admission webhook would complete them
+func createTestApproval(kubeconfig *kubeconfigv1alpha1.Kubeconfig, approver string, csrUID types.UID) *kubeconfigv1alpha1.KubeconfigApproval {
+	approval := &kubeconfigv1alpha1.KubeconfigApproval{
+		ObjectMeta: metav1.ObjectMeta{Name: uniqueName("approval")},
+		Spec: kubeconfigv1alpha1.KubeconfigApprovalSpec{
+			Kubeconfig: kubeconfig.Name,
+			Approver:   approver,
+			CSRUID:     csrUID,
+		},
+	}
+	if csrUID == "" {
+		approval.Spec.Generation = kubeconfig.Generation
+		approval.Spec.SpecHash = kubeconfig.ApprovalSpecHash()
+	}
+	Expect(k8sClient.Create(ctx, approval)).To(Succeed())
+	return approval
+}
+
+// newTestNamespace creates a namespace with a unique name
+func newTestNamespace(prefix string) string {
+	namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: uniqueName(prefix)}}
+	Expect(k8sClient.Create(ctx, namespace)).To(Succeed())
+	return namespace.Name
+}
+
+// getKubeconfig returns the kubeconfig as currently stored in the test environment
+func getKubeconfig(kubeconfig *kubeconfigv1alpha1.Kubeconfig) *kubeconfigv1alpha1.Kubeconfig {
+	current := &kubeconfigv1alpha1.Kubeconfig{}
+	Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(kubeconfig), current)).To(Succeed())
+	return current
+}
+
+// getUserSecret returns the user secret of a kubeconfig in the ClientCertificate auth mode
+func getUserSecret(kubeconfig *kubeconfigv1alpha1.Kubeconfig) *corev1.Secret {
+	secret := &corev1.Secret{}
+	key := types.NamespacedName{Namespace: operatorNamespace, Name: fmt.Sprintf("%s-client-cert", kubeconfig.Name)}
+	Expect(k8sClient.Get(ctx, key, secret)).To(Succeed())
+	return secret
+}
+
+// createTestCSR creates the CSR that the controller requests for the kubeconfig, with the private key generated for
+// the requester. Passing a requester other than the kubeconfig creates CSRs that do not match their kubeconfig
+func createTestCSR(kubeconfig *kubeconfigv1alpha1.Kubeconfig, requester *kubeconfigv1alpha1.Kubeconfig) *certificatesv1.CertificateSigningRequest {
+	r := newTestReconciler()
+	material, err := r.createCSR(ctx, requester)
+	Expect(err).NotTo(HaveOccurred())
+	csr := r.createCsr(kubeconfig, material.CSR)
+	Expect(k8sClient.Create(ctx, csr)).To(Succeed())
+	return csr
+}
+
+// reconcileCSR runs a single reconciliation of the CSR and returns the decision about it, i.e., the type and reason
+// of its Approved or Denied condition, or empty strings if the CSR is still pending
+func reconcileCSR(r *CertificateSigningRequestReconciler, csr *certificatesv1.CertificateSigningRequest) (certificatesv1.RequestConditionType, string) {
+	_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(csr)})
+	Expect(err).NotTo(HaveOccurred())
+	current, err := clientSet.CertificatesV1().CertificateSigningRequests().Get(ctx, csr.Name, metav1.GetOptions{})
+	Expect(err).NotTo(HaveOccurred())
+	for _, condition := range current.Status.Conditions {
+		if condition.Status == corev1.ConditionTrue {
+			return condition.Type, condition.Reason
+		}
+	}
+	return "", ""
+}
+
+// reconcileKubeconfig runs a single reconciliation of the kubeconfig
+func reconcileKubeconfig(r *KubeconfigReconciler, kubeconfig *kubeconfigv1alpha1.Kubeconfig) (ctrl.Result, error) {
+	return r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(kubeconfig)})
+}
+
+// reconcileIssued reconciles the kubeconfig until its CSR is requested, issues the CSR's certificate for the given
+// validity, and reconciles the kubeconfig again to deliver it. It returns the finished kubeconfig
+func reconcileIssued(r *KubeconfigReconciler, kubeconfig *kubeconfigv1alpha1.Kubeconfig, notBefore time.Time, notAfter time.Time) *kubeconfigv1alpha1.Kubeconfig {
+	_, err := reconcileKubeconfig(r, kubeconfig)
+	Expect(err).NotTo(HaveOccurred())
+	csrName := getKubeconfig(kubeconfig).Status.Csr.Name
+	Expect(csrName).NotTo(BeEmpty())
+	clusterCA.issueCSR(csrName, notBefore, notAfter)
+	_, err = reconcileKubeconfig(r, kubeconfig)
+	Expect(err).NotTo(HaveOccurred())
+	finished := getKubeconfig(kubeconfig)
+	Expect(isFinished(finished)).To(BeTrue())
+	return finished
+}
+
+// testCA is a self-signed CA for issuing client certificates in specs
+type testCA struct {
+	cert    *x509.Certificate
+	key     crypto.Signer
+	certPEM []byte
+	keyPEM  []byte
+}
+
+// newTestCA returns a CA that is valid until notAfter
+func newTestCA(notAfter time.Time) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).NotTo(HaveOccurred())
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "kubeconfig-operator-test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	Expect(err).NotTo(HaveOccurred())
+	cert, err := x509.ParseCertificate(der)
+	Expect(err).NotTo(HaveOccurred())
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	Expect(err).NotTo(HaveOccurred())
+	return &testCA{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: x509TypeCertificate, Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+// issue returns the PEM-encoded client certificate for the PEM-encoded certificate request
+func (ca *testCA) issue(request []byte, notBefore time.Time, notAfter time.Time) []byte {
+	csr, err := parseCSR(request)
+	Expect(err).NotTo(HaveOccurred())
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	Expect(err).NotTo(HaveOccurred())
+	template := &x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject:      csr.Subject,
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, csr.PublicKey, ca.key)
+	Expect(err).NotTo(HaveOccurred())
+	return pem.EncodeToMemory(&pem.Block{Type: x509TypeCertificate, Bytes: der})
+}
+
+// issueCSR approves the CSR and sets the certificate issued for its request, like the kube-controller-manager's
+// approver and signer do
+func (ca *testCA) issueCSR(name string, notBefore time.Time, notAfter time.Time) []byte {
+	csr := approveTestCSR(name)
+	csr.Status.Certificate = ca.issue(csr.Spec.Request, notBefore, notAfter)
+	_, err := clientSet.CertificatesV1().CertificateSigningRequests().UpdateStatus(ctx, csr, metav1.UpdateOptions{})
+	Expect(err).NotTo(HaveOccurred())
+	return csr.Status.Certificate
+}
+
+// approveTestCSR approves the CSR with the given name and returns it
+func approveTestCSR(name string) *certificatesv1.CertificateSigningRequest {
+	csr, err := clientSet.CertificatesV1().CertificateSigningRequests().Get(ctx, name, metav1.GetOptions{})
+	Expect(err).NotTo(HaveOccurred())
+	csr.Status.Conditions = append(csr.Status.Conditions, certificatesv1.CertificateSigningRequestCondition{
+		Type:    certificatesv1.CertificateApproved,
+		Status:  corev1.ConditionTrue,
+		Reason:  "TestApproved",
+		Message: "Approved by the test suite",
+	})
+	csr, err = clientSet.CertificatesV1().CertificateSigningRequests().UpdateApproval(ctx, name, csr, metav1.UpdateOptions{})
+	Expect(err).NotTo(HaveOccurred())
+	return csr
+}
@@ -18,20 +18,26 @@ package controllers
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
 	certificatesv1 "k8s.io/api/certificates/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	clientset "k8s.io/client-go/kubernetes"
 	"k8s.io/klog/v2"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	"k8s.io/client-go/tools/record"
 
 	kubeconfigv1alpha1 "github.com/zoomoid/kubeconfig-operator/api/v1alpha1"
 	"github.com/zoomoid/kubeconfig-operator/controllers/phases"
+	kcrypto "github.com/zoomoid/kubeconfig-operator/pkg/crypto"
 	corev1 "k8s.io/api/core/v1"
 	rbacv1 "k8s.io/api/rbac/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
@@ -44,14 +50,31 @@ type KubeconfigReconciler struct {
 	client.Client
 	Scheme   *runtime.Scheme
 	Recorder record.EventRecorder
+	// StatusMode determines how much of the generated kubeconfig is published in the status
+	StatusMode KubeconfigStatusMode
+	// Approval configures the multi-party approval of privileged kubeconfigs
+	Approval ApprovalOptions
+	// Retry configures the automatic retries of kubeconfigs that failed transiently
+	Retry RetryOptions
+	// ClientSet is used for requesting ServiceAccount tokens, which the controller-runtime client does not support
+	ClientSet clientset.Interface
+	// SignerName is the signer name requested by the CSRs of kubeconfigs, defaults to KubeconfigSignerName
+	SignerName string
+	// KeyGenerator generates the private keys of kubeconfigs, defaults to crypto.DefaultKeyGenerator
+	KeyGenerator kcrypto.KeyGenerator
 }
 
 // +kubebuilder:rbac:groups=kubeconfig.k8s.zoomoid.dev,resources=kubeconfigs,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=kubeconfig.k8s.zoomoid.dev,resources=kubeconfigs/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups=kubeconfig.k8s.zoomoid.dev,resources=kubeconfigs/finalizers,verbs=update
+// +kubebuilder:rbac:groups=kubeconfig.k8s.zoomoid.dev,resources=kubeconfigapprovals,verbs=get;list;watch
+// +kubebuilder:rbac:groups=kubeconfig.k8s.zoomoid.dev,resources=approvalpolicies,verbs=get;list;watch
+// +kubebuilder:rbac:groups=kubeconfig.k8s.zoomoid.dev,resources=revocationlists,verbs=get;list;watch;create;update;patch
 // +kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;list;watch
-// +kubebuilder:rbac:groups=certificates.k8s.io,resources=certificatesigningrequests,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=core,resources=serviceaccounts,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=core,resources=serviceaccounts/token,verbs=create
+// +kubebuilder:rbac:groups=certificates.k8s.io,resources=certificatesigningrequests,verbs=get;list;watch;create;update;patch;delete;deletecollection
 // +kubebuilder:rbac:groups=rbac.authorization.k8s.io,resources=clusterrolebindings,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=rbac.authorization.k8s.io,resources=rolebindings,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=*,resources=*,verbs=*
@@ -63,8 +86,7 @@ func (r *KubeconfigReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 	if err != nil {
 		klog.V(1).ErrorS(err, "failed to get kubeconfig object")
 		if apierrors.IsNotFound(err) {
-			// this is a delete request, kubernetes will garbage collect all other objects
-			// with ownership from this controller
+			// the kubeconfig is gone, its finalizer already removed all objects created for it
 			return ctrl.Result{}, nil
 		}
 		return ctrl.Result{}, err
@@ -72,8 +94,60 @@ func (r *KubeconfigReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 
 	klog.V(2).InfoS("Reconciling kubeconfig", "name", kubeconfig.Name)
 
+	if !kubeconfig.DeletionTimestamp.IsZero() {
+		return r.finalize(ctx, kubeconfig)
+	}
+	if !controllerutil.ContainsFinalizer(kubeconfig, KubeconfigFinalizer) {
+		controllerutil.AddFinalizer(kubeconfig, KubeconfigFinalizer)
+		err = r.Update(ctx, kubeconfig)
+		if err != nil {
+			klog.ErrorS(err, "failed to add finalizer to kubeconfig", "name", kubeconfig.Name)
+			return ctrl.Result{}, err
+		}
+	}
+
+	if hasRetryAnnotation(kubeconfig) {
+		return r.retry(ctx, kubeconfig)
+	}
+
+	if r.scrubStatusKubeconfig(kubeconfig) {
+		klog.V(0).InfoS("Removed unredacted kubeconfig from status", "name", kubeconfig.Name)
+		r.Recorder.Event(kubeconfig, "Normal", "StatusScrubbed", "Removed kubeconfig containing the client key from status")
+		err = r.Status().Update(ctx, kubeconfig)
+		if err != nil {
+			klog.ErrorS(err, "failed to scrub kubeconfig from status", "name", kubeconfig.Name)
+			return ctrl.Result{}, err
+		}
+	}
+
+	if kubeconfig.Spec.Revoked {
+		return r.reconcileRevoked(ctx, kubeconfig)
+	}
+
+	if kubeconfig.UsesServiceAccountToken() {
+		return r.reconcileServiceAccountToken(ctx, kubeconfig)
+	}
+	if kubeconfig.UsesOIDC() {
+		return r.reconcileOIDC(ctx, kubeconfig)
+	}
+
+	if !isFinished(kubeconfig) && credentialsSpecChanged(kubeconfig, kubeconfig.Status.Csr.SpecHash) {
+		// the current CSR was requested with outdated parameters, discard it instead of waiting for its approval
+		return r.rotateCredentials(ctx, kubeconfig)
+	}
+	if !isFinished(kubeconfig) && rolesChanged(kubeconfig, kubeconfig.Status.Csr.RolesHash) {
+		// the current CSR may be approved for other roles than those that would be bound
+		return r.reapproveRoles(ctx, kubeconfig)
+	}
+
 	terminal := isInTerminalCondition(kubeconfig)
 	if terminal {
+		if isFinished(kubeconfig) {
+			return r.reconcileFinished(ctx, kubeconfig)
+		}
+		if isRetryable(kubeconfig) {
+			return r.reconcileRetry(ctx, kubeconfig)
+		}
 		klog.V(2).InfoS("Kubeconfig is done, skipping reconciliation", "name", kubeconfig.Name)
 		return ctrl.Result{}, nil
 	}
@@ -90,6 +164,8 @@ func (r *KubeconfigReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 			klog.Error(err, "failed to get predefined secret for CSR data, waiting for appearance")
 			r.Recorder.Eventf(kubeconfig, "Warning", "NotFound", "Predefined secret for CSR could not be found, %v", err)
 			return ctrl.Result{Requeue: true, RequeueAfter: 1 * time.Minute}, err
+		} else if err != nil {
+			return ctrl.Result{}, err
 		}
 		kubeconfig.Status.UserSecret = kubeconfigv1alpha1.SecretRef{
 			Namespace: userSecret.Namespace,
@@ -101,7 +177,7 @@ func (r *KubeconfigReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 		userSecretName = types.NamespacedName{
 			Name: fmt.Sprintf("%s-client-cert", kubeconfig.Name),
 			// since secrets are namespaced, we need to create it somewhere, and we put this secret into the operator's namespace
-			Namespace: "kubeconfig-operator-system",
+			Namespace: operatorNamespace,
 		}
 		err = r.Get(ctx, userSecretName, userSecret)
 		if apierrors.IsNotFound(err) {
@@ -117,7 +193,9 @@ func (r *KubeconfigReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 					Status:  metav1.ConditionFalse,
 				})
 				kubeconfig.Status.Status = phases.PhaseFailed
-				r.Status().Update(ctx, kubeconfig)
+				if statusErr := r.Status().Update(ctx, kubeconfig); statusErr != nil {
+					klog.ErrorS(statusErr, "failed to update kubeconfig status", "name", kubeconfig.Name)
+				}
 				return ctrl.Result{}, err
 			}
 			kubeconfig.Status.UserSecret = kubeconfigv1alpha1.SecretRef{
@@ -136,42 +214,115 @@ func (r *KubeconfigReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 		}
 	}
 
+	if userSecret.Data == nil {
+		userSecret.Data = map[string][]byte{}
+	}
+
+	csrName := kubeconfig.Status.Csr.Name
+	if csrName == "" {
+		csrName = csrNameFor(kubeconfig)
+	}
 	csr := &certificatesv1.CertificateSigningRequest{}
-	err = r.Get(ctx, types.NamespacedName{Name: kubeconfig.Name}, csr)
+	err = r.Get(ctx, types.NamespacedName{Name: csrName}, csr)
+	if err == nil && kubeconfig.Status.Csr.Name == "" {
+		// the CSR was requested before, but never recorded in the status
+		adopted, result, err := r.adoptCSR(ctx, kubeconfig, csr, userSecret)
+		if !adopted {
+			return result, err
+		}
+	}
 	if apierrors.IsNotFound(err) {
 		// condition is either false or unknown, either way create a fresh CSR, create a fresh CSR
 		klog.V(2).InfoS("Creating a fresh CSR for kubeconfig", "name", kubeconfig.Name)
-		r.Recorder.Event(kubeconfig, "Normal", "Generating", "Generating CSR for kubeconfig")
-		keyBuffer, csrBuffer, err := r.createCSR(kubeconfig)
-		if err != nil {
-			// append failure condition to Kubeconfig object
-			meta.SetStatusCondition(&kubeconfig.Status.Conditions, metav1.Condition{
-				Type:    kubeconfigv1alpha1.ConditionTypeCSRCreated,
-				Reason:  "CsrCreateFailed",
-				Message: fmt.Sprintf("Failed to generate private key and certificate signing request, %v", err),
-				Status:  metav1.ConditionFalse,
-			})
-			kubeconfig.Status.Status = phases.PhaseFailed
-			r.Recorder.Eventf(kubeconfig, "Warning", "CsrFailed", "Failed to generate private key and certificate signing request, %v", err)
-			klog.ErrorS(err, "Failed to create CSR for kubeconfig", "name", kubeconfig.Name)
-			return ctrl.Result{}, nil
-		}
+		var csrPEM []byte
+		if kubeconfig.Spec.ExistingCSR != nil {
+			// the user brought their own CSR, submit it as-is such that the private key never leaves the user's hands
+			csrPEM = userSecret.Data[CertificateSecretCSRKey]
+			err = verifyExistingCSR(kubeconfig, csrPEM)
+			if err != nil {
+				meta.SetStatusCondition(&kubeconfig.Status.Conditions, metav1.Condition{
+					Type:    kubeconfigv1alpha1.ConditionTypeCSRCreated,
+					Reason:  "ExistingCsrInvalid",
+					Message: fmt.Sprintf("CSR in secret %s/%s is invalid, %v", userSecret.Namespace, userSecret.Name, err),
+					Status:  metav1.ConditionFalse,
+				})
+				meta.SetStatusCondition(&kubeconfig.Status.Conditions, metav1.Condition{
+					Type:    kubeconfigv1alpha1.ConditionTypeKubeconfigFinished,
+					Reason:  "Failed",
+					Message: fmt.Sprintf("Kubeconfig creation failed in CSR stage, annotate the kubeconfig with %s after fixing the CSR", RetryAnnotationKey),
+					Status:  metav1.ConditionFalse,
+				})
+				kubeconfig.Status.Status = phases.PhaseFailed
+				r.Recorder.Eventf(kubeconfig, "Warning", "CsrFailed", "CSR in secret %s/%s is invalid, %v", userSecret.Namespace, userSecret.Name, err)
+				klog.ErrorS(err, "Existing CSR for kubeconfig is invalid", "name", kubeconfig.Name)
+				err = r.Status().Update(ctx, kubeconfig)
+				if err != nil {
+					klog.ErrorS(err, "failed to update kubeconfig status", "name", kubeconfig.Name)
+					return ctrl.Result{}, err
+				}
+				return ctrl.Result{}, nil
+			}
+		} else {
+			r.Recorder.Event(kubeconfig, "Normal", "Generating", "Generating CSR for kubeconfig")
+			material, err := r.createCSR(ctx, kubeconfig)
+			if err != nil {
+				// invalid key parameters fail the same way on every retry, only other failures are transient
+				reason := "CsrCreateFailed"
+				if errors.Is(err, kcrypto.ErrUnsupportedAlgorithm) || errors.Is(err, kcrypto.ErrInvalidKeyParameters) || errors.Is(err, kcrypto.ErrUnsupportedEncoding) {
+					reason = "InvalidKeyParameters"
+				}
+				// append failure condition to Kubeconfig object
+				meta.SetStatusCondition(&kubeconfig.Status.Conditions, metav1.Condition{
+					Type:    kubeconfigv1alpha1.ConditionTypeCSRCreated,
+					Reason:  reason,
+					Message: fmt.Sprintf("Failed to generate private key and certificate signing request, %v", err),
+					Status:  metav1.ConditionFalse,
+				})
+				meta.SetStatusCondition(&kubeconfig.Status.Conditions, metav1.Condition{
+					Type:    kubeconfigv1alpha1.ConditionTypeKubeconfigFinished,
+					Reason:  reason,
+					Message: "Kubeconfig creation failed in CSR stage",
+					Status:  metav1.ConditionFalse,
+				})
+				kubeconfig.Status.Status = phases.PhaseFailed
+				r.Recorder.Eventf(kubeconfig, "Warning", "CsrFailed", "Failed to generate private key and certificate signing request, %v", err)
+				klog.ErrorS(err, "Failed to create CSR for kubeconfig", "name", kubeconfig.Name)
+				err = r.Status().Update(ctx, kubeconfig)
+				if err != nil {
+					klog.ErrorS(err, "failed to update kubeconfig status", "name", kubeconfig.Name)
+					return ctrl.Result{}, err
+				}
+				return ctrl.Result{Requeue: true}, nil
+			}
 
-		userSecret.Data[CertificateSecretPrivKeyKey] = keyBuffer.Bytes()
-		userSecret.Data[CertificateSecretCSRKey] = csrBuffer.Bytes()
+			privKeyKey, csrKey, encryptedKeyKey := CertificateSecretPrivKeyKey, CertificateSecretCSRKey, CertificateSecretEncryptedPrivKeyKey
+			if len(userSecret.Data[CertificateSecretCertKey]) > 0 {
+				// the secret still holds the credentials of the certificate that is being renewed,
+				// stage the new key material next to them to keep the current kubeconfig usable
+				privKeyKey, csrKey, encryptedKeyKey = CertificateSecretPendingPrivKeyKey, CertificateSecretPendingCSRKey, CertificateSecretPendingEncryptedPrivKeyKey
+			}
+			userSecret.Data[privKeyKey] = material.Key
+			userSecret.Data[csrKey] = material.CSR
+			if material.EncryptedKey != nil {
+				userSecret.Data[encryptedKeyKey] = material.EncryptedKey
+			} else {
+				delete(userSecret.Data, encryptedKeyKey)
+			}
 
-		err = r.Update(ctx, userSecret)
-		if err != nil {
-			klog.ErrorS(err, "Failed to update user secret with private key and CSR buffers", "namespace", userSecret.Namespace, "name", userSecret.Name)
-			r.Recorder.Eventf(kubeconfig, "Warning", "UserSecretUpdated", "Failed to update user secret with private key and CSR, %v", err)
-			return ctrl.Result{}, err
-		}
+			err = r.Update(ctx, userSecret)
+			if err != nil {
+				klog.ErrorS(err, "Failed to update user secret with private key and CSR buffers", "namespace", userSecret.Namespace, "name", userSecret.Name)
+				r.Recorder.Eventf(kubeconfig, "Warning", "UserSecretUpdated", "Failed to update user secret with private key and CSR, %v", err)
+				return ctrl.Result{}, err
+			}
 
-		klog.V(2).InfoS("Updated user secret", "namespace", userSecret.Namespace, "name", userSecret.Name)
-		r.Recorder.Event(kubeconfig, "Normal", "UserSecretUpdated", "Added private key and CSR to user secret")
+			klog.V(2).InfoS("Updated user secret", "namespace", userSecret.Namespace, "name", userSecret.Name)
+			r.Recorder.Event(kubeconfig, "Normal", "UserSecretUpdated", "Added private key and CSR to user secret")
+			csrPEM = material.CSR
+		}
 
 		// Create fresh CSR and a secret keeping track of the private/public key and the CSR
-		csr := r.createCsr(kubeconfig, csrBuffer)
+		csr := r.createCsr(kubeconfig, csrPEM)
 		err = r.Create(ctx, csr)
 		if err != nil {
 			klog.Error(err)
@@ -179,18 +330,28 @@ func (r *KubeconfigReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 			return ctrl.Result{}, err
 		}
 		kubeconfig.Status.Csr = kubeconfigv1alpha1.CsrRef{
-			Name: csr.Name,
+			Name:       csr.Name,
+			Generation: kubeconfig.Generation,
+			SpecHash:   credentialsSpecHash(kubeconfig),
+			RolesHash:  rolesHash(kubeconfig),
 		}
+		kubeconfig.Status.Approvers = nil
 		meta.SetStatusCondition(&kubeconfig.Status.Conditions, metav1.Condition{
 			Type:    kubeconfigv1alpha1.ConditionTypeCSRCreated,
 			Reason:  "CsrCreated",
 			Message: "Created CSR for kubeconfig request",
 			Status:  metav1.ConditionTrue,
 		})
-		kubeconfig.Status.Status = phases.PhaseAwaitingApproval
+		if kubeconfig.Status.Status != phases.PhaseRenewing {
+			kubeconfig.Status.Status = phases.PhaseAwaitingApproval
+		}
 		r.Recorder.Eventf(kubeconfig, "Normal", "Created", "Created user secret and CSR for kubeconfig")
 		klog.V(0).InfoS("Exiting early, created CSR, waiting for next reconciliation", "name", kubeconfig.Name)
-		r.Status().Update(ctx, kubeconfig)
+		err = r.Status().Update(ctx, kubeconfig)
+		if err != nil {
+			klog.ErrorS(err, "failed to update kubeconfig status", "name", kubeconfig.Name)
+			return ctrl.Result{}, err
+		}
 		return ctrl.Result{}, nil
 	} else if err != nil {
 		klog.ErrorS(err, "Failed to get CSR from apiserver")
@@ -199,23 +360,55 @@ func (r *KubeconfigReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 
 	approved, denied, failed := getCertApprovalCondition(csr.Status.Conditions)
 	if denied || failed {
-		// updated approval state for this reonciler run
-		klog.Errorf("CSR was denied or failed", "failed", failed, "denied", denied)
+		// propagate the CSR's reason and message, such that users do not need to look up the CSR
+		reason, message, phase := "CsrFailed", "CSR for the kubeconfig failed", phases.PhaseFailed
+		if denied {
+			reason, message, phase = "CsrDenied", "CSR for the kubeconfig was denied", phases.PhaseDenied
+		}
+		if condition := getCertFailureCondition(csr.Status.Conditions); condition != nil {
+			if condition.Message != "" {
+				message = condition.Message
+			}
+			reason = conditionReason(condition.Reason, reason)
+		}
+		klog.V(0).InfoS("CSR was denied or failed", "name", kubeconfig.Name, "denied", denied, "failed", failed, "reason", reason, "message", message)
+		r.Recorder.Eventf(kubeconfig, "Warning", reason, "CSR %s was not approved, %s", csr.Name, message)
 		meta.SetStatusCondition(&kubeconfig.Status.Conditions, metav1.Condition{
 			Type:    kubeconfigv1alpha1.ConditionTypeCSRApproved,
 			Status:  metav1.ConditionFalse,
-			Reason:  "CsrApproved",
-			Message: "CSR for the kubeconfig was denied or failed",
+			Reason:  reason,
+			Message: message,
 		})
+		finishedReason, finishedMessage := "CsrFailed", "Kubeconfig creation failed in CSR stage"
+		if denied {
+			finishedReason = "CsrDenied"
+			finishedMessage = fmt.Sprintf("Kubeconfig creation failed in CSR stage, annotate the kubeconfig with %s to request a fresh CSR", ReRequestAnnotationKey)
+		}
 		meta.SetStatusCondition(&kubeconfig.Status.Conditions, metav1.Condition{
 			Type:    kubeconfigv1alpha1.ConditionTypeKubeconfigFinished,
 			Status:  metav1.ConditionFalse,
-			Reason:  "Failed",
-			Message: "Kubeconfig creation failed in CSR stage",
+			Reason:  finishedReason,
+			Message: finishedMessage,
 		})
-		kubeconfig.Status.Status = phases.PhaseFailed
-		r.Status().Update(ctx, kubeconfig)
-		return ctrl.Result{}, nil
+		kubeconfig.Status.Status = phase
+		err = r.Status().Update(ctx, kubeconfig)
+		if err != nil {
+			klog.ErrorS(err, "failed to update kubeconfig status", "name", kubeconfig.Name)
+			return ctrl.Result{}, err
+		}
+		// failed CSRs are retried automatically, requeue to schedule the first retry
+		return ctrl.Result{Requeue: failed && !denied}, nil
+	}
+	privileged, err := r.Approval.requiresQuorum(ctx, r.Client, kubeconfig)
+	if err != nil {
+		klog.ErrorS(err, "failed to list kubeconfig groups", "name", kubeconfig.Name)
+		return ctrl.Result{}, err
+	}
+	if privileged {
+		stop, err := r.reconcileApprovers(ctx, kubeconfig, csr, approved)
+		if stop || err != nil {
+			return ctrl.Result{}, err
+		}
 	}
 	if !approved {
 		// CSR is still pending somehow
@@ -233,9 +426,26 @@ func (r *KubeconfigReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 	cert := csr.Status.Certificate
 	if len(cert) == 0 {
 		klog.V(0).InfoS("Certificate is empty, requeuing kubeconfig reconciliation", "name", kubeconfig.Name)
-		r.Status().Update(ctx, kubeconfig)
+		err = r.Status().Update(ctx, kubeconfig)
+		if err != nil {
+			klog.ErrorS(err, "failed to update kubeconfig status", "name", kubeconfig.Name)
+			return ctrl.Result{}, err
+		}
 		return ctrl.Result{RequeueAfter: 15 * time.Second, Requeue: true}, nil
 	}
+	// Promote the key material staged by a renewal now that its certificate is issued
+	if pendingKey := userSecret.Data[CertificateSecretPendingPrivKeyKey]; len(pendingKey) > 0 {
+		userSecret.Data[CertificateSecretPrivKeyKey] = pendingKey
+		userSecret.Data[CertificateSecretCSRKey] = userSecret.Data[CertificateSecretPendingCSRKey]
+		if encryptedKey, ok := userSecret.Data[CertificateSecretPendingEncryptedPrivKeyKey]; ok {
+			userSecret.Data[CertificateSecretEncryptedPrivKeyKey] = encryptedKey
+		} else {
+			delete(userSecret.Data, CertificateSecretEncryptedPrivKeyKey)
+		}
+		delete(userSecret.Data, CertificateSecretPendingPrivKeyKey)
+		delete(userSecret.Data, CertificateSecretPendingCSRKey)
+		delete(userSecret.Data, CertificateSecretPendingEncryptedPrivKeyKey)
+	}
 	// Upsert secret with certificate
 	userSecret.Data[CertificateSecretCertKey] = cert
 
@@ -245,7 +455,7 @@ func (r *KubeconfigReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 		r.Recorder.Eventf(kubeconfig, "Warning", "KubeconfigSecretFailed", "Failed to template kubeconfig, %v", err)
 		return ctrl.Result{}, err
 	}
-	kubeconfig.Status.Kubeconfig = string(cfg)
+	kubeconfig.Status.Kubeconfig = r.statusKubeconfig(cfg)
 	userSecret.Data[KubeconfigKey] = cfg
 	err = r.Update(ctx, userSecret)
 	if err != nil {
@@ -256,6 +466,11 @@ func (r *KubeconfigReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 	}
 	klog.InfoS("Updated user secret secret", "namespace", userSecret.Namespace, "name", userSecret.Name)
 
+	err = r.reconcileTarget(ctx, kubeconfig, cfg)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
 	// update kubeconfig conditions accordingly
 	meta.SetStatusCondition(&kubeconfig.Status.Conditions, metav1.Condition{
 		Type:    kubeconfigv1alpha1.ConditionTypeUserSecretFinished,
@@ -264,24 +479,11 @@ func (r *KubeconfigReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 		Message: "Upserted user secret with certificate from approved CSR",
 	})
 
-	crbName := types.NamespacedName{
-		Name: fmt.Sprintf("%s-kubeconfig", kubeconfig.Spec.Username),
-	}
-	crb := &rbacv1.ClusterRoleBinding{}
-	err = r.Get(ctx, crbName, crb)
-	if apierrors.IsNotFound(err) {
-		crb = r.createClusterRoleBinding(kubeconfig, crbName)
-		err = r.Create(ctx, crb)
-		if err != nil {
-			klog.ErrorS(err, "failed to create clusterrolebinding object at API server, requeueing")
-			return ctrl.Result{Requeue: true}, err
-		}
-		klog.V(2).InfoS("Created clusterrolebinding for kubeconfig user", "user", kubeconfig.Spec.Username)
-	} else if err != nil {
-		klog.ErrorS(err, "failed to get clusterrolebinding", "name", crbName.Name)
-		r.Recorder.Eventf(kubeconfig, "Warning", "ClusterRoleBindingFailed", "Failed to get clusterrolebinding, %v", err)
+	err = r.reconcileBindings(ctx, kubeconfig)
+	if err != nil {
 		return ctrl.Result{}, err
 	}
+	klog.V(2).InfoS("Reconciled role bindings for kubeconfig user", "user", kubeconfig.Spec.Username)
 
 	meta.SetStatusCondition(&kubeconfig.Status.Conditions, metav1.Condition{
 		Type:    kubeconfigv1alpha1.ConditionTypeKubeconfigFinished,
@@ -289,11 +491,84 @@ func (r *KubeconfigReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 		Message: "Finished kubeconfig creation",
 		Status:  metav1.ConditionTrue,
 	})
+	if kubeconfig.Status.Status == phases.PhaseRenewing {
+		r.Recorder.Event(kubeconfig, "Normal", "Renewed", "Renewed client certificate of kubeconfig")
+	}
 	kubeconfig.Status.Status = phases.PhaseDone
-	r.Status().Update(ctx, kubeconfig)
+	kubeconfig.Status.Retries = 0
+	setCredentialsStatus(kubeconfig)
+
+	result := ctrl.Result{}
+	if parsed, err := parseCertificate(cert); err == nil {
+		setCertificateStatus(kubeconfig, parsed)
+		renewIn, _ := setRenewalTime(kubeconfig, parsed)
+		result.RequeueAfter = renewIn
+	} else {
+		klog.ErrorS(err, "failed to parse issued certificate, cannot schedule renewal", "name", kubeconfig.Name)
+	}
+	err = r.Status().Update(ctx, kubeconfig)
+	if err != nil {
+		klog.ErrorS(err, "failed to update kubeconfig status", "name", kubeconfig.Name)
+		return ctrl.Result{}, err
+	}
 
 	klog.V(2).InfoS("Finished kubeconfig reconciliation", "name", kubeconfig.Name)
-	return ctrl.Result{}, nil
+	return result, nil
+}
+
+// reconcileFinished keeps the objects derived from a finished kubeconfig in sync and schedules the renewal of its certificate
+func (r *KubeconfigReconciler) reconcileFinished(ctx context.Context, kubeconfig *kubeconfigv1alpha1.Kubeconfig) (ctrl.Result, error) {
+	userSecret := &corev1.Secret{}
+	err := r.Get(ctx, types.NamespacedName{
+		Namespace: kubeconfig.Status.UserSecret.Namespace,
+		Name:      kubeconfig.Status.UserSecret.Name,
+	}, userSecret)
+	if apierrors.IsNotFound(err) {
+		klog.V(0).InfoS("User secret of finished kubeconfig is missing, requesting a new certificate", "name", kubeconfig.Name)
+		r.Recorder.Event(kubeconfig, "Warning", "UserSecretMissing", "User secret was deleted, requesting a new certificate")
+		return r.beginReissue(ctx, kubeconfig, "UserSecretMissing", "User secret was deleted, requesting a new certificate")
+	} else if err != nil {
+		klog.ErrorS(err, "failed to get user secret", "namespace", kubeconfig.Status.UserSecret.Namespace, "name", kubeconfig.Status.UserSecret.Name)
+		return ctrl.Result{}, err
+	}
+
+	if kubeconfig.Status.CredentialsSpecHash == "" && kubeconfig.Spec.ExistingCSR == nil {
+		// credentials issued by previous versions of the operator match .spec.csr, which used to be immutable
+		kubeconfig.Status.CredentialsGeneration = kubeconfig.Generation
+		kubeconfig.Status.CredentialsSpecHash = credentialsSpecHash(kubeconfig)
+		err = r.Status().Update(ctx, kubeconfig)
+		if err != nil {
+			klog.ErrorS(err, "failed to update kubeconfig status", "name", kubeconfig.Name)
+			return ctrl.Result{}, err
+		}
+	} else if credentialsSpecChanged(kubeconfig, kubeconfig.Status.CredentialsSpecHash) {
+		return r.rotateCredentials(ctx, kubeconfig)
+	}
+
+	if kubeconfig.Status.ApprovedRolesHash == "" {
+		// previous versions of the operator did not record the roles that certificates were approved for
+		kubeconfig.Status.ApprovedRolesHash = rolesHash(kubeconfig)
+		err = r.Status().Update(ctx, kubeconfig)
+		if err != nil {
+			klog.ErrorS(err, "failed to update kubeconfig status", "name", kubeconfig.Name)
+			return ctrl.Result{}, err
+		}
+	} else if rolesChanged(kubeconfig, kubeconfig.Status.ApprovedRolesHash) {
+		// never bind roles that were not approved together with the certificate
+		return r.reapproveRoles(ctx, kubeconfig)
+	}
+
+	err = r.reconcileTarget(ctx, kubeconfig, userSecret.Data[KubeconfigKey])
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	err = r.reconcileBindings(ctx, kubeconfig)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	return r.reconcileRenewal(ctx, kubeconfig, userSecret)
 }
 
 // SetupWithManager sets up the controller with the Manager.
@@ -301,5 +576,11 @@ func (r *KubeconfigReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&kubeconfigv1alpha1.Kubeconfig{}).
 		Owns(&certificatesv1.CertificateSigningRequest{}).
+		Owns(&corev1.Secret{}).
+		Owns(&corev1.ServiceAccount{}).
+		Owns(&rbacv1.ClusterRoleBinding{}).
+		Owns(&rbacv1.RoleBinding{}).
+		Watches(&source.Kind{Type: &kubeconfigv1alpha1.KubeconfigApproval{}}, handler.EnqueueRequestsFromMapFunc(approvalKubeconfig)).
+		Watches(&source.Kind{Type: &kubeconfigv1alpha1.ApprovalPolicy{}}, handler.EnqueueRequestsFromMapFunc(r.credentialKubeconfigs)).
 		Complete(r)
 }
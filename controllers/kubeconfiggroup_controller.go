@@ -0,0 +1,131 @@
+/*
+Copyright 2022 zoomoid.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	kubeconfigv1alpha1 "github.com/zoomoid/kubeconfig-operator/api/v1alpha1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// KubeconfigGroupReconciler reconciles the role bindings of a KubeconfigGroup object
+type KubeconfigGroupReconciler struct {
+	client.Client
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+}
+
+// +kubebuilder:rbac:groups=kubeconfig.k8s.zoomoid.dev,resources=kubeconfiggroups,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=kubeconfig.k8s.zoomoid.dev,resources=kubeconfiggroups/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=kubeconfig.k8s.zoomoid.dev,resources=kubeconfiggroups/finalizers,verbs=update
+
+func (r *KubeconfigGroupReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	group := &kubeconfigv1alpha1.KubeconfigGroup{}
+	err := r.Get(ctx, req.NamespacedName, group)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			// the group's bindings are garbage-collected through their owner references
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+
+	klog.V(2).InfoS("Reconciling kubeconfig group", "name", group.Name)
+
+	condition := metav1.Condition{
+		Type:    kubeconfigv1alpha1.ConditionTypeRBACSynced,
+		Status:  metav1.ConditionTrue,
+		Reason:  "Synced",
+		Message: "Role bindings match the spec",
+	}
+	err = syncBindings(ctx, r.Client, r.Scheme, r.createBindings(group))
+	if err != nil {
+		r.Recorder.Eventf(group, "Warning", "BindingsFailed", "Failed to reconcile role bindings, %v", err)
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "SyncFailed"
+		condition.Message = fmt.Sprintf("Failed to reconcile role bindings, %v", err)
+	}
+	if meta.SetStatusCondition(&group.Status.Conditions, condition) {
+		if updateErr := r.Status().Update(ctx, group); updateErr != nil && err == nil {
+			return ctrl.Result{}, updateErr
+		}
+	}
+	return ctrl.Result{}, err
+}
+
+// createBindings returns the bindings of the group's Group subject
+func (r *KubeconfigGroupReconciler) createBindings(group *kubeconfigv1alpha1.KubeconfigGroup) bindingSet {
+	labels := map[string]string{
+		"kubeconfig-operator.k8s.zoomoid.dev/group": group.Name,
+	}
+	subjects := []rbacv1.Subject{
+		{
+			Kind:     "Group",
+			APIGroup: "rbac.authorization.k8s.io",
+			Name:     group.GroupName(),
+		},
+	}
+	set := bindingSet{
+		owner:    group,
+		selector: labels,
+	}
+	for _, binding := range group.Spec.Bindings {
+		roleRef := binding.RoleRef
+		if roleRef.APIGroup == "" {
+			roleRef.APIGroup = rbacv1.GroupName
+		}
+		objectMeta := metav1.ObjectMeta{
+			Namespace: binding.Namespace,
+			Name:      fmt.Sprintf("%s-group-%s-%s", group.Name, strings.ToLower(roleRef.Kind), roleRef.Name),
+			Labels:    labels,
+		}
+		if binding.Namespace == "" {
+			set.bindings = append(set.bindings, &rbacv1.ClusterRoleBinding{
+				ObjectMeta: objectMeta,
+				Subjects:   subjects,
+				RoleRef:    roleRef,
+			})
+		} else {
+			set.bindings = append(set.bindings, &rbacv1.RoleBinding{
+				ObjectMeta: objectMeta,
+				Subjects:   subjects,
+				RoleRef:    roleRef,
+			})
+		}
+	}
+	return set
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *KubeconfigGroupReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&kubeconfigv1alpha1.KubeconfigGroup{}).
+		Owns(&rbacv1.ClusterRoleBinding{}).
+		Owns(&rbacv1.RoleBinding{}).
+		Complete(r)
+}
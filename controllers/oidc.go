@@ -0,0 +1,180 @@
+/*
+Copyright 2022 zoomoid.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	kubeconfigv1alpha1 "github.com/zoomoid/kubeconfig-operator/api/v1alpha1"
+	"github.com/zoomoid/kubeconfig-operator/controllers/phases"
+	config "github.com/zoomoid/kubeconfig-operator/pkg/kubeconfig"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	// ExecAPIVersion is the version of the client.authentication.k8s.io API spoken by exec credential plugins
+	ExecAPIVersion = "client.authentication.k8s.io/v1beta1"
+	// OIDCExecCommand is the command of the exec credential plugin, which runs the kubelogin kubectl plugin
+	OIDCExecCommand = "kubectl"
+)
+
+// oidcSubjects returns the subjects that the roles of a kubeconfig in the OIDC auth mode are bound to, i.e., the
+// username and groups as the kube-apiserver maps them from ID tokens
+func oidcSubjects(kubeconfig *kubeconfigv1alpha1.Kubeconfig) []rbacv1.Subject {
+	oidc := kubeconfig.Spec.OIDC
+	var usernamePrefix, groupsPrefix string
+	if oidc != nil {
+		usernamePrefix, groupsPrefix = oidc.UsernamePrefix, oidc.GroupsPrefix
+	}
+	subjects := []rbacv1.Subject{
+		{
+			Kind:     rbacv1.UserKind,
+			APIGroup: rbacv1.GroupName,
+			Name:     usernamePrefix + kubeconfig.Spec.Username,
+		},
+	}
+	for _, group := range kubeconfig.Spec.Groups {
+		subjects = append(subjects, rbacv1.Subject{
+			Kind:     rbacv1.GroupKind,
+			APIGroup: rbacv1.GroupName,
+			Name:     groupsPrefix + group,
+		})
+	}
+	return subjects
+}
+
+// oidcUser returns the kubeconfig user that obtains ID tokens with the kubelogin exec credential plugin
+func oidcUser(oidc *kubeconfigv1alpha1.OIDC) config.User {
+	args := []string{
+		"oidc-login",
+		"get-token",
+		fmt.Sprintf("--oidc-issuer-url=%s", oidc.IssuerURL),
+		fmt.Sprintf("--oidc-client-id=%s", oidc.ClientID),
+	}
+	for _, scope := range oidc.ExtraScopes {
+		args = append(args, fmt.Sprintf("--oidc-extra-scope=%s", scope))
+	}
+	return config.User{
+		Exec: &config.Exec{
+			APIVersion:      ExecAPIVersion,
+			Command:         OIDCExecCommand,
+			Args:            args,
+			InteractiveMode: "IfAvailable",
+		},
+	}
+}
+
+// reconcileOIDC templates the kubeconfig of a kubeconfig in the OIDC auth mode and binds its roles. The kubeconfig
+// contains no credentials, such that the CSR workflow is skipped entirely, but its roles are only bound once the
+// kubeconfig is approved
+func (r *KubeconfigReconciler) reconcileOIDC(ctx context.Context, kubeconfig *kubeconfigv1alpha1.Kubeconfig) (ctrl.Result, error) {
+	if kubeconfig.Spec.OIDC == nil {
+		// the validating webhook rejects these, never template a kubeconfig without an identity provider
+		return ctrl.Result{}, fmt.Errorf("kubeconfig %s uses the OIDC auth mode without .spec.oidc", kubeconfig.Name)
+	}
+	status := kubeconfig.Status.DeepCopy()
+	approved, err := r.reconcileApproval(ctx, kubeconfig)
+	if !approved || err != nil {
+		// approvals and changes of approval policies trigger another reconciliation
+		return ctrl.Result{}, err
+	}
+
+	cfg, err := r.templateKubeconfig(ctx, kubeconfig, oidcUser(kubeconfig.Spec.OIDC))
+	if err != nil {
+		klog.ErrorS(err, "failed to template kubeconfig")
+		r.Recorder.Eventf(kubeconfig, "Warning", "KubeconfigSecretFailed", "Failed to template kubeconfig, %v", err)
+		return ctrl.Result{}, err
+	}
+
+	userSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: operatorNamespace,
+			Name:      fmt.Sprintf("%s-oidc", kubeconfig.Name),
+		},
+	}
+	result, err := controllerutil.CreateOrUpdate(ctx, r.Client, userSecret, func() error {
+		if !userSecret.CreationTimestamp.IsZero() && !metav1.IsControlledBy(userSecret, kubeconfig) {
+			// never take over secrets that were not created for this kubeconfig
+			return fmt.Errorf("secret %s/%s already exists and is not managed by kubeconfig %s", userSecret.Namespace, userSecret.Name, kubeconfig.Name)
+		}
+		if userSecret.Labels == nil {
+			userSecret.Labels = map[string]string{}
+		}
+		for k, v := range labelsForSubresources(kubeconfig) {
+			userSecret.Labels[k] = v
+		}
+		userSecret.Type = corev1.SecretTypeOpaque
+		userSecret.Data = map[string][]byte{
+			KubeconfigKey: cfg,
+		}
+		return controllerutil.SetControllerReference(kubeconfig, userSecret, r.Scheme)
+	})
+	if err != nil {
+		klog.ErrorS(err, "failed to update user secret", "namespace", userSecret.Namespace, "name", userSecret.Name)
+		r.Recorder.Eventf(kubeconfig, "Warning", "UserSecretFailed", "Failed to update user secret, %v", err)
+		return ctrl.Result{}, err
+	}
+	if result != controllerutil.OperationResultNone {
+		klog.V(0).InfoS("Templated OIDC kubeconfig", "name", kubeconfig.Name, "operation", result)
+		r.Recorder.Event(kubeconfig, "Normal", "KubeconfigTemplated", "Templated kubeconfig with OIDC exec credential plugin")
+	}
+	kubeconfig.Status.UserSecret = kubeconfigv1alpha1.SecretRef{
+		Namespace: userSecret.Namespace,
+		Name:      userSecret.Name,
+	}
+
+	err = r.reconcileTarget(ctx, kubeconfig, cfg)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	err = r.reconcileBindings(ctx, kubeconfig)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	meta.SetStatusCondition(&kubeconfig.Status.Conditions, metav1.Condition{
+		Type:    kubeconfigv1alpha1.ConditionTypeUserSecretFinished,
+		Status:  metav1.ConditionTrue,
+		Reason:  "Upserted",
+		Message: "Upserted user secret with OIDC kubeconfig",
+	})
+	meta.SetStatusCondition(&kubeconfig.Status.Conditions, metav1.Condition{
+		Type:    kubeconfigv1alpha1.ConditionTypeKubeconfigFinished,
+		Status:  metav1.ConditionTrue,
+		Reason:  "Finished",
+		Message: "Finished kubeconfig creation",
+	})
+	kubeconfig.Status.Kubeconfig = r.statusKubeconfig(cfg)
+	kubeconfig.Status.CredentialsGeneration = kubeconfig.Generation
+	kubeconfig.Status.Status = phases.PhaseDone
+	if !equality.Semantic.DeepEqual(status, &kubeconfig.Status) {
+		err = r.Status().Update(ctx, kubeconfig)
+		if err != nil {
+			klog.ErrorS(err, "failed to update kubeconfig status", "name", kubeconfig.Name)
+			return ctrl.Result{}, err
+		}
+	}
+	return ctrl.Result{}, nil
+}
@@ -0,0 +1,120 @@
+/*
+Copyright 2022 zoomoid.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	kubeconfigv1alpha1 "github.com/zoomoid/kubeconfig-operator/api/v1alpha1"
+	"github.com/zoomoid/kubeconfig-operator/controllers/phases"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+var _ = Describe("OIDC kubeconfigs", func() {
+	var r *KubeconfigReconciler
+	var kubeconfig *kubeconfigv1alpha1.Kubeconfig
+
+	BeforeEach(func() {
+		r = newTestReconciler()
+		kubeconfig = newTestKubeconfig("oidc")
+		kubeconfig.Spec.AuthMode = kubeconfigv1alpha1.AuthModeOIDC
+		kubeconfig.Spec.CSR = nil
+		kubeconfig.Spec.Groups = []string{"dev"}
+		kubeconfig.Spec.OIDC = &kubeconfigv1alpha1.OIDC{
+			IssuerURL:      "https://issuer.example.com",
+			ClientID:       "kubernetes",
+			ExtraScopes:    []string{"email"},
+			UsernamePrefix: "oidc:",
+			GroupsPrefix:   "oidc:",
+		}
+	})
+
+	// oidcSecret returns the key of the user secret of the kubeconfig
+	oidcSecret := func() types.NamespacedName {
+		return types.NamespacedName{Namespace: operatorNamespace, Name: fmt.Sprintf("%s-oidc", kubeconfig.Name)}
+	}
+
+	It("templates and binds nothing before the kubeconfig is approved", func() {
+		createTestKubeconfig(kubeconfig)
+		_, err := r.reconcileOIDC(ctx, kubeconfig)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(apierrors.IsNotFound(k8sClient.Get(ctx, oidcSecret(), &corev1.Secret{}))).To(BeTrue())
+		Expect(apierrors.IsNotFound(k8sClient.Get(ctx, types.NamespacedName{Name: fmt.Sprintf("%s-kubeconfig", kubeconfig.Spec.Username)}, &rbacv1.ClusterRoleBinding{}))).To(BeTrue())
+		Expect(getKubeconfig(kubeconfig).Status.Status).To(Equal(phases.PhaseAwaitingApproval))
+	})
+
+	It("delivers a kubeconfig with the exec credential plugin once approved", func() {
+		kubeconfig.Spec.AutoApproveCSR = true
+		createTestKubeconfig(kubeconfig)
+		_, err := r.reconcileOIDC(ctx, kubeconfig)
+		Expect(err).NotTo(HaveOccurred())
+
+		userSecret := &corev1.Secret{}
+		Expect(k8sClient.Get(ctx, oidcSecret(), userSecret)).To(Succeed())
+		cfg := string(userSecret.Data[KubeconfigKey])
+		Expect(cfg).To(ContainSubstring("oidc-login"))
+		Expect(cfg).To(ContainSubstring("--oidc-issuer-url=https://issuer.example.com"))
+		Expect(cfg).To(ContainSubstring("--oidc-client-id=kubernetes"))
+		Expect(cfg).To(ContainSubstring("--oidc-extra-scope=email"))
+
+		By("binding the roles to the prefixed username and groups")
+		binding := &rbacv1.ClusterRoleBinding{}
+		Expect(k8sClient.Get(ctx, types.NamespacedName{Name: fmt.Sprintf("%s-kubeconfig", kubeconfig.Spec.Username)}, binding)).To(Succeed())
+		Expect(binding.Subjects).To(Equal([]rbacv1.Subject{
+			{Kind: rbacv1.UserKind, APIGroup: rbacv1.GroupName, Name: "oidc:" + kubeconfig.Spec.Username},
+			{Kind: rbacv1.GroupKind, APIGroup: rbacv1.GroupName, Name: "oidc:dev"},
+		}))
+
+		stored := getKubeconfig(kubeconfig)
+		Expect(stored.Status.Status).To(Equal(phases.PhaseDone))
+		Expect(stored.Status.UserSecret).To(Equal(kubeconfigv1alpha1.SecretRef{Namespace: operatorNamespace, Name: userSecret.Name}))
+		Expect(stored.Status.CredentialsGeneration).To(Equal(stored.Generation))
+	})
+
+	It("refuses to overwrite a secret not managed by the kubeconfig", func() {
+		kubeconfig.Spec.AutoApproveCSR = true
+		createTestKubeconfig(kubeconfig)
+		name := oidcSecret()
+		Expect(k8sClient.Create(ctx, &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: name.Namespace, Name: name.Name},
+			Data:       map[string][]byte{"foo": []byte("bar")},
+		})).To(Succeed())
+
+		_, err := r.reconcileOIDC(ctx, kubeconfig)
+		Expect(err).To(HaveOccurred())
+		secret := &corev1.Secret{}
+		Expect(k8sClient.Get(ctx, name, secret)).To(Succeed())
+		Expect(secret.OwnerReferences).To(BeEmpty())
+		Expect(secret.Data).To(Equal(map[string][]byte{"foo": []byte("bar")}))
+		Expect(apierrors.IsNotFound(k8sClient.Get(ctx, types.NamespacedName{Name: fmt.Sprintf("%s-kubeconfig", kubeconfig.Spec.Username)}, &rbacv1.ClusterRoleBinding{}))).To(BeTrue())
+	})
+
+	It("refuses to template a kubeconfig without an identity provider", func() {
+		kubeconfig.Spec.AutoApproveCSR = true
+		kubeconfig.Spec.OIDC = nil
+		_, err := r.reconcileOIDC(ctx, kubeconfig)
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -23,6 +23,13 @@ const (
 	PhaseAwaitingApproval = "Awaiting Approval"
 	// PhaseCreatingKubeconfig indicates that the transaction to create a user's kubeconfig is in progress
 	PhaseDone = "Done"
+	// PhaseRenewing indicates that the client certificate is about to expire and a new one is being requested.
+	// The previous kubeconfig remains in the user secret until the renewed certificate is issued
+	PhaseRenewing = "Renewing"
 	// PhaseFailed indicates terminal failure to reconcile the kubeconfig
 	PhaseFailed = "Failed"
+	// PhaseDenied indicates that the kubeconfig's CSR was denied. A fresh CSR can be requested with the re-request annotation
+	PhaseDenied = "Denied"
+	// PhaseRevoked indicates that the kubeconfig's role bindings were removed and its certificate was added to the revocation list
+	PhaseRevoked = "Revoked"
 )
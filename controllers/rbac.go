@@ -0,0 +1,218 @@
+/*
+Copyright 2022 zoomoid.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	kubeconfigv1alpha1 "github.com/zoomoid/kubeconfig-operator/api/v1alpha1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// bindingSet is the complete set of ClusterRoleBindings and RoleBindings that an owner wants to exist.
+// All bindings of the set carry the selector labels, which are used to find and prune bindings
+// that are no longer part of the set
+type bindingSet struct {
+	owner    client.Object
+	selector map[string]string
+	bindings []client.Object
+}
+
+// syncBindings creates or updates all bindings of the set, reverting changes made to their subjects and labels,
+// and deletes bindings matching the set's selector that are no longer desired
+func syncBindings(ctx context.Context, c client.Client, scheme *runtime.Scheme, set bindingSet) error {
+	desired := map[client.ObjectKey]bool{}
+	for _, want := range set.bindings {
+		err := upsertBinding(ctx, c, scheme, set, want)
+		if err != nil {
+			return err
+		}
+		desired[client.ObjectKeyFromObject(want)] = true
+	}
+
+	crbs := &rbacv1.ClusterRoleBindingList{}
+	err := c.List(ctx, crbs, client.MatchingLabels(set.selector))
+	if err != nil {
+		return err
+	}
+	rbs := &rbacv1.RoleBindingList{}
+	err = c.List(ctx, rbs, client.MatchingLabels(set.selector))
+	if err != nil {
+		return err
+	}
+	var existing []client.Object
+	for i := range crbs.Items {
+		existing = append(existing, &crbs.Items[i])
+	}
+	for i := range rbs.Items {
+		existing = append(existing, &rbs.Items[i])
+	}
+	for _, obj := range existing {
+		if desired[client.ObjectKeyFromObject(obj)] || !isManagedBinding(obj, set) {
+			continue
+		}
+		err := c.Delete(ctx, obj)
+		if err != nil && !apierrors.IsNotFound(err) {
+			klog.ErrorS(err, "failed to prune binding", "kind", fmt.Sprintf("%T", obj), "namespace", obj.GetNamespace(), "name", obj.GetName())
+			return err
+		}
+		klog.V(2).InfoS("Pruned binding", "kind", fmt.Sprintf("%T", obj), "namespace", obj.GetNamespace(), "name", obj.GetName())
+	}
+	return nil
+}
+
+// upsertBinding creates or updates a single binding of the set. The role reference of bindings is immutable,
+// so bindings referencing a different role than desired are deleted and created again
+func upsertBinding(ctx context.Context, c client.Client, scheme *runtime.Scheme, set bindingSet, want client.Object) error {
+	obj := emptyBinding(want)
+	if obj == nil {
+		return fmt.Errorf("unsupported binding type %T", want)
+	}
+
+	err := c.Get(ctx, client.ObjectKeyFromObject(obj), obj)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	if err == nil && isManagedBinding(obj, set) && roleRefOf(obj) != roleRefOf(want) {
+		klog.V(0).InfoS("Role reference of binding changed, recreating binding", "kind", fmt.Sprintf("%T", want), "namespace", want.GetNamespace(), "name", want.GetName(), "roleRef", roleRefOf(want).Name)
+		uid := obj.GetUID()
+		err = c.Delete(ctx, obj, client.Preconditions{UID: &uid})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+		obj = emptyBinding(want)
+	}
+
+	result, err := controllerutil.CreateOrUpdate(ctx, c, obj, func() error {
+		if obj.GetResourceVersion() != "" && !isManagedBinding(obj, set) {
+			// never take over bindings that were not created for this owner
+			return fmt.Errorf("binding %s already exists and is not managed by %s", client.ObjectKeyFromObject(obj), set.owner.GetName())
+		}
+		labels := obj.GetLabels()
+		if labels == nil {
+			labels = map[string]string{}
+		}
+		for k, v := range want.GetLabels() {
+			labels[k] = v
+		}
+		obj.SetLabels(labels)
+		switch o := obj.(type) {
+		case *rbacv1.ClusterRoleBinding:
+			w := want.(*rbacv1.ClusterRoleBinding)
+			o.Subjects = w.Subjects
+			o.RoleRef = w.RoleRef
+		case *rbacv1.RoleBinding:
+			w := want.(*rbacv1.RoleBinding)
+			o.Subjects = w.Subjects
+			o.RoleRef = w.RoleRef
+		}
+		return controllerutil.SetControllerReference(set.owner, obj, scheme)
+	})
+	if err != nil {
+		klog.ErrorS(err, "failed to reconcile binding", "kind", fmt.Sprintf("%T", want), "namespace", want.GetNamespace(), "name", want.GetName())
+		return err
+	}
+	if result != controllerutil.OperationResultNone {
+		klog.V(2).InfoS("Reconciled binding", "kind", fmt.Sprintf("%T", want), "namespace", want.GetNamespace(), "name", want.GetName(), "operation", result)
+	}
+	return nil
+}
+
+// isManagedBinding returns true if the binding is controlled by the set's owner, or if it carries the set's labels
+// without being controlled by anyone, which is the case for bindings created by previous versions of the operator
+func isManagedBinding(obj client.Object, set bindingSet) bool {
+	if metav1.IsControlledBy(obj, set.owner) {
+		return true
+	}
+	if metav1.GetControllerOf(obj) != nil {
+		return false
+	}
+	labels := obj.GetLabels()
+	for k, v := range set.selector {
+		if labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// emptyBinding returns a binding of the same type and with the same key as the given binding
+func emptyBinding(want client.Object) client.Object {
+	switch want.(type) {
+	case *rbacv1.ClusterRoleBinding:
+		return &rbacv1.ClusterRoleBinding{ObjectMeta: metav1.ObjectMeta{Name: want.GetName()}}
+	case *rbacv1.RoleBinding:
+		return &rbacv1.RoleBinding{ObjectMeta: metav1.ObjectMeta{Namespace: want.GetNamespace(), Name: want.GetName()}}
+	}
+	return nil
+}
+
+// roleRefOf returns the role reference of a ClusterRoleBinding or RoleBinding
+func roleRefOf(obj client.Object) rbacv1.RoleRef {
+	switch o := obj.(type) {
+	case *rbacv1.ClusterRoleBinding:
+		return o.RoleRef
+	case *rbacv1.RoleBinding:
+		return o.RoleRef
+	}
+	return rbacv1.RoleRef{}
+}
+
+// reconcileBindings syncs the role bindings of the kubeconfig's user with its spec and reports the result
+// in the RBACSynced condition
+func (r *KubeconfigReconciler) reconcileBindings(ctx context.Context, kubeconfig *kubeconfigv1alpha1.Kubeconfig) error {
+	condition := metav1.Condition{
+		Type:    kubeconfigv1alpha1.ConditionTypeRBACSynced,
+		Status:  metav1.ConditionTrue,
+		Reason:  "Synced",
+		Message: "Role bindings match the spec",
+	}
+	set := r.createBindings(kubeconfig)
+	revoked, err := r.revocationFor(ctx, kubeconfig.Spec.Username)
+	if err != nil {
+		return err
+	}
+	if revoked != nil {
+		// another kubeconfig of the same username was revoked, binding the username would grant access to the revoked certificate
+		set.bindings = nil
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "UsernameRevoked"
+		condition.Message = fmt.Sprintf("Username is revoked until %s", revoked.NotAfter.Format(time.RFC3339))
+	}
+	err = syncBindings(ctx, r.Client, r.Scheme, set)
+	if err != nil {
+		r.Recorder.Eventf(kubeconfig, "Warning", "BindingsFailed", "Failed to reconcile role bindings, %v", err)
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "SyncFailed"
+		condition.Message = fmt.Sprintf("Failed to reconcile role bindings, %v", err)
+	}
+	if meta.SetStatusCondition(&kubeconfig.Status.Conditions, condition) {
+		if updateErr := r.Status().Update(ctx, kubeconfig); updateErr != nil && err == nil {
+			return updateErr
+		}
+	}
+	return err
+}
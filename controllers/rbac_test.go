@@ -0,0 +1,195 @@
+/*
+Copyright 2022 zoomoid.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	kubeconfigv1alpha1 "github.com/zoomoid/kubeconfig-operator/api/v1alpha1"
+	"github.com/zoomoid/kubeconfig-operator/controllers/phases"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+var _ = Describe("Role bindings", func() {
+	view := rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: "view"}
+	edit := rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: "edit"}
+
+	var r *KubeconfigReconciler
+	var namespace string
+	var kubeconfig *kubeconfigv1alpha1.Kubeconfig
+
+	BeforeEach(func() {
+		r = newTestReconciler()
+		namespace = newTestNamespace("bindings")
+		kubeconfig = newTestKubeconfig("bindings")
+		kubeconfig.Spec.Bindings = []kubeconfigv1alpha1.KubeconfigBinding{
+			{RoleRef: view},
+			{Namespace: namespace, RoleRef: edit},
+		}
+	})
+
+	It("prunes bindings that were removed from the spec", func() {
+		createTestKubeconfig(kubeconfig)
+		Expect(r.reconcileBindings(ctx, kubeconfig)).To(Succeed())
+
+		clusterRoleBinding := types.NamespacedName{Name: fmt.Sprintf("%s-clusterrole-view", kubeconfig.Name)}
+		roleBinding := types.NamespacedName{Namespace: namespace, Name: fmt.Sprintf("%s-clusterrole-edit", kubeconfig.Name)}
+		Expect(k8sClient.Get(ctx, types.NamespacedName{Name: fmt.Sprintf("%s-kubeconfig", kubeconfig.Spec.Username)}, &rbacv1.ClusterRoleBinding{})).To(Succeed())
+		Expect(k8sClient.Get(ctx, clusterRoleBinding, &rbacv1.ClusterRoleBinding{})).To(Succeed())
+		created := &rbacv1.RoleBinding{}
+		Expect(k8sClient.Get(ctx, roleBinding, created)).To(Succeed())
+		Expect(created.RoleRef).To(Equal(edit))
+		Expect(created.Subjects).To(ConsistOf(rbacv1.Subject{Kind: rbacv1.UserKind, APIGroup: rbacv1.GroupName, Name: kubeconfig.Spec.Username}))
+
+		kubeconfig.Spec.Bindings = kubeconfig.Spec.Bindings[:1]
+		Expect(r.reconcileBindings(ctx, kubeconfig)).To(Succeed())
+		Expect(apierrors.IsNotFound(k8sClient.Get(ctx, roleBinding, &rbacv1.RoleBinding{}))).To(BeTrue())
+		Expect(k8sClient.Get(ctx, clusterRoleBinding, &rbacv1.ClusterRoleBinding{})).To(Succeed())
+	})
+
+	It("leaves bindings alone that are not managed by the kubeconfig", func() {
+		createTestKubeconfig(kubeconfig)
+		// a binding of the same name that someone else created
+		unmanaged := &rbacv1.RoleBinding{
+			ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: fmt.Sprintf("%s-clusterrole-edit", kubeconfig.Name)},
+			Subjects:   []rbacv1.Subject{{Kind: rbacv1.UserKind, APIGroup: rbacv1.GroupName, Name: "mallory"}},
+			RoleRef:    view,
+		}
+		Expect(k8sClient.Create(ctx, unmanaged)).To(Succeed())
+		// a binding carrying the kubeconfig's labels that is controlled by another object
+		controller := true
+		foreign := &rbacv1.ClusterRoleBinding{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   uniqueName("foreign"),
+				Labels: labelsForSubresources(kubeconfig),
+				OwnerReferences: []metav1.OwnerReference{{
+					APIVersion: "v1",
+					Kind:       "ConfigMap",
+					Name:       "owner",
+					UID:        "4c3f2d6e-8a61-4d5b-9a0e-1f2e3d4c5b6a",
+					Controller: &controller,
+				}},
+			},
+			Subjects: []rbacv1.Subject{{Kind: rbacv1.UserKind, APIGroup: rbacv1.GroupName, Name: "mallory"}},
+			RoleRef:  view,
+		}
+		Expect(k8sClient.Create(ctx, foreign)).To(Succeed())
+
+		Expect(r.reconcileBindings(ctx, kubeconfig)).NotTo(Succeed())
+		current := &rbacv1.RoleBinding{}
+		Expect(k8sClient.Get(ctx, types.NamespacedName{Namespace: namespace, Name: unmanaged.Name}, current)).To(Succeed())
+		Expect(current.UID).To(Equal(unmanaged.UID))
+		Expect(current.RoleRef).To(Equal(view))
+		Expect(current.Subjects).To(Equal(unmanaged.Subjects))
+		Expect(current.OwnerReferences).To(BeEmpty())
+
+		kubeconfig.Spec.Bindings = nil
+		Expect(r.reconcileBindings(ctx, kubeconfig)).To(Succeed())
+		Expect(k8sClient.Get(ctx, types.NamespacedName{Namespace: namespace, Name: unmanaged.Name}, &rbacv1.RoleBinding{})).To(Succeed())
+		Expect(k8sClient.Get(ctx, types.NamespacedName{Name: foreign.Name}, &rbacv1.ClusterRoleBinding{})).To(Succeed())
+	})
+
+	It("recreates bindings whose role reference changed", func() {
+		createTestKubeconfig(kubeconfig)
+		Expect(r.reconcileBindings(ctx, kubeconfig)).To(Succeed())
+		key := types.NamespacedName{Name: fmt.Sprintf("%s-kubeconfig", kubeconfig.Spec.Username)}
+		previous := &rbacv1.ClusterRoleBinding{}
+		Expect(k8sClient.Get(ctx, key, previous)).To(Succeed())
+		Expect(previous.RoleRef).To(Equal(view))
+
+		kubeconfig.Spec.RoleRef = &edit
+		Expect(r.reconcileBindings(ctx, kubeconfig)).To(Succeed())
+		recreated := &rbacv1.ClusterRoleBinding{}
+		Expect(k8sClient.Get(ctx, key, recreated)).To(Succeed())
+		Expect(recreated.RoleRef).To(Equal(edit))
+		Expect(recreated.UID).NotTo(Equal(previous.UID))
+		Expect(metav1.IsControlledBy(recreated, kubeconfig)).To(BeTrue())
+	})
+
+	It("does not bind roles that changed after the certificate was approved", func() {
+		Expect(k8sClient.Create(ctx, kubeconfig)).To(Succeed())
+		now := time.Now()
+		kubeconfig = reconcileIssued(r, kubeconfig, now.Add(-time.Hour), now.Add(24*time.Hour))
+		_, err := reconcileKubeconfig(r, kubeconfig)
+		Expect(err).NotTo(HaveOccurred())
+		key := types.NamespacedName{Name: fmt.Sprintf("%s-kubeconfig", kubeconfig.Spec.Username)}
+		issued := &rbacv1.ClusterRoleBinding{}
+		Expect(k8sClient.Get(ctx, key, issued)).To(Succeed())
+		Expect(issued.RoleRef).To(Equal(view))
+
+		By("escalating the roleRef of the issued kubeconfig")
+		clusterAdmin := rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: "cluster-admin"}
+		kubeconfig = getKubeconfig(kubeconfig)
+		kubeconfig.Spec.RoleRef = &clusterAdmin
+		Expect(k8sClient.Update(ctx, kubeconfig)).To(Succeed())
+		_, err = reconcileKubeconfig(r, kubeconfig)
+		Expect(err).NotTo(HaveOccurred())
+		current := &rbacv1.ClusterRoleBinding{}
+		Expect(k8sClient.Get(ctx, key, current)).To(Succeed())
+		Expect(current.RoleRef).To(Equal(view))
+		Expect(current.UID).To(Equal(issued.UID))
+		renewing := getKubeconfig(kubeconfig)
+		Expect(renewing.Status.Status).To(Equal(phases.PhaseRenewing))
+		Expect(renewing.Status.Csr.Name).To(BeEmpty())
+		condition := meta.FindStatusCondition(renewing.Status.Conditions, kubeconfigv1alpha1.ConditionTypeRBACSynced)
+		Expect(condition).NotTo(BeNil())
+		Expect(condition.Status).To(Equal(metav1.ConditionFalse))
+		Expect(condition.Reason).To(Equal("AwaitingApproval"))
+
+		By("binding the new role once a certificate was issued for it")
+		_, err = reconcileKubeconfig(r, kubeconfig)
+		Expect(err).NotTo(HaveOccurred())
+		kubeconfig = reconcileIssued(r, kubeconfig, now, now.Add(24*time.Hour))
+		Expect(kubeconfig.Status.ApprovedRolesHash).To(Equal(rolesHash(kubeconfig)))
+		_, err = reconcileKubeconfig(r, kubeconfig)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(k8sClient.Get(ctx, key, current)).To(Succeed())
+		Expect(current.RoleRef).To(Equal(clusterAdmin))
+	})
+
+	It("reports the result in the RBACSynced condition", func() {
+		createTestKubeconfig(kubeconfig)
+		Expect(r.reconcileBindings(ctx, kubeconfig)).To(Succeed())
+		condition := meta.FindStatusCondition(getKubeconfig(kubeconfig).Status.Conditions, kubeconfigv1alpha1.ConditionTypeRBACSynced)
+		Expect(condition).NotTo(BeNil())
+		Expect(condition.Status).To(Equal(metav1.ConditionTrue))
+		Expect(condition.Reason).To(Equal("Synced"))
+
+		By("failing on a binding that is not managed by the kubeconfig")
+		unmanaged := &rbacv1.ClusterRoleBinding{
+			ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("%s-clusterrole-edit", kubeconfig.Name)},
+			Subjects:   []rbacv1.Subject{{Kind: rbacv1.UserKind, APIGroup: rbacv1.GroupName, Name: "mallory"}},
+			RoleRef:    edit,
+		}
+		Expect(k8sClient.Create(ctx, unmanaged)).To(Succeed())
+		kubeconfig.Spec.Bindings = append(kubeconfig.Spec.Bindings, kubeconfigv1alpha1.KubeconfigBinding{RoleRef: edit})
+		Expect(r.reconcileBindings(ctx, kubeconfig)).NotTo(Succeed())
+		condition = meta.FindStatusCondition(getKubeconfig(kubeconfig).Status.Conditions, kubeconfigv1alpha1.ConditionTypeRBACSynced)
+		Expect(condition).NotTo(BeNil())
+		Expect(condition.Status).To(Equal(metav1.ConditionFalse))
+		Expect(condition.Reason).To(Equal("SyncFailed"))
+		Expect(condition.Message).To(ContainSubstring(unmanaged.Name))
+	})
+})
@@ -0,0 +1,68 @@
+/*
+Copyright 2022 zoomoid.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	kubeconfigv1alpha1 "github.com/zoomoid/kubeconfig-operator/api/v1alpha1"
+	config "github.com/zoomoid/kubeconfig-operator/pkg/kubeconfig"
+	"k8s.io/klog/v2"
+)
+
+// KubeconfigStatusMode determines how much of the generated kubeconfig is published in the status of the
+// cluster-scoped Kubeconfig object. The complete kubeconfig is only ever stored in the user secret
+type KubeconfigStatusMode string
+
+const (
+	// KubeconfigStatusNone only references the user secret in the status
+	KubeconfigStatusNone KubeconfigStatusMode = "None"
+	// KubeconfigStatusRedacted additionally publishes the kubeconfig with the client key removed
+	KubeconfigStatusRedacted KubeconfigStatusMode = "Redacted"
+)
+
+// IsValid returns true if the mode is one of the known status modes
+func (m KubeconfigStatusMode) IsValid() bool {
+	return m == KubeconfigStatusNone || m == KubeconfigStatusRedacted
+}
+
+// statusKubeconfig returns the representation of the kubeconfig that may be published in the status
+// according to the reconciler's status mode
+func (r *KubeconfigReconciler) statusKubeconfig(cfg []byte) string {
+	if r.StatusMode != KubeconfigStatusRedacted || len(cfg) == 0 {
+		return ""
+	}
+	parsed, err := config.Unmarshal(cfg)
+	if err != nil {
+		// never fall back to publishing a kubeconfig we could not redact
+		klog.ErrorS(err, "failed to parse kubeconfig for redaction")
+		return ""
+	}
+	return string(parsed.Redacted().Marshal())
+}
+
+// scrubStatusKubeconfig removes kubeconfigs from the status that were published by previous versions of the operator
+// or under a different status mode, which may contain the client's private key. It returns true if the status was changed
+func (r *KubeconfigReconciler) scrubStatusKubeconfig(kubeconfig *kubeconfigv1alpha1.Kubeconfig) bool {
+	if kubeconfig.Status.Kubeconfig == "" {
+		return false
+	}
+	scrubbed := r.statusKubeconfig([]byte(kubeconfig.Status.Kubeconfig))
+	if scrubbed == kubeconfig.Status.Kubeconfig {
+		return false
+	}
+	kubeconfig.Status.Kubeconfig = scrubbed
+	return true
+}
@@ -0,0 +1,80 @@
+/*
+Copyright 2022 zoomoid.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	kubeconfigv1alpha1 "github.com/zoomoid/kubeconfig-operator/api/v1alpha1"
+	config "github.com/zoomoid/kubeconfig-operator/pkg/kubeconfig"
+)
+
+var _ = Describe("Kubeconfig status redaction", func() {
+	// a kubeconfig as published in the status by previous versions of the operator
+	cfg := config.NewBareConfig()
+	cfg.Users = map[string]config.User{
+		"alice": {ClientCertificate: "Y2VydGlmaWNhdGU=", ClientKey: "cHJpdmF0ZSBrZXk="},
+	}
+	unredacted := string(cfg.Marshal())
+
+	table.DescribeTable("scrubs the client key from the status",
+		func(mode KubeconfigStatusMode, want string) {
+			r := &KubeconfigReconciler{StatusMode: mode}
+			kubeconfig := &kubeconfigv1alpha1.Kubeconfig{}
+			kubeconfig.Status.Kubeconfig = unredacted
+
+			Expect(r.scrubStatusKubeconfig(kubeconfig)).To(BeTrue())
+			Expect(kubeconfig.Status.Kubeconfig).NotTo(ContainSubstring("client-key-data"))
+			Expect(kubeconfig.Status.Kubeconfig).NotTo(ContainSubstring("cHJpdmF0ZSBrZXk="))
+			if want == "" {
+				Expect(kubeconfig.Status.Kubeconfig).To(BeEmpty())
+			} else {
+				Expect(kubeconfig.Status.Kubeconfig).To(ContainSubstring(want))
+			}
+
+			By("leaving a scrubbed status unchanged")
+			Expect(r.scrubStatusKubeconfig(kubeconfig)).To(BeFalse())
+		},
+		table.Entry("in the None mode", KubeconfigStatusNone, ""),
+		table.Entry("in the Redacted mode", KubeconfigStatusRedacted, "Y2VydGlmaWNhdGU="),
+	)
+
+	table.DescribeTable("publishes no client key of issued kubeconfigs",
+		func(mode KubeconfigStatusMode) {
+			r := newTestReconciler()
+			r.StatusMode = mode
+			kubeconfig := newTestKubeconfig("redaction")
+			Expect(k8sClient.Create(ctx, kubeconfig)).To(Succeed())
+
+			now := time.Now()
+			kubeconfig = reconcileIssued(r, kubeconfig, now, now.Add(24*time.Hour))
+			Expect(getUserSecret(kubeconfig).Data[KubeconfigKey]).To(ContainSubstring("client-key-data"))
+			Expect(kubeconfig.Status.Kubeconfig).NotTo(ContainSubstring("client-key-data"))
+			if mode == KubeconfigStatusRedacted {
+				Expect(kubeconfig.Status.Kubeconfig).To(ContainSubstring("client-certificate-data"))
+			} else {
+				Expect(kubeconfig.Status.Kubeconfig).To(BeEmpty())
+			}
+		},
+		table.Entry("in the None mode", KubeconfigStatusNone),
+		table.Entry("in the Redacted mode", KubeconfigStatusRedacted),
+	)
+})
@@ -0,0 +1,136 @@
+/*
+Copyright 2022 zoomoid.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"time"
+
+	kubeconfigv1alpha1 "github.com/zoomoid/kubeconfig-operator/api/v1alpha1"
+	"github.com/zoomoid/kubeconfig-operator/controllers/phases"
+	certificatesv1 "k8s.io/api/certificates/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// reconcileRenewal requeues a finished kubeconfig until the renewal time of the client certificate in its user secret
+// is reached, and then starts requesting a new certificate
+func (r *KubeconfigReconciler) reconcileRenewal(ctx context.Context, kubeconfig *kubeconfigv1alpha1.Kubeconfig, userSecret *corev1.Secret) (ctrl.Result, error) {
+	cert, err := parseCertificate(userSecret.Data[CertificateSecretCertKey])
+	if err != nil {
+		klog.ErrorS(err, "failed to parse client certificate from user secret", "namespace", userSecret.Namespace, "name", userSecret.Name)
+		r.Recorder.Eventf(kubeconfig, "Warning", "CertificateInvalid", "Failed to parse client certificate from user secret, %v", err)
+		return r.beginReissue(ctx, kubeconfig, "CertificateInvalid", fmt.Sprintf("Failed to parse client certificate from user secret, %v", err))
+	}
+
+	certificateChanged := setCertificateStatus(kubeconfig, cert)
+	renewIn, renewalChanged := setRenewalTime(kubeconfig, cert)
+	if certificateChanged || renewalChanged {
+		err = r.Status().Update(ctx, kubeconfig)
+		if err != nil {
+			klog.ErrorS(err, "failed to update kubeconfig status", "name", kubeconfig.Name)
+			return ctrl.Result{}, err
+		}
+	}
+	if renewIn > 0 {
+		klog.V(2).InfoS("Client certificate is still valid, requeuing until renewal", "name", kubeconfig.Name, "renewalTime", kubeconfig.Status.RenewalTime)
+		return ctrl.Result{RequeueAfter: renewIn}, nil
+	}
+
+	message := fmt.Sprintf("Client certificate expires at %s, requesting a new certificate", cert.NotAfter.Format(time.RFC3339))
+	klog.V(0).InfoS("Renewing client certificate", "name", kubeconfig.Name, "notAfter", cert.NotAfter)
+	r.Recorder.Event(kubeconfig, "Normal", "Renewing", message)
+	return r.beginReissue(ctx, kubeconfig, "Renewing", message)
+}
+
+// beginReissue deletes the kubeconfig's current CSR and resets its conditions and its CSR reference, such that the
+// next reconciliation requests a fresh certificate. Kubeconfigs that were issued a certificate before enter the
+// Renewing phase. Credentials still contained in the user secret remain in place until the new certificate is issued,
+// such that the previous kubeconfig stays usable
+func (r *KubeconfigReconciler) beginReissue(ctx context.Context, kubeconfig *kubeconfigv1alpha1.Kubeconfig, reason string, message string) (ctrl.Result, error) {
+	err := r.deleteCurrentCSR(ctx, kubeconfig)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	for _, conditionType := range []string{
+		kubeconfigv1alpha1.ConditionTypeCSRCreated,
+		kubeconfigv1alpha1.ConditionTypeCSRApproved,
+		kubeconfigv1alpha1.ConditionTypeUserSecretFinished,
+		kubeconfigv1alpha1.ConditionTypeKubeconfigFinished,
+	} {
+		meta.SetStatusCondition(&kubeconfig.Status.Conditions, metav1.Condition{
+			Type:    conditionType,
+			Status:  metav1.ConditionUnknown,
+			Reason:  reason,
+			Message: message,
+		})
+	}
+	kubeconfig.Status.Csr = kubeconfigv1alpha1.CsrRef{}
+	kubeconfig.Status.Approvers = nil
+	if kubeconfig.Status.Certificate != nil {
+		kubeconfig.Status.Status = phases.PhaseRenewing
+	} else {
+		kubeconfig.Status.Status = phases.PhasePending
+	}
+	err = r.Status().Update(ctx, kubeconfig)
+	if err != nil {
+		klog.ErrorS(err, "failed to update kubeconfig status for renewal", "name", kubeconfig.Name)
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{Requeue: true}, nil
+}
+
+// setRenewalTime records the renewal time of the given certificate in the kubeconfig's status. It returns
+// the duration until the renewal is due, and whether the status was changed
+func setRenewalTime(kubeconfig *kubeconfigv1alpha1.Kubeconfig, cert *x509.Certificate) (time.Duration, bool) {
+	renewAt := renewalTime(cert.NotBefore, cert.NotAfter, kubeconfig.Spec.RenewBefore)
+	// metav1.Time is serialized with second precision, truncate it to not flap on every reconciliation
+	t := metav1.NewTime(renewAt).Rfc3339Copy()
+	changed := kubeconfig.Status.RenewalTime == nil || !kubeconfig.Status.RenewalTime.Equal(&t)
+	kubeconfig.Status.RenewalTime = &t
+	return time.Until(renewAt), changed
+}
+
+// renewalTime returns the point in time at which credentials valid from notBefore until notAfter should be renewed.
+// If renewBefore is unset or does not fit into the credentials' lifetime, they are renewed after two thirds of their lifetime
+func renewalTime(notBefore time.Time, notAfter time.Time, renewBefore *metav1.Duration) time.Time {
+	lifetime := notAfter.Sub(notBefore)
+	if renewBefore == nil || renewBefore.Duration <= 0 || renewBefore.Duration >= lifetime {
+		return notBefore.Add(lifetime * 2 / 3)
+	}
+	return notAfter.Add(-renewBefore.Duration)
+}
+
+// deleteCurrentCSR removes the CSR referenced in the kubeconfig's status, if any
+func (r *KubeconfigReconciler) deleteCurrentCSR(ctx context.Context, kubeconfig *kubeconfigv1alpha1.Kubeconfig) error {
+	name := kubeconfig.Status.Csr.Name
+	if name == "" {
+		return nil
+	}
+	err := r.Delete(ctx, &certificatesv1.CertificateSigningRequest{ObjectMeta: metav1.ObjectMeta{Name: name}})
+	if err != nil && !apierrors.IsNotFound(err) {
+		klog.ErrorS(err, "failed to delete stale CSR", "name", name)
+		return err
+	}
+	return nil
+}
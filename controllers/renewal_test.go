@@ -0,0 +1,112 @@
+/*
+Copyright 2022 zoomoid.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	"github.com/zoomoid/kubeconfig-operator/controllers/phases"
+	certificatesv1 "k8s.io/api/certificates/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+var _ = Describe("Certificate renewal", func() {
+	Describe("renewalTime", func() {
+		notBefore := time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC)
+		notAfter := notBefore.Add(90 * 24 * time.Hour)
+
+		table.DescribeTable("schedules the renewal",
+			func(renewBefore *metav1.Duration, want time.Time) {
+				Expect(renewalTime(notBefore, notAfter, renewBefore)).To(BeTemporally("==", want))
+			},
+			table.Entry("after two thirds of the lifetime by default", nil, notBefore.Add(60*24*time.Hour)),
+			table.Entry("after two thirds of the lifetime for a zero renewBefore", &metav1.Duration{}, notBefore.Add(60*24*time.Hour)),
+			table.Entry("after two thirds of the lifetime if renewBefore exceeds the lifetime", &metav1.Duration{Duration: 91 * 24 * time.Hour}, notBefore.Add(60*24*time.Hour)),
+			table.Entry("renewBefore ahead of the expiry", &metav1.Duration{Duration: 7 * 24 * time.Hour}, notAfter.Add(-7*24*time.Hour)),
+		)
+	})
+
+	It("requeues a kubeconfig until renewBefore ahead of the expiry", func() {
+		r := newTestReconciler()
+		kubeconfig := newTestKubeconfig("renew-before")
+		kubeconfig.Spec.RenewBefore = &metav1.Duration{Duration: time.Hour}
+		Expect(k8sClient.Create(ctx, kubeconfig)).To(Succeed())
+
+		now := time.Now()
+		notAfter := now.Add(23 * time.Hour)
+		kubeconfig = reconcileIssued(r, kubeconfig, now.Add(-time.Hour), notAfter)
+		Expect(kubeconfig.Status.RenewalTime).NotTo(BeNil())
+		Expect(kubeconfig.Status.RenewalTime.Time).To(BeTemporally("~", notAfter.Add(-time.Hour), time.Second))
+
+		result, err := reconcileKubeconfig(r, kubeconfig)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.RequeueAfter).To(BeNumerically("~", 22*time.Hour, time.Minute))
+		Expect(getKubeconfig(kubeconfig).Status.Status).To(Equal(phases.PhaseDone))
+	})
+
+	It("stages the new key until the renewed certificate is issued", func() {
+		r := newTestReconciler()
+		kubeconfig := newTestKubeconfig("renewal")
+		Expect(k8sClient.Create(ctx, kubeconfig)).To(Succeed())
+
+		// two thirds of the certificate's lifetime have passed
+		now := time.Now()
+		kubeconfig = reconcileIssued(r, kubeconfig, now.Add(-3*time.Hour), now.Add(time.Hour))
+		issued := getUserSecret(kubeconfig)
+
+		By("removing the CSR of the expiring certificate")
+		_, err := reconcileKubeconfig(r, kubeconfig)
+		Expect(err).NotTo(HaveOccurred())
+		renewing := getKubeconfig(kubeconfig)
+		Expect(renewing.Status.Status).To(Equal(phases.PhaseRenewing))
+		Expect(renewing.Status.Csr.Name).To(BeEmpty())
+		err = k8sClient.Get(ctx, types.NamespacedName{Name: kubeconfig.Status.Csr.Name}, &certificatesv1.CertificateSigningRequest{})
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+
+		By("staging the new key next to the current credentials")
+		_, err = reconcileKubeconfig(r, kubeconfig)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = reconcileKubeconfig(r, kubeconfig)
+		Expect(err).NotTo(HaveOccurred())
+		renewing = getKubeconfig(kubeconfig)
+		Expect(renewing.Status.Csr.Name).NotTo(BeEmpty())
+		staged := getUserSecret(kubeconfig)
+		Expect(staged.Data[CertificateSecretPendingPrivKeyKey]).NotTo(BeEmpty())
+		Expect(staged.Data[CertificateSecretPendingCSRKey]).NotTo(BeEmpty())
+		for _, key := range []string{CertificateSecretPrivKeyKey, CertificateSecretCSRKey, CertificateSecretCertKey, KubeconfigKey} {
+			Expect(staged.Data[key]).To(Equal(issued.Data[key]), "key %s changed before the renewed certificate was issued", key)
+		}
+
+		By("promoting the staged key once the renewed certificate is issued")
+		cert := clusterCA.issueCSR(renewing.Status.Csr.Name, now, now.Add(24*time.Hour))
+		_, err = reconcileKubeconfig(r, kubeconfig)
+		Expect(err).NotTo(HaveOccurred())
+		renewed := getUserSecret(kubeconfig)
+		Expect(renewed.Data[CertificateSecretPrivKeyKey]).To(Equal(staged.Data[CertificateSecretPendingPrivKeyKey]))
+		Expect(renewed.Data[CertificateSecretCSRKey]).To(Equal(staged.Data[CertificateSecretPendingCSRKey]))
+		Expect(renewed.Data[CertificateSecretCertKey]).To(Equal(cert))
+		Expect(renewed.Data).NotTo(HaveKey(CertificateSecretPendingPrivKeyKey))
+		Expect(renewed.Data).NotTo(HaveKey(CertificateSecretPendingCSRKey))
+		Expect(getKubeconfig(kubeconfig).Status.Status).To(Equal(phases.PhaseDone))
+	})
+})
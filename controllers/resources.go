@@ -17,10 +17,10 @@ limitations under the License.
 package controllers
 
 import (
-	"bytes"
 	"context"
 	"encoding/base64"
 	"fmt"
+	"strings"
 
 	config "github.com/zoomoid/kubeconfig-operator/pkg/kubeconfig"
 
@@ -33,30 +33,86 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 )
 
+// operatorNamespace is the namespace of the secrets and ServiceAccounts created for the cluster-scoped kubeconfigs
+const operatorNamespace = "kubeconfig-operator-system"
+
 const (
 	CertificateSecretPrivKeyKey = "tls.key"
 	CertificateSecretCSRKey     = "tls.csr"
 	CertificateSecretCertKey    = "tls.crt"
 	KubeconfigKey               = "kubeconfig"
+
+	// Renewals stage the new private key and CSR next to the current credentials
+	// until the renewed certificate is issued
+	CertificateSecretPendingPrivKeyKey = "tls.key.pending"
+	CertificateSecretPendingCSRKey     = "tls.csr.pending"
+
+	// Kubeconfigs with .spec.csr.privateKeyPassphrase additionally hold their private key as encrypted PKCS#8
+	CertificateSecretEncryptedPrivKeyKey        = "tls.key.encrypted"
+	CertificateSecretPendingEncryptedPrivKeyKey = "tls.key.encrypted.pending"
 )
 
-func (r *KubeconfigReconciler) createClusterRoleBinding(kubeconfig *kubeconfigv1alpha1.Kubeconfig, obj types.NamespacedName) *rbacv1.ClusterRoleBinding {
+// createBindings returns the bindings of the kubeconfig's user, of its ServiceAccount in the ServiceAccountToken
+// auth mode, or of its OIDC username and groups in the OIDC auth mode. The cluster role binding for spec.roleRef
+// keeps its previous name such that bindings created by previous versions of the operator are adopted
+func (r *KubeconfigReconciler) createBindings(kubeconfig *kubeconfigv1alpha1.Kubeconfig) bindingSet {
 	labels := labelsForSubresources(kubeconfig)
-	clusterrolebinding := &rbacv1.ClusterRoleBinding{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:   obj.Name,
-			Labels: labels,
+	subjects := []rbacv1.Subject{
+		{
+			Kind:     "User",
+			APIGroup: "rbac.authorization.k8s.io",
+			Name:     kubeconfig.Spec.Username,
 		},
-		Subjects: []rbacv1.Subject{
+	}
+	if kubeconfig.UsesOIDC() {
+		subjects = oidcSubjects(kubeconfig)
+	} else if kubeconfig.UsesServiceAccountToken() {
+		serviceAccount := serviceAccountFor(kubeconfig)
+		subjects = []rbacv1.Subject{
 			{
-				Kind:     "User",
-				APIGroup: "rbac.authorization.k8s.io",
-				Name:     kubeconfig.Spec.Username,
+				Kind:      rbacv1.ServiceAccountKind,
+				Namespace: serviceAccount.Namespace,
+				Name:      serviceAccount.Name,
 			},
+		}
+	}
+	set := bindingSet{
+		owner: kubeconfig,
+		selector: map[string]string{
+			"kubeconfig-operator.k8s.zoomoid.dev/for": kubeconfig.Name,
 		},
-		RoleRef: *kubeconfig.Spec.RoleRef, // This is defaulted by the mutating webhook
 	}
-	return clusterrolebinding
+	if kubeconfig.Spec.RoleRef != nil {
+		set.bindings = append(set.bindings, &rbacv1.ClusterRoleBinding{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   fmt.Sprintf("%s-kubeconfig", kubeconfig.Spec.Username),
+				Labels: labels,
+			},
+			Subjects: subjects,
+			RoleRef:  *kubeconfig.Spec.RoleRef, // This is defaulted by the mutating webhook
+		})
+	}
+	for _, binding := range kubeconfig.Spec.Bindings {
+		objectMeta := metav1.ObjectMeta{
+			Namespace: binding.Namespace,
+			Name:      fmt.Sprintf("%s-%s-%s", kubeconfig.Name, strings.ToLower(binding.RoleRef.Kind), binding.RoleRef.Name),
+			Labels:    labels,
+		}
+		if binding.Namespace == "" {
+			set.bindings = append(set.bindings, &rbacv1.ClusterRoleBinding{
+				ObjectMeta: objectMeta,
+				Subjects:   subjects,
+				RoleRef:    binding.RoleRef,
+			})
+		} else {
+			set.bindings = append(set.bindings, &rbacv1.RoleBinding{
+				ObjectMeta: objectMeta,
+				Subjects:   subjects,
+				RoleRef:    binding.RoleRef,
+			})
+		}
+	}
+	return set
 }
 
 // userSecret wraps the CSR bytes in a Kubernetes secret object and sets
@@ -84,33 +140,46 @@ func (r *KubeconfigReconciler) createUserSecret(kubeconfig *kubeconfigv1alpha1.K
 	return secret
 }
 
+// csrNameFor returns the name of the CSR requested for the kubeconfig's current generation. Suffixing the generation
+// keeps the CSRs of rotated credentials apart
+func csrNameFor(kubeconfig *kubeconfigv1alpha1.Kubeconfig) string {
+	return fmt.Sprintf("%s-%d", kubeconfig.Name, kubeconfig.Generation)
+}
+
 // createCsr creates a new certificate signing request object and sets the kubeconfig
 // controller as owner.
-// If the kubeconfig's AutoApproveCSR field is set to true, sets an annotation for the csr controller to auto-approve the CSR
-func (r *KubeconfigReconciler) createCsr(kubeconfig *kubeconfigv1alpha1.Kubeconfig, csrBuffer *bytes.Buffer) *certificatesv1.CertificateSigningRequest {
+// If the kubeconfig's AutoApproveCSR field is set to true, sets an annotation for the csr controller to auto-approve the CSR.
+// A requested certificate lifetime is passed on to the signer as expirationSeconds
+func (r *KubeconfigReconciler) createCsr(kubeconfig *kubeconfigv1alpha1.Kubeconfig, csrPEM []byte) *certificatesv1.CertificateSigningRequest {
 	labels := labelsForSubresources(kubeconfig)
 
-	annotations := map[string]string{}
+	annotations := map[string]string{
+		CSRCredentialsSpecHashAnnotationKey: credentialsSpecHash(kubeconfig),
+		CSRRolesHashAnnotationKey:           rolesHash(kubeconfig),
+	}
 	if kubeconfig.Spec.AutoApproveCSR {
 		annotations[CSRAutoApproveAnnotationKey] = "true"
 	}
 
 	csr := &certificatesv1.CertificateSigningRequest{
 		ObjectMeta: metav1.ObjectMeta{
-			Name: kubeconfig.Name,
+			Name: csrNameFor(kubeconfig),
 			// Namespace:   "kubeconfig-operator-system",
 			Labels:      labels,
 			Annotations: annotations,
 		},
 		Spec: certificatesv1.CertificateSigningRequestSpec{
-			Request:    csrBuffer.Bytes(),
-			SignerName: "kubernetes.io/kube-apiserver-client",
-			Usages: []certificatesv1.KeyUsage{
-				certificatesv1.UsageClientAuth,
-			},
+			Request:    csrPEM,
+			SignerName: signerNameFor(kubeconfig, r.SignerName),
+			Usages:     usagesFor(kubeconfig),
 		},
 	}
 
+	if kubeconfig.Spec.CSR != nil && kubeconfig.Spec.CSR.Duration != nil {
+		expirationSeconds := int32(kubeconfig.Spec.CSR.Duration.Seconds())
+		csr.Spec.ExpirationSeconds = &expirationSeconds
+	}
+
 	controllerutil.SetControllerReference(kubeconfig, csr, r.Scheme)
 	return csr
 }
@@ -124,13 +193,26 @@ func (r *KubeconfigReconciler) createCsr(kubeconfig *kubeconfigv1alpha1.Kubeconf
 // and (b) the client's private key and the approved certificate from the secret that tracks the
 // client data.
 func (r *KubeconfigReconciler) createKubeconfig(ctx context.Context, kubeconfig *kubeconfigv1alpha1.Kubeconfig, secret *corev1.Secret) ([]byte, error) {
-	clusterCA, err := r.ClusterCA(ctx)
+	clientKey, clientCert, err := r.ClientData(ctx, secret)
 	if err != nil {
-		// Failed to get kube root CA, fail
 		return nil, err
 	}
-	clientKey, clientCert, err := r.ClientData(ctx, secret)
+	user := config.User{
+		ClientCertificate: base64.StdEncoding.EncodeToString([]byte(clientCert)),
+	}
+	// kubeconfigs for existing CSRs are completed by the user locally with their private key
+	if kubeconfig.Spec.ExistingCSR == nil {
+		user.ClientKey = base64.StdEncoding.EncodeToString([]byte(clientKey))
+	}
+	return r.templateKubeconfig(ctx, kubeconfig, user)
+}
+
+// templateKubeconfig creates a kubeconfig for the given user credentials with the kubeconfig's cluster as
+// its current context
+func (r *KubeconfigReconciler) templateKubeconfig(ctx context.Context, kubeconfig *kubeconfigv1alpha1.Kubeconfig, user config.User) ([]byte, error) {
+	clusterCA, err := r.ClusterCA(ctx)
 	if err != nil {
+		// Failed to get kube root CA, fail
 		return nil, err
 	}
 
@@ -143,10 +225,7 @@ func (r *KubeconfigReconciler) createKubeconfig(ctx context.Context, kubeconfig
 		},
 	}
 	cfg.Users = map[string]config.User{
-		kubeconfig.Spec.Username: {
-			ClientCertificate: base64.StdEncoding.EncodeToString([]byte(clientCert)),
-			ClientKey:         base64.StdEncoding.EncodeToString([]byte(clientKey)),
-		},
+		kubeconfig.Spec.Username: user,
 	}
 	contextName := fmt.Sprintf("%s@%s", kubeconfig.Spec.Username, kubeconfig.Spec.Cluster.Name)
 	cfg.Contexts = map[string]config.Context{
@@ -0,0 +1,135 @@
+/*
+Copyright 2022 zoomoid.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	kubeconfigv1alpha1 "github.com/zoomoid/kubeconfig-operator/api/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+const (
+	// RetryAnnotationKey is the annotation that makes the controller restart the workflow of a failed kubeconfig
+	RetryAnnotationKey = "kubeconfig.k8s.zoomoid.dev/retry"
+	// ReRequestAnnotationKey is the annotation that makes the controller discard the kubeconfig's current CSR and
+	// request a fresh one, e.g., after the CSR was denied. It behaves like RetryAnnotationKey
+	ReRequestAnnotationKey = "kubeconfig.k8s.zoomoid.dev/re-request"
+
+	// maxRetryBackoff caps the exponential backoff between automatic retries
+	maxRetryBackoff = 1 * time.Hour
+)
+
+// retryableReasons are the reasons of a false KubeconfigFinished condition that denote transient failures, which
+// are retried automatically. Denied CSRs, invalid existing CSRs and unmet approval quorums require user intervention
+var retryableReasons = []string{
+	"CsrCreateFailed",
+	"CsrFailed",
+}
+
+// RetryOptions configures the automatic retries of kubeconfigs that failed transiently
+type RetryOptions struct {
+	// MaxRetries is the number of automatic retries before a kubeconfig remains failed. Zero disables automatic retries
+	MaxRetries int
+	// Backoff is the delay before the first automatic retry, which doubles with every subsequent retry
+	Backoff time.Duration
+}
+
+// backoff returns the delay before the automatic retry following the given number of previous retries
+func (o RetryOptions) backoff(retries int32) time.Duration {
+	backoff := o.Backoff
+	for i := int32(0); i < retries && backoff < maxRetryBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxRetryBackoff {
+		return maxRetryBackoff
+	}
+	return backoff
+}
+
+// hasRetryAnnotation returns true if the kubeconfig was annotated to restart its workflow
+func hasRetryAnnotation(kubeconfig *kubeconfigv1alpha1.Kubeconfig) bool {
+	_, retry := kubeconfig.Annotations[RetryAnnotationKey]
+	_, reRequest := kubeconfig.Annotations[ReRequestAnnotationKey]
+	return retry || reRequest
+}
+
+// isRetryable returns true if the kubeconfig failed for a transient reason
+func isRetryable(kubeconfig *kubeconfigv1alpha1.Kubeconfig) bool {
+	f := meta.FindStatusCondition(kubeconfig.Status.Conditions, kubeconfigv1alpha1.ConditionTypeKubeconfigFinished)
+	if f == nil || f.Status != metav1.ConditionFalse {
+		return false
+	}
+	for _, reason := range retryableReasons {
+		if f.Reason == reason {
+			return true
+		}
+	}
+	return false
+}
+
+// retry removes the retry annotations and the kubeconfig's current CSR, and restarts the CSR workflow. Manual retries
+// reset the budget of automatic retries. Revoked kubeconfigs only lose the annotations, revocation cannot be undone
+func (r *KubeconfigReconciler) retry(ctx context.Context, kubeconfig *kubeconfigv1alpha1.Kubeconfig) (ctrl.Result, error) {
+	delete(kubeconfig.Annotations, RetryAnnotationKey)
+	delete(kubeconfig.Annotations, ReRequestAnnotationKey)
+	err := r.Update(ctx, kubeconfig)
+	if err != nil {
+		klog.ErrorS(err, "failed to remove retry annotation", "name", kubeconfig.Name)
+		return ctrl.Result{}, err
+	}
+
+	if kubeconfig.Spec.Revoked {
+		klog.V(0).InfoS("Ignoring retry annotation of revoked kubeconfig", "name", kubeconfig.Name)
+		r.Recorder.Event(kubeconfig, "Warning", "RetryIgnored", "Ignored retry annotation, revoked kubeconfigs are never issued new credentials")
+		return r.reconcileRevoked(ctx, kubeconfig)
+	}
+
+	message := "Restarted the kubeconfig workflow with a fresh CSR as requested by annotation"
+	klog.V(0).InfoS("Retrying kubeconfig as requested by annotation", "name", kubeconfig.Name)
+	r.Recorder.Event(kubeconfig, "Normal", "Retried", message)
+	kubeconfig.Status.Retries = 0
+	return r.beginReissue(ctx, kubeconfig, "Retried", message)
+}
+
+// reconcileRetry automatically restarts the workflow of a kubeconfig that failed transiently, with an exponential
+// backoff between retries, until the retry budget is exhausted
+func (r *KubeconfigReconciler) reconcileRetry(ctx context.Context, kubeconfig *kubeconfigv1alpha1.Kubeconfig) (ctrl.Result, error) {
+	retries := kubeconfig.Status.Retries
+	if int(retries) >= r.Retry.MaxRetries {
+		klog.V(2).InfoS("Kubeconfig exhausted its automatic retries, skipping reconciliation", "name", kubeconfig.Name, "retries", retries)
+		return ctrl.Result{}, nil
+	}
+
+	f := meta.FindStatusCondition(kubeconfig.Status.Conditions, kubeconfigv1alpha1.ConditionTypeKubeconfigFinished)
+	retryAt := f.LastTransitionTime.Add(r.Retry.backoff(retries))
+	if wait := time.Until(retryAt); wait > 0 {
+		klog.V(2).InfoS("Kubeconfig failed transiently, waiting for next retry", "name", kubeconfig.Name, "retries", retries, "retryAt", retryAt)
+		return ctrl.Result{RequeueAfter: wait}, nil
+	}
+
+	message := fmt.Sprintf("Retrying after transient failure (%d/%d), %s", retries+1, r.Retry.MaxRetries, f.Message)
+	klog.V(0).InfoS("Retrying kubeconfig after transient failure", "name", kubeconfig.Name, "reason", f.Reason, "retry", retries+1)
+	r.Recorder.Event(kubeconfig, "Normal", "Retrying", message)
+	kubeconfig.Status.Retries = retries + 1
+	return r.beginReissue(ctx, kubeconfig, "Retrying", message)
+}
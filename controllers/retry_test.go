@@ -0,0 +1,192 @@
+/*
+Copyright 2022 zoomoid.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	kubeconfigv1alpha1 "github.com/zoomoid/kubeconfig-operator/api/v1alpha1"
+	"github.com/zoomoid/kubeconfig-operator/controllers/phases"
+	certificatesv1 "k8s.io/api/certificates/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+var _ = Describe("Retries", func() {
+	// failKubeconfig creates a kubeconfig with a CSR whose KubeconfigFinished condition failed for the reason at the
+	// given time
+	failKubeconfig := func(reason string, failedAt time.Time) *kubeconfigv1alpha1.Kubeconfig {
+		kubeconfig := newTestKubeconfig("retry")
+		createTestKubeconfig(kubeconfig)
+		csr := createTestCSR(kubeconfig, kubeconfig)
+		kubeconfig.Status.Status = phases.PhaseFailed
+		kubeconfig.Status.Csr = kubeconfigv1alpha1.CsrRef{Name: csr.Name, Generation: kubeconfig.Generation}
+		meta.SetStatusCondition(&kubeconfig.Status.Conditions, metav1.Condition{
+			Type:               kubeconfigv1alpha1.ConditionTypeKubeconfigFinished,
+			Status:             metav1.ConditionFalse,
+			Reason:             reason,
+			Message:            "Kubeconfig creation failed in CSR stage",
+			LastTransitionTime: metav1.NewTime(failedAt),
+		})
+		Expect(k8sClient.Status().Update(ctx, kubeconfig)).To(Succeed())
+		return kubeconfig
+	}
+
+	// expectRestarted asserts that the kubeconfig's CSR was deleted and its workflow restarted for the reason
+	expectRestarted := func(kubeconfig *kubeconfigv1alpha1.Kubeconfig, reason string) *kubeconfigv1alpha1.Kubeconfig {
+		err := k8sClient.Get(ctx, types.NamespacedName{Name: kubeconfig.Status.Csr.Name}, &certificatesv1.CertificateSigningRequest{})
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+		restarted := getKubeconfig(kubeconfig)
+		Expect(restarted.Status.Status).To(Equal(phases.PhasePending))
+		Expect(restarted.Status.Csr.Name).To(BeEmpty())
+		finished := meta.FindStatusCondition(restarted.Status.Conditions, kubeconfigv1alpha1.ConditionTypeKubeconfigFinished)
+		Expect(finished).NotTo(BeNil())
+		Expect(finished.Status).To(Equal(metav1.ConditionUnknown))
+		Expect(finished.Reason).To(Equal(reason))
+		return restarted
+	}
+
+	table.DescribeTable("doubles the backoff with every retry",
+		func(retries int32, want time.Duration) {
+			options := RetryOptions{MaxRetries: 10, Backoff: time.Minute}
+			Expect(options.backoff(retries)).To(Equal(want))
+		},
+		table.Entry("before the first retry", int32(0), time.Minute),
+		table.Entry("after one retry", int32(1), 2*time.Minute),
+		table.Entry("after three retries", int32(3), 8*time.Minute),
+		table.Entry("up to the maximum", int32(6), maxRetryBackoff),
+		table.Entry("without overflowing", int32(1000), maxRetryBackoff),
+	)
+
+	table.DescribeTable("only retries transient failures automatically",
+		func(reason string, want bool) {
+			kubeconfig := &kubeconfigv1alpha1.Kubeconfig{}
+			if reason != "" {
+				kubeconfig.Status.Conditions = []metav1.Condition{
+					{Type: kubeconfigv1alpha1.ConditionTypeKubeconfigFinished, Status: metav1.ConditionFalse, Reason: reason},
+				}
+			}
+			Expect(isRetryable(kubeconfig)).To(Equal(want))
+		},
+		table.Entry("when the CSR could not be created", "CsrCreateFailed", true),
+		table.Entry("when the CSR failed", "CsrFailed", true),
+		table.Entry("unless the CSR was denied", "CsrDenied", false),
+		table.Entry("unless the approval quorum was not met", "ApprovalQuorumNotMet", false),
+		table.Entry("unless the kubeconfig did not fail", "", false),
+	)
+
+	Context("automatic retries", func() {
+		var r *KubeconfigReconciler
+
+		BeforeEach(func() {
+			r = newTestReconciler()
+			r.Retry = RetryOptions{MaxRetries: 3, Backoff: time.Minute}
+		})
+
+		It("restarts the workflow once the backoff passed", func() {
+			kubeconfig := failKubeconfig("CsrFailed", time.Now().Add(-time.Hour))
+			kubeconfig.Status.Retries = 1
+			Expect(k8sClient.Status().Update(ctx, kubeconfig)).To(Succeed())
+
+			result, err := reconcileKubeconfig(r, kubeconfig)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Requeue).To(BeTrue())
+			Expect(expectRestarted(kubeconfig, "Retrying").Status.Retries).To(BeEquivalentTo(2))
+		})
+
+		It("waits for the remaining backoff", func() {
+			kubeconfig := failKubeconfig("CsrFailed", time.Now())
+			kubeconfig.Status.Retries = 1
+			Expect(k8sClient.Status().Update(ctx, kubeconfig)).To(Succeed())
+
+			result, err := reconcileKubeconfig(r, kubeconfig)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.RequeueAfter).To(BeNumerically(">", 0))
+			Expect(result.RequeueAfter).To(BeNumerically("<=", 2*time.Minute))
+			Expect(getKubeconfig(kubeconfig).Status.Retries).To(BeEquivalentTo(1))
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: kubeconfig.Status.Csr.Name}, &certificatesv1.CertificateSigningRequest{})).To(Succeed())
+		})
+
+		It("stops once the retry budget is exhausted", func() {
+			kubeconfig := failKubeconfig("CsrFailed", time.Now().Add(-24*time.Hour))
+			kubeconfig.Status.Retries = 3
+			Expect(k8sClient.Status().Update(ctx, kubeconfig)).To(Succeed())
+
+			_, err := reconcileKubeconfig(r, kubeconfig)
+			Expect(err).NotTo(HaveOccurred())
+			failed := getKubeconfig(kubeconfig)
+			Expect(failed.Status.Status).To(Equal(phases.PhaseFailed))
+			Expect(failed.Status.Retries).To(BeEquivalentTo(3))
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: kubeconfig.Status.Csr.Name}, &certificatesv1.CertificateSigningRequest{})).To(Succeed())
+		})
+	})
+
+	table.DescribeTable("restarts the workflow when annotated",
+		func(annotation string) {
+			r := newTestReconciler()
+			kubeconfig := failKubeconfig("CsrDenied", time.Now())
+			kubeconfig.Status.Retries = 3
+			Expect(k8sClient.Status().Update(ctx, kubeconfig)).To(Succeed())
+			kubeconfig.Annotations = map[string]string{annotation: "", "keep": "true"}
+			Expect(k8sClient.Update(ctx, kubeconfig)).To(Succeed())
+
+			_, err := reconcileKubeconfig(r, kubeconfig)
+			Expect(err).NotTo(HaveOccurred())
+			restarted := expectRestarted(kubeconfig, "Retried")
+			Expect(restarted.Annotations).NotTo(HaveKey(annotation))
+			Expect(restarted.Annotations).To(HaveKeyWithValue("keep", "true"))
+			Expect(restarted.Status.Retries).To(BeZero())
+		},
+		table.Entry("with the retry annotation", RetryAnnotationKey),
+		table.Entry("with the re-request annotation", ReRequestAnnotationKey),
+	)
+
+	table.DescribeTable("never restarts the workflow of revoked kubeconfigs",
+		func(annotation string) {
+			r := newTestReconciler()
+			kubeconfig := failKubeconfig("CsrDenied", time.Now())
+			kubeconfig.Spec.Revoked = true
+			Expect(k8sClient.Update(ctx, kubeconfig)).To(Succeed())
+			_, err := reconcileKubeconfig(r, kubeconfig)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(getKubeconfig(kubeconfig).Status.Status).To(Equal(phases.PhaseRevoked))
+
+			annotated := getKubeconfig(kubeconfig)
+			annotated.Annotations = map[string]string{annotation: ""}
+			Expect(k8sClient.Update(ctx, annotated)).To(Succeed())
+			for i := 0; i < 2; i++ {
+				_, err = reconcileKubeconfig(r, kubeconfig)
+				Expect(err).NotTo(HaveOccurred())
+			}
+
+			revoked := getKubeconfig(kubeconfig)
+			Expect(revoked.Annotations).NotTo(HaveKey(annotation))
+			Expect(revoked.Status.Status).To(Equal(phases.PhaseRevoked))
+			Expect(meta.IsStatusConditionTrue(revoked.Status.Conditions, kubeconfigv1alpha1.ConditionTypeRevoked)).To(BeTrue())
+			err = k8sClient.Get(ctx, types.NamespacedName{Name: kubeconfig.Status.Csr.Name}, &certificatesv1.CertificateSigningRequest{})
+			Expect(apierrors.IsNotFound(err)).To(BeTrue())
+		},
+		table.Entry("with the retry annotation", RetryAnnotationKey),
+		table.Entry("with the re-request annotation", ReRequestAnnotationKey),
+	)
+})
@@ -0,0 +1,262 @@
+/*
+Copyright 2022 zoomoid.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	kubeconfigv1alpha1 "github.com/zoomoid/kubeconfig-operator/api/v1alpha1"
+	"github.com/zoomoid/kubeconfig-operator/controllers/phases"
+	certificatesv1 "k8s.io/api/certificates/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// reconcileRevoked removes all operator-managed role bindings of a revoked kubeconfig's username, records its
+// certificates in the revocation list, and removes its CSRs and the secrets containing its kubeconfig
+func (r *KubeconfigReconciler) reconcileRevoked(ctx context.Context, kubeconfig *kubeconfigv1alpha1.Kubeconfig) (ctrl.Result, error) {
+	// bindings are removed on every reconciliation, such that bindings recreated in the meantime are caught as well
+	err := r.deleteUserBindings(ctx, kubeconfig.Spec.Username)
+	if err != nil {
+		r.Recorder.Eventf(kubeconfig, "Warning", "RevocationFailed", "Failed to remove role bindings of user %s, %v", kubeconfig.Spec.Username, err)
+		return ctrl.Result{}, err
+	}
+	if meta.IsStatusConditionTrue(kubeconfig.Status.Conditions, kubeconfigv1alpha1.ConditionTypeRevoked) {
+		return ctrl.Result{}, nil
+	}
+
+	klog.V(0).InfoS("Revoking kubeconfig", "name", kubeconfig.Name, "user", kubeconfig.Spec.Username)
+	err = r.recordRevocation(ctx, kubeconfig)
+	if err != nil {
+		klog.ErrorS(err, "failed to record revoked certificates", "name", kubeconfig.Name)
+		r.Recorder.Eventf(kubeconfig, "Warning", "RevocationFailed", "Failed to record revoked certificates, %v", err)
+		return ctrl.Result{}, err
+	}
+
+	err = r.deleteCSRs(ctx, kubeconfig)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if kubeconfig.UsesServiceAccountToken() {
+		err = r.deleteServiceAccount(ctx, kubeconfig)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+	var secrets []kubeconfigv1alpha1.SecretRef
+	if kubeconfig.Spec.ExistingCSR == nil && kubeconfig.Status.UserSecret.Name != "" {
+		// secrets provided by the user are left in place, they do not contain a private key
+		secrets = append(secrets, kubeconfig.Status.UserSecret)
+	}
+	err = r.deleteSecrets(ctx, kubeconfig, secrets)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if kubeconfig.Status.TargetSecret != nil {
+		err = r.deleteTargetSecret(ctx, kubeconfig, *kubeconfig.Status.TargetSecret)
+		if err != nil {
+			r.Recorder.Eventf(kubeconfig, "Warning", "CleanupFailed", "Failed to remove target secret, %v", err)
+			return ctrl.Result{}, err
+		}
+	}
+
+	message := "Removed role bindings and recorded the client certificates in the revocation list"
+	meta.SetStatusCondition(&kubeconfig.Status.Conditions, metav1.Condition{
+		Type:    kubeconfigv1alpha1.ConditionTypeRevoked,
+		Status:  metav1.ConditionTrue,
+		Reason:  "Revoked",
+		Message: message,
+	})
+	meta.SetStatusCondition(&kubeconfig.Status.Conditions, metav1.Condition{
+		Type:    kubeconfigv1alpha1.ConditionTypeRBACSynced,
+		Status:  metav1.ConditionFalse,
+		Reason:  "Revoked",
+		Message: "Role bindings were removed because the kubeconfig was revoked",
+	})
+	kubeconfig.Status.TargetSecret = nil
+	kubeconfig.Status.RenewalTime = nil
+	kubeconfig.Status.Status = phases.PhaseRevoked
+	r.Recorder.Event(kubeconfig, "Normal", "Revoked", message)
+	err = r.Status().Update(ctx, kubeconfig)
+	if err != nil {
+		klog.ErrorS(err, "failed to update kubeconfig status after revocation", "name", kubeconfig.Name)
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{}, nil
+}
+
+// deleteUserBindings removes the operator-managed ClusterRoleBindings and RoleBindings of all kubeconfigs of the username
+func (r *KubeconfigReconciler) deleteUserBindings(ctx context.Context, username string) error {
+	selector := client.MatchingLabels{
+		"kubeconfig-operator.k8s.zoomoid.dev/username": username,
+	}
+	crbs := &rbacv1.ClusterRoleBindingList{}
+	err := r.List(ctx, crbs, selector)
+	if err != nil {
+		return err
+	}
+	rbs := &rbacv1.RoleBindingList{}
+	err = r.List(ctx, rbs, selector)
+	if err != nil {
+		return err
+	}
+	var bindings []client.Object
+	for i := range crbs.Items {
+		bindings = append(bindings, &crbs.Items[i])
+	}
+	for i := range rbs.Items {
+		bindings = append(bindings, &rbs.Items[i])
+	}
+	for _, binding := range bindings {
+		err = r.Delete(ctx, binding)
+		if err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+		klog.V(2).InfoS("Removed binding of revoked user", "user", username, "namespace", binding.GetNamespace(), "name", binding.GetName())
+	}
+	return nil
+}
+
+// recordRevocation adds all unexpired certificates issued for the kubeconfig to the revocation list and removes
+// expired entries. Besides the current certificate, these are the certificates replaced by renewals and rotations,
+// and certificates issued on CSRs whose certificate was not yet delivered to the user secret
+func (r *KubeconfigReconciler) recordRevocation(ctx context.Context, kubeconfig *kubeconfigv1alpha1.Kubeconfig) error {
+	now := time.Now()
+	certificates, err := r.issuedCertificates(ctx, kubeconfig, now)
+	if err != nil {
+		return err
+	}
+	if len(certificates) == 0 {
+		// no certificate was issued yet, and its CSR is removed during revocation
+		klog.V(2).InfoS("Kubeconfig has no issued certificate, nothing to record", "name", kubeconfig.Name)
+		return nil
+	}
+
+	list := &kubeconfigv1alpha1.RevocationList{}
+	err = r.Get(ctx, types.NamespacedName{Name: kubeconfigv1alpha1.RevocationListName}, list)
+	notFound := apierrors.IsNotFound(err)
+	if err != nil && !notFound {
+		return err
+	}
+
+	recorded := map[string]bool{}
+	entries := []kubeconfigv1alpha1.RevocationEntry{}
+	for _, entry := range list.Spec.Entries {
+		if !now.Before(entry.NotAfter.Time) {
+			continue
+		}
+		if entry.Username == kubeconfig.Spec.Username {
+			// already recorded by a previous reconciliation whose status update failed
+			recorded[entry.SerialNumber] = true
+		}
+		entries = append(entries, entry)
+	}
+	changed := len(entries) != len(list.Spec.Entries)
+	for _, certificate := range certificates {
+		if recorded[certificate.SerialNumber] {
+			continue
+		}
+		entries = append(entries, kubeconfigv1alpha1.RevocationEntry{
+			Username:     kubeconfig.Spec.Username,
+			Kubeconfig:   kubeconfig.Name,
+			SerialNumber: certificate.SerialNumber,
+			Fingerprint:  certificate.Fingerprint,
+			RevokedAt:    metav1.NewTime(now),
+			NotAfter:     certificate.NotAfter,
+		})
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	list.Spec.Entries = entries
+
+	if notFound {
+		list.Name = kubeconfigv1alpha1.RevocationListName
+		return r.Create(ctx, list)
+	}
+	return r.Update(ctx, list)
+}
+
+// issuedCertificates returns the unexpired certificates issued for the kubeconfig, collected from its status and
+// from the CSRs created for it
+func (r *KubeconfigReconciler) issuedCertificates(ctx context.Context, kubeconfig *kubeconfigv1alpha1.Kubeconfig, now time.Time) ([]kubeconfigv1alpha1.IssuedCertificate, error) {
+	status := kubeconfig.Status.DeepCopy()
+	if certificate := status.Certificate; certificate != nil && certificate.NotAfter != nil {
+		// kubeconfigs issued before the operator recorded issued certificates only know their current certificate
+		status.IssuedCertificates = append(status.IssuedCertificates, kubeconfigv1alpha1.IssuedCertificate{
+			SerialNumber: certificate.SerialNumber,
+			Fingerprint:  certificate.Fingerprint,
+			NotAfter:     *certificate.NotAfter,
+		})
+	}
+
+	csrs := &certificatesv1.CertificateSigningRequestList{}
+	err := r.List(ctx, csrs, client.MatchingLabels{
+		"kubeconfig-operator.k8s.zoomoid.dev/for": kubeconfig.Name,
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i := range csrs.Items {
+		csr := &csrs.Items[i]
+		if !metav1.IsControlledBy(csr, kubeconfig) || len(csr.Status.Certificate) == 0 {
+			continue
+		}
+		cert, err := parseCertificate(csr.Status.Certificate)
+		if err != nil {
+			klog.ErrorS(err, "failed to parse certificate of CSR, cannot record it as revoked", "name", csr.Name)
+			continue
+		}
+		status.IssuedCertificates = append(status.IssuedCertificates, kubeconfigv1alpha1.IssuedCertificate{
+			SerialNumber: strings.ToUpper(cert.SerialNumber.Text(16)),
+			Fingerprint:  certificateFingerprint(cert),
+			NotAfter:     metav1.NewTime(cert.NotAfter),
+		})
+	}
+
+	seen := map[string]bool{}
+	certificates := []kubeconfigv1alpha1.IssuedCertificate{}
+	for _, certificate := range status.IssuedCertificates {
+		if seen[certificate.SerialNumber] || !now.Before(certificate.NotAfter.Time) {
+			continue
+		}
+		seen[certificate.SerialNumber] = true
+		certificates = append(certificates, certificate)
+	}
+	return certificates, nil
+}
+
+// revocationFor returns the revocation list entry that currently revokes the username, if any
+func (r *KubeconfigReconciler) revocationFor(ctx context.Context, username string) (*kubeconfigv1alpha1.RevocationEntry, error) {
+	list := &kubeconfigv1alpha1.RevocationList{}
+	err := r.Get(ctx, types.NamespacedName{Name: kubeconfigv1alpha1.RevocationListName}, list)
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return list.RevokedUsername(username, time.Now()), nil
+}
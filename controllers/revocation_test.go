@@ -0,0 +1,108 @@
+/*
+Copyright 2022 zoomoid.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	kubeconfigv1alpha1 "github.com/zoomoid/kubeconfig-operator/api/v1alpha1"
+	"github.com/zoomoid/kubeconfig-operator/controllers/phases"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+var _ = Describe("Revocation", func() {
+	// renew reconciles a kubeconfig whose renewal time passed until the CSR for the new certificate is requested,
+	// and returns the CSR's name
+	renew := func(r *KubeconfigReconciler, kubeconfig *kubeconfigv1alpha1.Kubeconfig) string {
+		for i := 0; i < 3; i++ {
+			_, err := reconcileKubeconfig(r, kubeconfig)
+			Expect(err).NotTo(HaveOccurred())
+		}
+		renewing := getKubeconfig(kubeconfig)
+		Expect(renewing.Status.Status).To(Equal(phases.PhaseRenewing))
+		Expect(renewing.Status.Csr.Name).NotTo(BeEmpty())
+		return renewing.Status.Csr.Name
+	}
+
+	// serialNumber returns the serial number of the PEM-encoded certificate as published in the status
+	serialNumber := func(certPEM []byte) string {
+		cert, err := parseCertificate(certPEM)
+		Expect(err).NotTo(HaveOccurred())
+		return strings.ToUpper(cert.SerialNumber.Text(16))
+	}
+
+	It("records every unexpired certificate issued for the kubeconfig", func() {
+		r := newTestReconciler()
+		kubeconfig := newTestKubeconfig("revocation")
+		Expect(k8sClient.Create(ctx, kubeconfig)).To(Succeed())
+		now := time.Now()
+		kubeconfig = reconcileIssued(r, kubeconfig, now.Add(-3*time.Hour), now.Add(time.Hour))
+		first := serialNumber(getUserSecret(kubeconfig).Data[CertificateSecretCertKey])
+
+		By("replacing the first certificate by a renewal")
+		second := serialNumber(clusterCA.issueCSR(renew(r, kubeconfig), now.Add(-5*time.Hour), now.Add(2*time.Hour)))
+		_, err := reconcileKubeconfig(r, kubeconfig)
+		Expect(err).NotTo(HaveOccurred())
+		renewed := getKubeconfig(kubeconfig)
+		Expect(renewed.Status.Certificate.SerialNumber).To(Equal(second))
+		Expect(renewed.Status.IssuedCertificates).To(HaveLen(2))
+
+		By("issuing a third certificate that is not delivered to the user secret yet")
+		thirdNotAfter := now.Add(24 * time.Hour).Truncate(time.Second)
+		third := serialNumber(clusterCA.issueCSR(renew(r, kubeconfig), now, thirdNotAfter))
+
+		revoked := getKubeconfig(kubeconfig)
+		revoked.Spec.Revoked = true
+		Expect(k8sClient.Update(ctx, revoked)).To(Succeed())
+		_, err = reconcileKubeconfig(r, kubeconfig)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(getKubeconfig(kubeconfig).Status.Status).To(Equal(phases.PhaseRevoked))
+
+		list := &kubeconfigv1alpha1.RevocationList{}
+		Expect(k8sClient.Get(ctx, types.NamespacedName{Name: kubeconfigv1alpha1.RevocationListName}, list)).To(Succeed())
+		var serialNumbers []string
+		for _, entry := range list.Spec.Entries {
+			if entry.Username == kubeconfig.Spec.Username {
+				Expect(entry.Kubeconfig).To(Equal(kubeconfig.Name))
+				serialNumbers = append(serialNumbers, entry.SerialNumber)
+			}
+		}
+		Expect(serialNumbers).To(ConsistOf(first, second, third))
+
+		By("blocking the username until the last certificate expires")
+		entry := list.RevokedUsername(kubeconfig.Spec.Username, now.Add(12*time.Hour))
+		Expect(entry).NotTo(BeNil())
+		Expect(entry.SerialNumber).To(Equal(third))
+		Expect(entry.NotAfter.Time).To(BeTemporally("==", thirdNotAfter))
+
+		By("recording each certificate only once")
+		revoked = getKubeconfig(kubeconfig)
+		Expect(r.recordRevocation(ctx, revoked)).To(Succeed())
+		Expect(k8sClient.Get(ctx, types.NamespacedName{Name: kubeconfigv1alpha1.RevocationListName}, list)).To(Succeed())
+		count := 0
+		for _, entry := range list.Spec.Entries {
+			if entry.Username == kubeconfig.Spec.Username {
+				count++
+			}
+		}
+		Expect(count).To(Equal(3))
+	})
+})
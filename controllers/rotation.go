@@ -0,0 +1,191 @@
+/*
+Copyright 2022 zoomoid.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	kubeconfigv1alpha1 "github.com/zoomoid/kubeconfig-operator/api/v1alpha1"
+	"github.com/zoomoid/kubeconfig-operator/controllers/phases"
+	certificatesv1 "k8s.io/api/certificates/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// CSRCredentialsSpecHashAnnotationKey records the credentialsSpecHash of the kubeconfig on the CSRs requested for it
+const CSRCredentialsSpecHashAnnotationKey = "kubeconfig.k8s.zoomoid.dev/credentials-spec-hash"
+
+// CSRRolesHashAnnotationKey records the rolesHash of the kubeconfig on the CSRs requested for it
+const CSRRolesHashAnnotationKey = "kubeconfig.k8s.zoomoid.dev/roles-hash"
+
+// credentialsSpecHash returns a hash of the kubeconfig's .spec.csr, or .spec.serviceAccountToken in the
+// ServiceAccountToken auth mode, and .spec.rotationToken, which identifies the parameters that credentials were
+// requested with
+func credentialsSpecHash(kubeconfig *kubeconfigv1alpha1.Kubeconfig) string {
+	var params interface{} = kubeconfig.Spec.CSR
+	if kubeconfig.UsesServiceAccountToken() {
+		params = kubeconfig.Spec.ServiceAccountToken
+	}
+	data, err := json.Marshal(params)
+	if err != nil {
+		// cannot happen for the plain structs, but never rotate credentials because of it
+		klog.ErrorS(err, "failed to marshal credential parameters for hashing", "name", kubeconfig.Name)
+		return ""
+	}
+	h := sha256.New()
+	h.Write(data)
+	if token := kubeconfig.Spec.RotationToken; token != "" {
+		// hashes of kubeconfigs without a rotation token remain the hash of .spec.csr alone
+		h.Write([]byte{0})
+		h.Write([]byte(token))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// credentialsSpecChanged returns true if credentials requested with the given hash are outdated. Kubeconfigs
+// bringing their own CSR are never rotated, and empty hashes recorded by previous versions of the operator are
+// never outdated
+func credentialsSpecChanged(kubeconfig *kubeconfigv1alpha1.Kubeconfig, hash string) bool {
+	if kubeconfig.Spec.ExistingCSR != nil || hash == "" {
+		return false
+	}
+	current := credentialsSpecHash(kubeconfig)
+	return current != "" && current != hash
+}
+
+// rolesHash returns a hash of the kubeconfig's username, groups, roleRef and bindings, i.e., of the roles that are
+// approved together with the CSR of a client certificate
+func rolesHash(kubeconfig *kubeconfigv1alpha1.Kubeconfig) string {
+	data, err := json.Marshal(struct {
+		Username string                                 `json:"username"`
+		Groups   []string                               `json:"groups"`
+		RoleRef  *rbacv1.RoleRef                        `json:"roleRef"`
+		Bindings []kubeconfigv1alpha1.KubeconfigBinding `json:"bindings"`
+	}{kubeconfig.Spec.Username, kubeconfig.Spec.Groups, kubeconfig.Spec.RoleRef, kubeconfig.Spec.Bindings})
+	if err != nil {
+		// cannot happen for the plain structs, an empty hash is never synced
+		klog.ErrorS(err, "failed to marshal roles for hashing", "name", kubeconfig.Name)
+		return ""
+	}
+	h := sha256.Sum256(data)
+	return hex.EncodeToString(h[:])
+}
+
+// rolesChanged returns true if the roles that a CSR was requested or approved for with the given hash differ from the
+// spec. Empty hashes recorded by previous versions of the operator never differ
+func rolesChanged(kubeconfig *kubeconfigv1alpha1.Kubeconfig, hash string) bool {
+	return hash != "" && rolesHash(kubeconfig) != hash
+}
+
+// reapproveRoles discards the kubeconfig's current CSR and requests a new certificate for the changed roles, such that
+// they pass the approval of CSRs again, including approval policies and the approval quorum. Role bindings are not
+// synced until then, the previous credentials and bindings remain in place
+func (r *KubeconfigReconciler) reapproveRoles(ctx context.Context, kubeconfig *kubeconfigv1alpha1.Kubeconfig) (ctrl.Result, error) {
+	message := "The username or roles changed since the CSR was approved, requesting a new certificate for their approval"
+	klog.V(0).InfoS("Roles of kubeconfig changed, requesting approval", "name", kubeconfig.Name, "generation", kubeconfig.Generation)
+	r.Recorder.Event(kubeconfig, "Normal", "RolesChanged", message)
+	meta.SetStatusCondition(&kubeconfig.Status.Conditions, metav1.Condition{
+		Type:    kubeconfigv1alpha1.ConditionTypeRBACSynced,
+		Status:  metav1.ConditionFalse,
+		Reason:  "AwaitingApproval",
+		Message: "Role bindings are synced once the changed roles are approved",
+	})
+	return r.beginReissue(ctx, kubeconfig, "RolesChanged", message)
+}
+
+// rotateCredentials discards the kubeconfig's current CSR and requests new credentials for the changed .spec.csr or
+// .spec.rotationToken. The previous credentials remain in the user secret until the new certificate is issued
+func (r *KubeconfigReconciler) rotateCredentials(ctx context.Context, kubeconfig *kubeconfigv1alpha1.Kubeconfig) (ctrl.Result, error) {
+	message := "The CSR parameters in .spec.csr or the .spec.rotationToken changed, requesting a new key and certificate"
+	klog.V(0).InfoS("Rotating credentials of kubeconfig", "name", kubeconfig.Name, "generation", kubeconfig.Generation)
+	r.Recorder.Event(kubeconfig, "Normal", "Rotating", message)
+	return r.beginReissue(ctx, kubeconfig, "Rotating", message)
+}
+
+// setCredentialsStatus records the generation, CSR parameters and approved roles of the newly issued credentials in
+// the status
+func setCredentialsStatus(kubeconfig *kubeconfigv1alpha1.Kubeconfig) {
+	kubeconfig.Status.CredentialsGeneration = kubeconfig.Status.Csr.Generation
+	kubeconfig.Status.CredentialsSpecHash = kubeconfig.Status.Csr.SpecHash
+	kubeconfig.Status.ApprovedRolesHash = kubeconfig.Status.Csr.RolesHash
+}
+
+// adoptCSR decides about a CSR of the kubeconfig's current name that is missing from its status, e.g., because the
+// status update after requesting it failed. The CSR is adopted if it was requested by this kubeconfig for its current
+// credential parameters and key material. Stale CSRs of this kubeconfig are deleted, and CSRs of other objects are
+// never touched. It returns true if the CSR was adopted and the reconciliation may continue
+func (r *KubeconfigReconciler) adoptCSR(ctx context.Context, kubeconfig *kubeconfigv1alpha1.Kubeconfig, csr *certificatesv1.CertificateSigningRequest, userSecret *corev1.Secret) (bool, ctrl.Result, error) {
+	if !metav1.IsControlledBy(csr, kubeconfig) || csr.Labels["kubeconfig-operator.k8s.zoomoid.dev/for"] != kubeconfig.Name {
+		// e.g., the CSR of a deleted kubeconfig of the same name that the garbage collector did not remove yet
+		klog.V(0).InfoS("CSR is not managed by kubeconfig, waiting for its removal", "name", kubeconfig.Name, "csr", csr.Name)
+		r.Recorder.Eventf(kubeconfig, "Warning", "CsrConflict", "CSR %s is not managed by the kubeconfig, waiting for its removal", csr.Name)
+		return false, ctrl.Result{RequeueAfter: 1 * time.Minute}, nil
+	}
+
+	// the CSR's name carries the kubeconfig's current generation, so it is only stale if it was requested for other
+	// CSR parameters, rotation token or roles, or for key material that is no longer in the user secret
+	hash, hashed := csr.Annotations[CSRCredentialsSpecHashAnnotationKey]
+	roles := csr.Annotations[CSRRolesHashAnnotationKey]
+	requested := bytes.Equal(csr.Spec.Request, userSecret.Data[CertificateSecretPendingCSRKey]) ||
+		bytes.Equal(csr.Spec.Request, userSecret.Data[CertificateSecretCSRKey])
+	if (hashed && hash != credentialsSpecHash(kubeconfig)) || rolesChanged(kubeconfig, roles) || !requested {
+		klog.V(2).InfoS("Deleting stale CSR of kubeconfig", "name", kubeconfig.Name, "csr", csr.Name)
+		err := r.Delete(ctx, csr, client.Preconditions{UID: &csr.UID})
+		if err != nil && !apierrors.IsNotFound(err) {
+			klog.ErrorS(err, "failed to delete stale CSR", "name", csr.Name)
+			return false, ctrl.Result{}, err
+		}
+		return false, ctrl.Result{RequeueAfter: 5 * time.Second}, nil
+	}
+
+	klog.V(0).InfoS("Adopting CSR of kubeconfig", "name", kubeconfig.Name, "csr", csr.Name)
+	r.Recorder.Eventf(kubeconfig, "Normal", "CsrAdopted", "Adopted CSR %s that was requested for the kubeconfig before", csr.Name)
+	kubeconfig.Status.Csr = kubeconfigv1alpha1.CsrRef{
+		Name:       csr.Name,
+		Generation: kubeconfig.Generation,
+		SpecHash:   credentialsSpecHash(kubeconfig),
+		RolesHash:  rolesHash(kubeconfig),
+	}
+	kubeconfig.Status.Approvers = nil
+	meta.SetStatusCondition(&kubeconfig.Status.Conditions, metav1.Condition{
+		Type:    kubeconfigv1alpha1.ConditionTypeCSRCreated,
+		Reason:  "CsrAdopted",
+		Message: fmt.Sprintf("Adopted CSR %s that was requested for the kubeconfig before", csr.Name),
+		Status:  metav1.ConditionTrue,
+	})
+	if kubeconfig.Status.Status != phases.PhaseRenewing {
+		kubeconfig.Status.Status = phases.PhaseAwaitingApproval
+	}
+	err := r.Status().Update(ctx, kubeconfig)
+	if err != nil {
+		klog.ErrorS(err, "failed to update kubeconfig status", "name", kubeconfig.Name)
+		return false, ctrl.Result{}, err
+	}
+	return true, ctrl.Result{}, nil
+}
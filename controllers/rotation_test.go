@@ -0,0 +1,218 @@
+/*
+Copyright 2022 zoomoid.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	kubeconfigv1alpha1 "github.com/zoomoid/kubeconfig-operator/api/v1alpha1"
+	"github.com/zoomoid/kubeconfig-operator/controllers/phases"
+	certificatesv1 "k8s.io/api/certificates/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+var _ = Describe("Credential rotation", func() {
+	certificate := func() *kubeconfigv1alpha1.Kubeconfig {
+		return newTestKubeconfig("rotation")
+	}
+	token := func() *kubeconfigv1alpha1.Kubeconfig {
+		kubeconfig := newTestKubeconfig("rotation")
+		kubeconfig.Spec.AuthMode = kubeconfigv1alpha1.AuthModeServiceAccountToken
+		kubeconfig.Spec.CSR = nil
+		kubeconfig.Spec.ServiceAccountToken = &kubeconfigv1alpha1.ServiceAccountToken{Duration: &metav1.Duration{Duration: time.Hour}}
+		return kubeconfig
+	}
+
+	table.DescribeTable("detects changes of the credential parameters",
+		func(issued func() *kubeconfigv1alpha1.Kubeconfig, change func(*kubeconfigv1alpha1.Kubeconfig), legacy bool, want bool) {
+			kubeconfig := issued()
+			hash := credentialsSpecHash(kubeconfig)
+			Expect(hash).NotTo(BeEmpty())
+			if legacy {
+				// credentials issued by previous versions of the operator recorded no hash
+				hash = ""
+			}
+			change(kubeconfig)
+			Expect(credentialsSpecChanged(kubeconfig, hash)).To(Equal(want))
+		},
+		table.Entry("unless nothing changed", certificate, func(*kubeconfigv1alpha1.Kubeconfig) {}, false, false),
+		table.Entry("of the signature algorithm", certificate, func(k *kubeconfigv1alpha1.Kubeconfig) {
+			k.Spec.CSR.SignatureAlgorithm = kubeconfigv1alpha1.PureEd25519
+		}, false, true),
+		table.Entry("of the key encoding", certificate, func(k *kubeconfigv1alpha1.Kubeconfig) {
+			k.Spec.CSR.KeyEncoding = kubeconfigv1alpha1.KeyEncodingPKCS8
+		}, false, true),
+		table.Entry("of the rotation token", certificate, func(k *kubeconfigv1alpha1.Kubeconfig) {
+			k.Spec.RotationToken = "2022-06-01"
+		}, false, true),
+		table.Entry("unless an unrelated field changed", certificate, func(k *kubeconfigv1alpha1.Kubeconfig) {
+			k.Spec.Groups = []string{"dev"}
+		}, false, false),
+		table.Entry("unless the kubeconfig brings its own CSR", certificate, func(k *kubeconfigv1alpha1.Kubeconfig) {
+			k.Spec.ExistingCSR = &kubeconfigv1alpha1.SecretRef{Namespace: "default", Name: "csr"}
+			k.Spec.RotationToken = "2022-06-01"
+		}, false, false),
+		table.Entry("unless the hash was recorded by previous versions", certificate, func(k *kubeconfigv1alpha1.Kubeconfig) {
+			k.Spec.RotationToken = "2022-06-01"
+		}, true, false),
+		table.Entry("of the token duration", token, func(k *kubeconfigv1alpha1.Kubeconfig) {
+			k.Spec.ServiceAccountToken.Duration = &metav1.Duration{Duration: 2 * time.Hour}
+		}, false, true),
+		table.Entry("unless CSR parameters changed in the ServiceAccountToken auth mode", token, func(k *kubeconfigv1alpha1.Kubeconfig) {
+			k.Spec.CSR = &kubeconfigv1alpha1.CertificateSigningRequest{SignatureAlgorithm: kubeconfigv1alpha1.PureEd25519}
+		}, false, false),
+	)
+
+	It("keeps the previous credentials until the rotated ones are issued", func() {
+		r := newTestReconciler()
+		kubeconfig := newTestKubeconfig("rotation")
+		Expect(k8sClient.Create(ctx, kubeconfig)).To(Succeed())
+		now := time.Now()
+		kubeconfig = reconcileIssued(r, kubeconfig, now, now.Add(24*time.Hour))
+		issued := getUserSecret(kubeconfig)
+		Expect(kubeconfig.Status.CredentialsGeneration).To(Equal(kubeconfig.Generation))
+
+		By("changing the signature algorithm")
+		kubeconfig.Spec.CSR.SignatureAlgorithm = kubeconfigv1alpha1.PureEd25519
+		Expect(k8sClient.Update(ctx, kubeconfig)).To(Succeed())
+		_, err := reconcileKubeconfig(r, kubeconfig)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(getKubeconfig(kubeconfig).Status.Status).To(Equal(phases.PhaseRenewing))
+		_, err = reconcileKubeconfig(r, kubeconfig)
+		Expect(err).NotTo(HaveOccurred())
+		rotating := getKubeconfig(kubeconfig)
+		Expect(rotating.Status.Csr.Generation).To(Equal(kubeconfig.Generation))
+		Expect(rotating.Status.CredentialsGeneration).To(BeNumerically("<", kubeconfig.Generation))
+		staged := getUserSecret(kubeconfig)
+		Expect(staged.Data[CertificateSecretCertKey]).To(Equal(issued.Data[CertificateSecretCertKey]))
+		Expect(staged.Data[CertificateSecretPrivKeyKey]).To(Equal(issued.Data[CertificateSecretPrivKeyKey]))
+		Expect(staged.Data[CertificateSecretPendingPrivKeyKey]).NotTo(BeEmpty())
+
+		By("promoting the new key once its certificate is issued")
+		clusterCA.issueCSR(rotating.Status.Csr.Name, now, now.Add(24*time.Hour))
+		_, err = reconcileKubeconfig(r, kubeconfig)
+		Expect(err).NotTo(HaveOccurred())
+		rotated := getKubeconfig(kubeconfig)
+		Expect(isFinished(rotated)).To(BeTrue())
+		Expect(rotated.Status.CredentialsGeneration).To(Equal(kubeconfig.Generation))
+		Expect(rotated.Status.CredentialsSpecHash).To(Equal(credentialsSpecHash(kubeconfig)))
+		secret := getUserSecret(kubeconfig)
+		Expect(secret.Data[CertificateSecretPrivKeyKey]).To(Equal(staged.Data[CertificateSecretPendingPrivKeyKey]))
+		Expect(secret.Data).NotTo(HaveKey(CertificateSecretPendingPrivKeyKey))
+		Expect(secret.Data[CertificateSecretCertKey]).NotTo(Equal(issued.Data[CertificateSecretCertKey]))
+	})
+})
+
+var _ = Describe("CSRs missing from the status", func() {
+	var r *KubeconfigReconciler
+	var kubeconfig *kubeconfigv1alpha1.Kubeconfig
+
+	BeforeEach(func() {
+		r = newTestReconciler()
+		kubeconfig = newTestKubeconfig("leftover")
+		Expect(k8sClient.Create(ctx, kubeconfig)).To(Succeed())
+	})
+
+	// getCSR returns the CSR with the given name, or nil if it does not exist
+	getCSR := func(name string) *certificatesv1.CertificateSigningRequest {
+		csr := &certificatesv1.CertificateSigningRequest{}
+		err := k8sClient.Get(ctx, types.NamespacedName{Name: name}, csr)
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		Expect(err).NotTo(HaveOccurred())
+		return csr
+	}
+
+	It("adopts the CSR requested for the current credentials", func() {
+		_, err := reconcileKubeconfig(r, kubeconfig)
+		Expect(err).NotTo(HaveOccurred())
+		requested := getKubeconfig(kubeconfig)
+		csr := getCSR(requested.Status.Csr.Name)
+		Expect(csr).NotTo(BeNil())
+
+		By("losing the CSR's name from the status")
+		requested.Status.Csr = kubeconfigv1alpha1.CsrRef{}
+		Expect(k8sClient.Status().Update(ctx, requested)).To(Succeed())
+		_, err = reconcileKubeconfig(r, kubeconfig)
+		Expect(err).NotTo(HaveOccurred())
+		adopted := getKubeconfig(kubeconfig)
+		Expect(adopted.Status.Csr.Name).To(Equal(csr.Name))
+		Expect(adopted.Status.Csr.SpecHash).To(Equal(credentialsSpecHash(adopted)))
+		Expect(adopted.Status.Status).To(Equal(phases.PhaseAwaitingApproval))
+		created := meta.FindStatusCondition(adopted.Status.Conditions, kubeconfigv1alpha1.ConditionTypeCSRCreated)
+		Expect(created).NotTo(BeNil())
+		Expect(created.Reason).To(Equal("CsrAdopted"))
+		Expect(getCSR(csr.Name).UID).To(Equal(csr.UID))
+
+		By("issuing the adopted CSR's certificate")
+		now := time.Now()
+		clusterCA.issueCSR(csr.Name, now, now.Add(24*time.Hour))
+		_, err = reconcileKubeconfig(r, kubeconfig)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(isFinished(getKubeconfig(kubeconfig))).To(BeTrue())
+	})
+
+	It("deletes CSRs requested for a previous rotation token", func() {
+		previous := kubeconfig.DeepCopy()
+		previous.Spec.RotationToken = "previous"
+		csr := createTestCSR(previous, previous)
+		Expect(csr.Name).To(Equal(csrNameFor(kubeconfig)))
+
+		result, err := reconcileKubeconfig(r, kubeconfig)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.RequeueAfter).To(Equal(5 * time.Second))
+		Expect(getCSR(csr.Name)).To(BeNil())
+
+		_, err = reconcileKubeconfig(r, kubeconfig)
+		Expect(err).NotTo(HaveOccurred())
+		fresh := getCSR(getKubeconfig(kubeconfig).Status.Csr.Name)
+		Expect(fresh).NotTo(BeNil())
+		Expect(fresh.UID).NotTo(Equal(csr.UID))
+		Expect(fresh.Annotations).To(HaveKeyWithValue(CSRCredentialsSpecHashAnnotationKey, credentialsSpecHash(kubeconfig)))
+	})
+
+	It("deletes CSRs whose key is not in the user secret", func() {
+		csr := createTestCSR(kubeconfig, kubeconfig)
+
+		result, err := reconcileKubeconfig(r, kubeconfig)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.RequeueAfter).To(Equal(5 * time.Second))
+		Expect(getCSR(csr.Name)).To(BeNil())
+	})
+
+	It("leaves CSRs alone that are not managed by the kubeconfig", func() {
+		material, err := r.createCSR(ctx, kubeconfig)
+		Expect(err).NotTo(HaveOccurred())
+		unmanaged := r.createCsr(kubeconfig, material.CSR)
+		unmanaged.OwnerReferences = nil
+		Expect(k8sClient.Create(ctx, unmanaged)).To(Succeed())
+
+		result, err := reconcileKubeconfig(r, kubeconfig)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.RequeueAfter).To(Equal(time.Minute))
+		Expect(getCSR(unmanaged.Name).UID).To(Equal(unmanaged.UID))
+		Expect(getKubeconfig(kubeconfig).Status.Csr.Name).To(BeEmpty())
+	})
+})
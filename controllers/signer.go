@@ -0,0 +1,277 @@
+/*
+Copyright 2022 zoomoid.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	certificatesv1 "k8s.io/api/certificates/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// OperatorSignerName is the suggested signer name for the operator's built-in signer. The kube-apiserver needs to
+// trust the signer's CA for client authentication, e.g., by adding it to its --client-ca-file bundle
+const OperatorSignerName = "kubeconfig.k8s.zoomoid.dev/client"
+
+// signerClockSkew backdates the certificates issued by the built-in signer, like the kube-controller-manager's signers do
+const signerClockSkew = 5 * time.Minute
+
+// CertificateSigningRequestSigner signs approved CSRs of kubeconfigs that request the operator's signer name with
+// the CA key pair from a secret, for clusters that do not sign kube-apiserver-client CSRs themselves
+type CertificateSigningRequestSigner struct {
+	client.Client
+	Scheme    *runtime.Scheme
+	Recorder  record.EventRecorder
+	ClientSet clientset.Interface
+	// SignerName is the signer name of the CSRs that are signed by the operator
+	SignerName string
+	// CASecret references the kubernetes.io/tls secret that holds the signer's CA certificate and private key
+	CASecret types.NamespacedName
+	// DefaultDuration is the lifetime of certificates for CSRs that do not request one in expirationSeconds
+	DefaultDuration time.Duration
+}
+
+//+kubebuilder:rbac:groups=certificates.k8s.io,resources=certificatesigningrequests/status,verbs=update
+//+kubebuilder:rbac:groups=certificates.k8s.io,resources=signers,resourceNames="kubeconfig.k8s.zoomoid.dev/client",verbs=sign
+//+kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch
+//+kubebuilder:rbac:groups=kubeconfig.k8s.zoomoid.dev,resources=kubeconfigs,verbs=get;list;watch
+
+// Reconcile issues a certificate for approved CSRs of kubeconfigs that request the signer's name and were
+// not signed yet. CSRs whose owner reference does not resolve to an existing kubeconfig are failed, CSRs of revoked
+// kubeconfigs are never signed
+func (s *CertificateSigningRequestSigner) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	csr := &certificatesv1.CertificateSigningRequest{}
+	err := s.Get(ctx, req.NamespacedName, csr)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+
+	if csr.Spec.SignerName != s.SignerName || !isKubeconfigV1Alpha1CSR(csr) || len(csr.Status.Certificate) > 0 {
+		return ctrl.Result{}, nil
+	}
+	approved, denied, failed := getCertApprovalCondition(csr.Status.Conditions)
+	if !approved || denied || failed {
+		return ctrl.Result{}, nil
+	}
+
+	// anyone allowed to create CSRs can claim to be owned by a kubeconfig
+	kubeconfig, err := ownerKubeconfig(ctx, s.Client, csr)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if kubeconfig == nil {
+		return ctrl.Result{}, s.failCSR(ctx, csr, "OwnerNotFound", "The CSR is not owned by an existing kubeconfig")
+	}
+	if kubeconfig.Spec.Revoked {
+		klog.V(2).InfoS("CSR belongs to a revoked kubeconfig, skipping signing", "name", csr.Name, "kubeconfig", kubeconfig.Name)
+		return ctrl.Result{}, nil
+	}
+
+	ca, caKey, err := s.loadCA(ctx)
+	if err != nil {
+		klog.ErrorS(err, "failed to load signer CA", "namespace", s.CASecret.Namespace, "name", s.CASecret.Name)
+		s.Recorder.Eventf(csr, "Warning", "SignerUnavailable", "Failed to load signer CA from secret %s, %v", s.CASecret, err)
+		return ctrl.Result{}, err
+	}
+
+	request, err := parseCSR(csr.Spec.Request)
+	if err == nil {
+		err = request.CheckSignature()
+	}
+	if err != nil {
+		return ctrl.Result{}, s.failCSR(ctx, csr, "InvalidRequest", fmt.Sprintf("Failed to parse x509 CSR from request field, %v", err))
+	}
+
+	duration := s.DefaultDuration
+	if csr.Spec.ExpirationSeconds != nil {
+		duration = time.Duration(*csr.Spec.ExpirationSeconds) * time.Second
+	}
+	keyUsage, extKeyUsages, err := keyUsagesFor(csr.Spec.Usages)
+	if err != nil {
+		return ctrl.Result{}, s.failCSR(ctx, csr, "UnsupportedUsages", fmt.Sprintf("Failed to sign certificate, %v", err))
+	}
+	cert, err := signCertificate(ca, caKey, request, keyUsage, extKeyUsages, duration, time.Now())
+	if err != nil {
+		return ctrl.Result{}, s.failCSR(ctx, csr, "SigningFailed", fmt.Sprintf("Failed to sign certificate, %v", err))
+	}
+
+	csr.Status.Certificate = cert
+	_, err = s.ClientSet.CertificatesV1().CertificateSigningRequests().UpdateStatus(ctx, csr, metav1.UpdateOptions{})
+	if err != nil {
+		klog.ErrorS(err, "failed to write certificate to CSR status", "name", csr.Name)
+		return ctrl.Result{}, err
+	}
+	klog.V(0).InfoS("Signed CSR", "name", csr.Name, "signer", s.SignerName)
+	s.Recorder.Eventf(csr, "Normal", "Signed", "Signed certificate with the CA from secret %s", s.CASecret)
+	return ctrl.Result{}, nil
+}
+
+// failCSR marks the CSR as failed, such that its kubeconfig stops waiting for the certificate
+func (s *CertificateSigningRequestSigner) failCSR(ctx context.Context, csr *certificatesv1.CertificateSigningRequest, reason string, message string) error {
+	setStatusCondition(&csr.Status.Conditions, certificatesv1.CertificateSigningRequestCondition{
+		Type:    certificatesv1.CertificateFailed,
+		Status:  corev1.ConditionTrue,
+		Reason:  reason,
+		Message: message,
+	})
+	_, err := s.ClientSet.CertificatesV1().CertificateSigningRequests().UpdateStatus(ctx, csr, metav1.UpdateOptions{})
+	if err != nil {
+		klog.ErrorS(err, "failed to mark CSR as failed", "name", csr.Name)
+		return err
+	}
+	klog.InfoS("Failed CSR", "name", csr.Name, "reason", reason)
+	s.Recorder.Event(csr, "Warning", reason, message)
+	return nil
+}
+
+// loadCA reads the signer's CA certificate and private key from the CA secret
+func (s *CertificateSigningRequestSigner) loadCA(ctx context.Context) (*x509.Certificate, crypto.Signer, error) {
+	secret := &corev1.Secret{}
+	err := s.Get(ctx, s.CASecret, secret)
+	if err != nil {
+		return nil, nil, err
+	}
+	ca, err := parseCertificate(secret.Data[corev1.TLSCertKey])
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse CA certificate, %w", err)
+	}
+	if !ca.IsCA {
+		return nil, nil, errors.New("certificate is not a CA")
+	}
+	key, err := parsePrivateKey(secret.Data[corev1.TLSPrivateKeyKey])
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse CA private key, %w", err)
+	}
+	return ca, key, nil
+}
+
+// parsePrivateKey unwraps a PEM-encoded PKCS#1, SEC 1 or PKCS#8 private key
+func parsePrivateKey(pemBytes []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("unsupported private key type %T", key)
+		}
+		return signer, nil
+	default:
+		return nil, fmt.Errorf("unsupported PEM block type %s", block.Type)
+	}
+}
+
+// keyUsages and extKeyUsages map the CSR usages that the built-in signer issues to their X.509 counterparts
+var (
+	keyUsages = map[certificatesv1.KeyUsage]x509.KeyUsage{
+		certificatesv1.UsageDigitalSignature: x509.KeyUsageDigitalSignature,
+		certificatesv1.UsageKeyEncipherment:  x509.KeyUsageKeyEncipherment,
+		certificatesv1.UsageKeyAgreement:     x509.KeyUsageKeyAgreement,
+		certificatesv1.UsageSigning:          x509.KeyUsageDigitalSignature,
+	}
+	extKeyUsages = map[certificatesv1.KeyUsage]x509.ExtKeyUsage{
+		certificatesv1.UsageClientAuth: x509.ExtKeyUsageClientAuth,
+	}
+)
+
+// keyUsagesFor converts the usages of a CSR to the key usage and extended key usages of the certificate. The
+// built-in signer only issues client certificates, so the usages must include client auth
+func keyUsagesFor(usages []certificatesv1.KeyUsage) (x509.KeyUsage, []x509.ExtKeyUsage, error) {
+	var keyUsage x509.KeyUsage
+	var ext []x509.ExtKeyUsage
+	for _, usage := range usages {
+		if u, ok := keyUsages[usage]; ok {
+			keyUsage |= u
+		} else if u, ok := extKeyUsages[usage]; ok {
+			ext = append(ext, u)
+		} else {
+			return 0, nil, fmt.Errorf("usage %q is not supported for client certificates", usage)
+		}
+	}
+	if len(ext) == 0 {
+		return 0, nil, fmt.Errorf("usages must include %q", certificatesv1.UsageClientAuth)
+	}
+	return keyUsage, ext, nil
+}
+
+// signCertificate issues a client certificate for the request's subject and public key, valid for the given duration
+// but never beyond the CA's own validity. Digital signatures, and key encipherment for RSA keys, are always allowed
+func signCertificate(ca *x509.Certificate, caKey crypto.Signer, request *x509.CertificateRequest, keyUsage x509.KeyUsage, extKeyUsages []x509.ExtKeyUsage, duration time.Duration, now time.Time) ([]byte, error) {
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	notAfter := now.Add(duration)
+	if notAfter.After(ca.NotAfter) {
+		notAfter = ca.NotAfter
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serialNumber,
+		Subject:               request.Subject,
+		NotBefore:             now.Add(-signerClockSkew),
+		NotAfter:              notAfter,
+		KeyUsage:              keyUsage | x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           extKeyUsages,
+		BasicConstraintsValid: true,
+	}
+	if _, ok := request.PublicKey.(*rsa.PublicKey); ok {
+		template.KeyUsage |= x509.KeyUsageKeyEncipherment
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, request.PublicKey, caKey)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: x509TypeCertificate, Bytes: der}), nil
+}
+
+func (s *CertificateSigningRequestSigner) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("certificatesigningrequest-signer").
+		For(&certificatesv1.CertificateSigningRequest{}).
+		Complete(s)
+}
@@ -0,0 +1,273 @@
+/*
+Copyright 2022 zoomoid.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	kubeconfigv1alpha1 "github.com/zoomoid/kubeconfig-operator/api/v1alpha1"
+	certificatesv1 "k8s.io/api/certificates/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var _ = Describe("Built-in signer", func() {
+	var s *CertificateSigningRequestSigner
+	var ca *testCA
+
+	// useCA stores the certificate and private key in the signer's CA secret
+	useCA := func(certPEM []byte, keyPEM []byte) {
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: operatorNamespace, Name: uniqueName("signer-ca")},
+			Type:       corev1.SecretTypeTLS,
+			Data:       map[string][]byte{corev1.TLSCertKey: certPEM, corev1.TLSPrivateKeyKey: keyPEM},
+		}
+		Expect(k8sClient.Create(ctx, secret)).To(Succeed())
+		s.CASecret = types.NamespacedName{Namespace: secret.Namespace, Name: secret.Name}
+	}
+
+	BeforeEach(func() {
+		s = &CertificateSigningRequestSigner{
+			Client:          k8sClient,
+			Scheme:          scheme.Scheme,
+			Recorder:        record.NewFakeRecorder(1024),
+			ClientSet:       clientSet,
+			SignerName:      OperatorSignerName,
+			DefaultDuration: 24 * time.Hour,
+		}
+		ca = newTestCA(time.Now().Add(365 * 24 * time.Hour))
+		useCA(ca.certPEM, ca.keyPEM)
+	})
+
+	// newSignerKubeconfig returns a kubeconfig whose CSRs request the built-in signer
+	newSignerKubeconfig := func() *kubeconfigv1alpha1.Kubeconfig {
+		kubeconfig := newTestKubeconfig("signer")
+		kubeconfig.Spec.CSR.SignerName = OperatorSignerName
+		return kubeconfig
+	}
+
+	// sign runs a single reconciliation of the CSR and returns it as stored afterwards
+	sign := func(csr *certificatesv1.CertificateSigningRequest) (*certificatesv1.CertificateSigningRequest, error) {
+		_, err := s.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(csr)})
+		current, getErr := clientSet.CertificatesV1().CertificateSigningRequests().Get(ctx, csr.Name, metav1.GetOptions{})
+		Expect(getErr).NotTo(HaveOccurred())
+		return current, err
+	}
+
+	// signedCertificate signs the approved CSR of the kubeconfig and returns the issued certificate
+	signedCertificate := func(kubeconfig *kubeconfigv1alpha1.Kubeconfig) *x509.Certificate {
+		createTestKubeconfig(kubeconfig)
+		csr := createTestCSR(kubeconfig, kubeconfig)
+		approveTestCSR(csr.Name)
+		signed, err := sign(csr)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(signed.Status.Certificate).NotTo(BeEmpty())
+		cert, err := parseCertificate(signed.Status.Certificate)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cert.CheckSignatureFrom(ca.cert)).To(Succeed())
+		return cert
+	}
+
+	// expectFailed asserts that the CSR was marked as failed for the reason without issuing a certificate
+	expectFailed := func(csr *certificatesv1.CertificateSigningRequest, reason string) {
+		Expect(csr.Status.Certificate).To(BeEmpty())
+		var failed *certificatesv1.CertificateSigningRequestCondition
+		for i := range csr.Status.Conditions {
+			if csr.Status.Conditions[i].Type == certificatesv1.CertificateFailed {
+				failed = &csr.Status.Conditions[i]
+			}
+		}
+		Expect(failed).NotTo(BeNil())
+		Expect(failed.Status).To(Equal(corev1.ConditionTrue))
+		Expect(failed.Reason).To(Equal(reason))
+	}
+
+	It("issues client certificates for the requested lifetime", func() {
+		kubeconfig := newSignerKubeconfig()
+		kubeconfig.Spec.CSR.Duration = &metav1.Duration{Duration: 2 * time.Hour}
+		now := time.Now()
+		cert := signedCertificate(kubeconfig)
+		Expect(cert.Subject.CommonName).To(Equal(kubeconfig.Spec.Username))
+		Expect(cert.ExtKeyUsage).To(Equal([]x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}))
+		Expect(cert.KeyUsage).To(Equal(x509.KeyUsageDigitalSignature))
+		Expect(cert.IsCA).To(BeFalse())
+		Expect(cert.NotBefore).To(BeTemporally("~", now.Add(-signerClockSkew), time.Minute))
+		Expect(cert.NotAfter).To(BeTemporally("~", now.Add(2*time.Hour), time.Minute))
+
+		By("falling back to the default lifetime")
+		cert = signedCertificate(newSignerKubeconfig())
+		Expect(cert.NotAfter).To(BeTemporally("~", time.Now().Add(s.DefaultDuration), time.Minute))
+	})
+
+	It("never issues certificates beyond the CA's validity", func() {
+		ca = newTestCA(time.Now().Add(2 * time.Hour))
+		useCA(ca.certPEM, ca.keyPEM)
+		kubeconfig := newSignerKubeconfig()
+		kubeconfig.Spec.CSR.Duration = &metav1.Duration{Duration: 24 * time.Hour}
+		cert := signedCertificate(kubeconfig)
+		Expect(cert.NotAfter).To(BeTemporally("==", ca.cert.NotAfter))
+	})
+
+	It("allows key encipherment for RSA keys", func() {
+		kubeconfig := newSignerKubeconfig()
+		kubeconfig.Spec.CSR.SignatureAlgorithm = kubeconfigv1alpha1.SHA256WithRSA
+		kubeconfig.Spec.CSR.KeySize = 2048
+		cert := signedCertificate(kubeconfig)
+		Expect(cert.KeyUsage).To(Equal(x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment))
+	})
+
+	table.DescribeTable("fails CSRs it cannot sign",
+		func(usages []certificatesv1.KeyUsage, corrupt bool, reason string) {
+			kubeconfig := newSignerKubeconfig()
+			kubeconfig.Spec.CSR.Usages = usages
+			createTestKubeconfig(kubeconfig)
+			r := newTestReconciler()
+			material, err := r.createCSR(ctx, kubeconfig)
+			Expect(err).NotTo(HaveOccurred())
+			request := material.CSR
+			if corrupt {
+				// the API server parses requests, but does not check their signature
+				block, _ := pem.Decode(request)
+				block.Bytes[len(block.Bytes)-1] ^= 0xff
+				request = pem.EncodeToMemory(block)
+			}
+			csr := r.createCsr(kubeconfig, request)
+			Expect(k8sClient.Create(ctx, csr)).To(Succeed())
+			approveTestCSR(csr.Name)
+
+			failed, err := sign(csr)
+			Expect(err).NotTo(HaveOccurred())
+			expectFailed(failed, reason)
+		},
+		table.Entry("with an invalid signature", nil, true, "InvalidRequest"),
+		table.Entry("without client auth", []certificatesv1.KeyUsage{certificatesv1.UsageDigitalSignature, certificatesv1.UsageKeyEncipherment}, false, "UnsupportedUsages"),
+		table.Entry("with usages other than for client certificates", []certificatesv1.KeyUsage{certificatesv1.UsageClientAuth, certificatesv1.UsageServerAuth}, false, "UnsupportedUsages"),
+	)
+
+	It("refuses to sign with a certificate that is not a CA", func() {
+		kubeconfig := newSignerKubeconfig()
+		createTestKubeconfig(kubeconfig)
+		csr := createTestCSR(kubeconfig, kubeconfig)
+		useCA(ca.issue(csr.Spec.Request, time.Now(), time.Now().Add(time.Hour)), ca.keyPEM)
+		approveTestCSR(csr.Name)
+
+		current, err := sign(csr)
+		Expect(err).To(HaveOccurred())
+		Expect(current.Status.Certificate).To(BeEmpty())
+		// a misconfigured signer is retried instead of failing the CSR
+		Expect(current.Status.Conditions).To(HaveLen(1))
+		Expect(current.Status.Conditions[0].Type).To(Equal(certificatesv1.CertificateApproved))
+	})
+
+	table.DescribeTable("leaves CSRs alone that must not be signed",
+		func(prepare func(csr *certificatesv1.CertificateSigningRequest)) {
+			kubeconfig := newSignerKubeconfig()
+			createTestKubeconfig(kubeconfig)
+			csr := createTestCSR(kubeconfig, kubeconfig)
+			prepare(csr)
+			previous, err := clientSet.CertificatesV1().CertificateSigningRequests().Get(ctx, csr.Name, metav1.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+
+			current, err := sign(csr)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(current.Status).To(Equal(previous.Status))
+			Expect(current.ResourceVersion).To(Equal(previous.ResourceVersion))
+		},
+		table.Entry("pending CSRs", func(csr *certificatesv1.CertificateSigningRequest) {}),
+		table.Entry("denied CSRs", func(csr *certificatesv1.CertificateSigningRequest) {
+			csr.Status.Conditions = append(csr.Status.Conditions, certificatesv1.CertificateSigningRequestCondition{
+				Type:    certificatesv1.CertificateDenied,
+				Status:  corev1.ConditionTrue,
+				Reason:  "TestDenied",
+				Message: "Denied by the test suite",
+			})
+			_, err := clientSet.CertificatesV1().CertificateSigningRequests().UpdateApproval(ctx, csr.Name, csr, metav1.UpdateOptions{})
+			Expect(err).NotTo(HaveOccurred())
+		}),
+		table.Entry("failed CSRs", func(csr *certificatesv1.CertificateSigningRequest) {
+			approved := approveTestCSR(csr.Name)
+			approved.Status.Conditions = append(approved.Status.Conditions, certificatesv1.CertificateSigningRequestCondition{
+				Type:    certificatesv1.CertificateFailed,
+				Status:  corev1.ConditionTrue,
+				Reason:  "TestFailed",
+				Message: "Failed by the test suite",
+			})
+			_, err := clientSet.CertificatesV1().CertificateSigningRequests().UpdateStatus(ctx, approved, metav1.UpdateOptions{})
+			Expect(err).NotTo(HaveOccurred())
+		}),
+		table.Entry("CSRs that already have a certificate", func(csr *certificatesv1.CertificateSigningRequest) {
+			clusterCA.issueCSR(csr.Name, time.Now(), time.Now().Add(time.Hour))
+		}),
+	)
+
+	table.DescribeTable("fails CSRs that are not owned by an existing kubeconfig",
+		func(prepare func(kubeconfig *kubeconfigv1alpha1.Kubeconfig)) {
+			kubeconfig := newSignerKubeconfig()
+			prepare(kubeconfig)
+			csr := createTestCSR(kubeconfig, kubeconfig)
+			approveTestCSR(csr.Name)
+
+			failed, err := sign(csr)
+			Expect(err).NotTo(HaveOccurred())
+			expectFailed(failed, "OwnerNotFound")
+		},
+		table.Entry("of a kubeconfig that does not exist", func(kubeconfig *kubeconfigv1alpha1.Kubeconfig) {
+			kubeconfig.UID = types.UID("made-up")
+		}),
+		table.Entry("of a kubeconfig with a different UID", func(kubeconfig *kubeconfigv1alpha1.Kubeconfig) {
+			createTestKubeconfig(kubeconfig)
+			kubeconfig.UID = types.UID("made-up")
+		}),
+	)
+
+	It("never signs CSRs of revoked kubeconfigs", func() {
+		kubeconfig := newSignerKubeconfig()
+		kubeconfig.Spec.Revoked = true
+		createTestKubeconfig(kubeconfig)
+		csr := createTestCSR(kubeconfig, kubeconfig)
+		approveTestCSR(csr.Name)
+
+		current, err := sign(csr)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(current.Status.Certificate).To(BeEmpty())
+		Expect(current.Status.Conditions).To(HaveLen(1))
+		Expect(current.Status.Conditions[0].Type).To(Equal(certificatesv1.CertificateApproved))
+	})
+
+	It("leaves CSRs for other signers alone", func() {
+		kubeconfig := newTestKubeconfig("signer")
+		createTestKubeconfig(kubeconfig)
+		csr := createTestCSR(kubeconfig, kubeconfig)
+		Expect(csr.Spec.SignerName).To(Equal(certificatesv1.KubeAPIServerClientSignerName))
+		approveTestCSR(csr.Name)
+
+		current, err := sign(csr)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(current.Status.Certificate).To(BeEmpty())
+	})
+})
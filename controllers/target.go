@@ -0,0 +1,144 @@
+/*
+Copyright 2022 zoomoid.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	kubeconfigv1alpha1 "github.com/zoomoid/kubeconfig-operator/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// reconcileTarget delivers the kubeconfig to the secret configured in the kubeconfig's .spec.target and removes
+// target secrets that are no longer referenced by the spec. The target secret is owned by the kubeconfig, such that
+// it is garbage-collected alongside it
+func (r *KubeconfigReconciler) reconcileTarget(ctx context.Context, kubeconfig *kubeconfigv1alpha1.Kubeconfig, cfg []byte) error {
+	target := kubeconfig.Spec.Target
+	previous := kubeconfig.Status.TargetSecret
+
+	var desired *kubeconfigv1alpha1.SecretRef
+	if target != nil {
+		desired = &kubeconfigv1alpha1.SecretRef{
+			Namespace: target.Namespace,
+			Name:      target.SecretName,
+		}
+	}
+
+	if previous != nil && (desired == nil || *previous != *desired) {
+		err := r.deleteTargetSecret(ctx, kubeconfig, *previous)
+		if err != nil {
+			return err
+		}
+		kubeconfig.Status.TargetSecret = nil
+		err = r.Status().Update(ctx, kubeconfig)
+		if err != nil {
+			return err
+		}
+	}
+
+	if desired == nil {
+		return nil
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: desired.Namespace,
+			Name:      desired.Name,
+		},
+	}
+	result, err := controllerutil.CreateOrUpdate(ctx, r.Client, secret, func() error {
+		if !secret.CreationTimestamp.IsZero() && !metav1.IsControlledBy(secret, kubeconfig) {
+			// never take over secrets that were not created for this kubeconfig
+			return fmt.Errorf("secret %s/%s already exists and is not managed by kubeconfig %s", secret.Namespace, secret.Name, kubeconfig.Name)
+		}
+		r.mutateTargetSecret(kubeconfig, secret, cfg)
+		return controllerutil.SetControllerReference(kubeconfig, secret, r.Scheme)
+	})
+	if err != nil {
+		klog.ErrorS(err, "failed to deliver kubeconfig to target secret", "namespace", desired.Namespace, "name", desired.Name)
+		r.Recorder.Eventf(kubeconfig, "Warning", "TargetSecretFailed", "Failed to deliver kubeconfig to target secret %s/%s, %v", desired.Namespace, desired.Name, err)
+		return err
+	}
+	if result != controllerutil.OperationResultNone {
+		klog.V(2).InfoS("Delivered kubeconfig to target secret", "namespace", desired.Namespace, "name", desired.Name, "operation", result)
+		r.Recorder.Eventf(kubeconfig, "Normal", "TargetSecretSynced", "Delivered kubeconfig to target secret %s/%s", desired.Namespace, desired.Name)
+	}
+
+	if kubeconfig.Status.TargetSecret == nil {
+		kubeconfig.Status.TargetSecret = desired
+		return r.Status().Update(ctx, kubeconfig)
+	}
+	return nil
+}
+
+// mutateTargetSecret sets the desired labels, annotations and data on the target secret. Labels and annotations
+// that were added to the secret by others are preserved
+func (r *KubeconfigReconciler) mutateTargetSecret(kubeconfig *kubeconfigv1alpha1.Kubeconfig, secret *corev1.Secret, cfg []byte) {
+	target := kubeconfig.Spec.Target
+	if secret.Labels == nil {
+		secret.Labels = map[string]string{}
+	}
+	for k, v := range target.Labels {
+		secret.Labels[k] = v
+	}
+	for k, v := range labelsForSubresources(kubeconfig) {
+		secret.Labels[k] = v
+	}
+	if len(target.Annotations) > 0 && secret.Annotations == nil {
+		secret.Annotations = map[string]string{}
+	}
+	for k, v := range target.Annotations {
+		secret.Annotations[k] = v
+	}
+	secret.Type = corev1.SecretTypeOpaque
+	secret.Data = map[string][]byte{
+		target.Key: cfg,
+	}
+}
+
+// deleteTargetSecret removes a target secret previously created for the kubeconfig. Secrets that are not controlled
+// by the kubeconfig, e.g., because someone else replaced the secret in the meantime, are left in place
+func (r *KubeconfigReconciler) deleteTargetSecret(ctx context.Context, kubeconfig *kubeconfigv1alpha1.Kubeconfig, ref kubeconfigv1alpha1.SecretRef) error {
+	secret := &corev1.Secret{}
+	err := r.Get(ctx, types.NamespacedName{Namespace: ref.Namespace, Name: ref.Name}, secret)
+	if apierrors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		klog.ErrorS(err, "failed to get target secret", "namespace", ref.Namespace, "name", ref.Name)
+		return err
+	}
+	if !metav1.IsControlledBy(secret, kubeconfig) {
+		klog.V(0).InfoS("Target secret is not managed by kubeconfig, leaving it in place", "name", kubeconfig.Name, "namespace", ref.Namespace, "secret", ref.Name)
+		return nil
+	}
+	uid := secret.GetUID()
+	err = r.Delete(ctx, secret, client.Preconditions{UID: &uid})
+	if err != nil && !apierrors.IsNotFound(err) {
+		klog.ErrorS(err, "failed to delete target secret", "namespace", ref.Namespace, "name", ref.Name)
+		return err
+	}
+	klog.V(2).InfoS("Deleted target secret", "namespace", ref.Namespace, "name", ref.Name)
+	r.Recorder.Eventf(kubeconfig, "Normal", "TargetSecretDeleted", "Deleted target secret %s/%s", ref.Namespace, ref.Name)
+	return nil
+}
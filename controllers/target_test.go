@@ -0,0 +1,98 @@
+/*
+Copyright 2022 zoomoid.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	kubeconfigv1alpha1 "github.com/zoomoid/kubeconfig-operator/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+var _ = Describe("Target secret", func() {
+	var r *KubeconfigReconciler
+	var namespace string
+
+	BeforeEach(func() {
+		r = newTestReconciler()
+		namespace = newTestNamespace("target")
+	})
+
+	It("delivers the kubeconfig and follows changes of the target", func() {
+		kubeconfig := newTestKubeconfig("target")
+		kubeconfig.Spec.Target = &kubeconfigv1alpha1.KubeconfigTarget{
+			Namespace:   namespace,
+			SecretName:  "kubeconfig",
+			Key:         "config",
+			Labels:      map[string]string{"app": "ci"},
+			Annotations: map[string]string{"reflector": "true"},
+		}
+		createTestKubeconfig(kubeconfig)
+		first := types.NamespacedName{Namespace: namespace, Name: "kubeconfig"}
+
+		Expect(r.reconcileTarget(ctx, kubeconfig, []byte("first"))).To(Succeed())
+		secret := &corev1.Secret{}
+		Expect(k8sClient.Get(ctx, first, secret)).To(Succeed())
+		Expect(secret.Data).To(HaveKeyWithValue("config", []byte("first")))
+		Expect(secret.Labels).To(HaveKeyWithValue("app", "ci"))
+		Expect(secret.Annotations).To(HaveKeyWithValue("reflector", "true"))
+		Expect(metav1.IsControlledBy(secret, kubeconfig)).To(BeTrue())
+		Expect(getKubeconfig(kubeconfig).Status.TargetSecret).To(Equal(&kubeconfigv1alpha1.SecretRef{Namespace: namespace, Name: "kubeconfig"}))
+
+		By("preserving labels added by others")
+		secret.Labels["owner"] = "someone-else"
+		Expect(k8sClient.Update(ctx, secret)).To(Succeed())
+		Expect(r.reconcileTarget(ctx, kubeconfig, []byte("second"))).To(Succeed())
+		Expect(k8sClient.Get(ctx, first, secret)).To(Succeed())
+		Expect(secret.Data).To(HaveKeyWithValue("config", []byte("second")))
+		Expect(secret.Labels).To(HaveKeyWithValue("owner", "someone-else"))
+
+		By("deleting the previous secret when the target moves")
+		kubeconfig.Spec.Target = &kubeconfigv1alpha1.KubeconfigTarget{Namespace: namespace, SecretName: "moved", Key: "config"}
+		Expect(r.reconcileTarget(ctx, kubeconfig, []byte("second"))).To(Succeed())
+		Expect(apierrors.IsNotFound(k8sClient.Get(ctx, first, &corev1.Secret{}))).To(BeTrue())
+		moved := types.NamespacedName{Namespace: namespace, Name: "moved"}
+		Expect(k8sClient.Get(ctx, moved, &corev1.Secret{})).To(Succeed())
+
+		By("deleting the secret when the target is removed")
+		kubeconfig.Spec.Target = nil
+		Expect(r.reconcileTarget(ctx, kubeconfig, []byte("second"))).To(Succeed())
+		Expect(apierrors.IsNotFound(k8sClient.Get(ctx, moved, &corev1.Secret{}))).To(BeTrue())
+		Expect(getKubeconfig(kubeconfig).Status.TargetSecret).To(BeNil())
+	})
+
+	It("refuses to take over a secret that is not managed by the kubeconfig", func() {
+		existing := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: "existing"},
+			Data:       map[string][]byte{"config": []byte("unrelated")},
+		}
+		Expect(k8sClient.Create(ctx, existing)).To(Succeed())
+		kubeconfig := newTestKubeconfig("target")
+		kubeconfig.Spec.Target = &kubeconfigv1alpha1.KubeconfigTarget{Namespace: namespace, SecretName: "existing", Key: "config"}
+		createTestKubeconfig(kubeconfig)
+
+		Expect(r.reconcileTarget(ctx, kubeconfig, []byte("kubeconfig"))).NotTo(Succeed())
+		secret := &corev1.Secret{}
+		Expect(k8sClient.Get(ctx, types.NamespacedName{Namespace: namespace, Name: "existing"}, secret)).To(Succeed())
+		Expect(secret.Data).To(HaveKeyWithValue("config", []byte("unrelated")))
+		Expect(getKubeconfig(kubeconfig).Status.TargetSecret).To(BeNil())
+	})
+})
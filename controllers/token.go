@@ -0,0 +1,257 @@
+/*
+Copyright 2022 zoomoid.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	kubeconfigv1alpha1 "github.com/zoomoid/kubeconfig-operator/api/v1alpha1"
+	"github.com/zoomoid/kubeconfig-operator/controllers/phases"
+	config "github.com/zoomoid/kubeconfig-operator/pkg/kubeconfig"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// ServiceAccountTokenKey is the key of the user secret that holds the token in the ServiceAccountToken auth mode
+const ServiceAccountTokenKey = "token"
+
+// serviceAccountFor returns the name of the ServiceAccount created for a kubeconfig in the ServiceAccountToken auth mode
+func serviceAccountFor(kubeconfig *kubeconfigv1alpha1.Kubeconfig) types.NamespacedName {
+	return types.NamespacedName{
+		Namespace: operatorNamespace,
+		Name:      kubeconfig.Name,
+	}
+}
+
+// reconcileServiceAccountToken creates the ServiceAccount of a kubeconfig in the ServiceAccountToken auth mode,
+// requests a bound token for it, and refreshes the token before it expires. The token is bound to the user secret,
+// such that deleting the secret invalidates the token. Nothing is created before the kubeconfig is approved
+func (r *KubeconfigReconciler) reconcileServiceAccountToken(ctx context.Context, kubeconfig *kubeconfigv1alpha1.Kubeconfig) (ctrl.Result, error) {
+	status := kubeconfig.Status.DeepCopy()
+	approved, err := r.reconcileApproval(ctx, kubeconfig)
+	if !approved || err != nil {
+		// approvals and changes of approval policies trigger another reconciliation
+		return ctrl.Result{}, err
+	}
+
+	serviceAccountName := serviceAccountFor(kubeconfig)
+	serviceAccount := &corev1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: serviceAccountName.Namespace,
+			Name:      serviceAccountName.Name,
+		},
+	}
+	_, err = controllerutil.CreateOrUpdate(ctx, r.Client, serviceAccount, func() error {
+		if !serviceAccount.CreationTimestamp.IsZero() && !metav1.IsControlledBy(serviceAccount, kubeconfig) {
+			// never hand out tokens of ServiceAccounts that were not created for this kubeconfig
+			return fmt.Errorf("service account %s/%s already exists and is not managed by kubeconfig %s", serviceAccount.Namespace, serviceAccount.Name, kubeconfig.Name)
+		}
+		if serviceAccount.Labels == nil {
+			serviceAccount.Labels = map[string]string{}
+		}
+		for k, v := range labelsForSubresources(kubeconfig) {
+			serviceAccount.Labels[k] = v
+		}
+		return controllerutil.SetControllerReference(kubeconfig, serviceAccount, r.Scheme)
+	})
+	if err != nil {
+		klog.ErrorS(err, "failed to reconcile service account", "namespace", serviceAccount.Namespace, "name", serviceAccount.Name)
+		r.Recorder.Eventf(kubeconfig, "Warning", "ServiceAccountFailed", "Failed to reconcile service account %s/%s, %v", serviceAccount.Namespace, serviceAccount.Name, err)
+		return ctrl.Result{}, err
+	}
+
+	userSecretName := types.NamespacedName{
+		Namespace: operatorNamespace,
+		Name:      fmt.Sprintf("%s-token", kubeconfig.Name),
+	}
+	userSecret := &corev1.Secret{}
+	err = r.Get(ctx, userSecretName, userSecret)
+	if apierrors.IsNotFound(err) {
+		userSecret = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: userSecretName.Namespace,
+				Name:      userSecretName.Name,
+				Labels:    labelsForSubresources(kubeconfig),
+			},
+			Type: corev1.SecretTypeOpaque,
+		}
+		controllerutil.SetControllerReference(kubeconfig, userSecret, r.Scheme)
+		err = r.Create(ctx, userSecret)
+		if err != nil {
+			klog.ErrorS(err, "failed to create user secret", "namespace", userSecretName.Namespace, "name", userSecretName.Name)
+			r.Recorder.Eventf(kubeconfig, "Warning", "UserSecretFailed", "Failed to create user secret, %v", err)
+			return ctrl.Result{}, err
+		}
+		klog.V(2).InfoS("Created user secret", "namespace", userSecret.Namespace, "name", userSecret.Name)
+	} else if err != nil {
+		klog.ErrorS(err, "failed to get user secret", "namespace", userSecretName.Namespace, "name", userSecretName.Name)
+		return ctrl.Result{}, err
+	}
+	kubeconfig.Status.UserSecret = kubeconfigv1alpha1.SecretRef{
+		Namespace: userSecret.Namespace,
+		Name:      userSecret.Name,
+	}
+
+	refresh := !isFinished(kubeconfig) ||
+		len(userSecret.Data[ServiceAccountTokenKey]) == 0 ||
+		kubeconfig.Status.RenewalTime == nil ||
+		!time.Now().Before(kubeconfig.Status.RenewalTime.Time) ||
+		credentialsSpecChanged(kubeconfig, kubeconfig.Status.CredentialsSpecHash)
+	if refresh {
+		err = r.issueServiceAccountToken(ctx, kubeconfig, serviceAccount, userSecret)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	err = r.reconcileTarget(ctx, kubeconfig, userSecret.Data[KubeconfigKey])
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	err = r.reconcileBindings(ctx, kubeconfig)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	kubeconfig.Status.Status = phases.PhaseDone
+	if !equality.Semantic.DeepEqual(status, &kubeconfig.Status) {
+		err = r.Status().Update(ctx, kubeconfig)
+		if err != nil {
+			klog.ErrorS(err, "failed to update kubeconfig status", "name", kubeconfig.Name)
+			return ctrl.Result{}, err
+		}
+	}
+	renewIn := time.Until(kubeconfig.Status.RenewalTime.Time)
+	klog.V(2).InfoS("Service account token is still valid, requeuing until refresh", "name", kubeconfig.Name, "renewalTime", kubeconfig.Status.RenewalTime)
+	return ctrl.Result{RequeueAfter: renewIn}, nil
+}
+
+// issueServiceAccountToken requests a new token for the ServiceAccount through the TokenRequest API and replaces the
+// token and the kubeconfig in the user secret with a single update. The status is updated in memory only
+func (r *KubeconfigReconciler) issueServiceAccountToken(ctx context.Context, kubeconfig *kubeconfigv1alpha1.Kubeconfig, serviceAccount *corev1.ServiceAccount, userSecret *corev1.Secret) error {
+	duration := kubeconfigv1alpha1.DefaultTokenDuration
+	var audiences []string
+	if spec := kubeconfig.Spec.ServiceAccountToken; spec != nil {
+		audiences = spec.Audiences
+		if spec.Duration != nil {
+			duration = spec.Duration.Duration
+		}
+	}
+	expirationSeconds := int64(duration.Seconds())
+	tokenRequest := &authenticationv1.TokenRequest{
+		Spec: authenticationv1.TokenRequestSpec{
+			Audiences:         audiences,
+			ExpirationSeconds: &expirationSeconds,
+			BoundObjectRef: &authenticationv1.BoundObjectReference{
+				Kind:       "Secret",
+				APIVersion: "v1",
+				Name:       userSecret.Name,
+				UID:        userSecret.UID,
+			},
+		},
+	}
+
+	issuedAt := time.Now()
+	tokenRequest, err := r.ClientSet.CoreV1().ServiceAccounts(serviceAccount.Namespace).CreateToken(ctx, serviceAccount.Name, tokenRequest, metav1.CreateOptions{})
+	if err != nil {
+		klog.ErrorS(err, "failed to request service account token", "namespace", serviceAccount.Namespace, "name", serviceAccount.Name)
+		r.Recorder.Eventf(kubeconfig, "Warning", "TokenRequestFailed", "Failed to request token for service account %s/%s, %v", serviceAccount.Namespace, serviceAccount.Name, err)
+		return err
+	}
+	token := tokenRequest.Status.Token
+
+	cfg, err := r.templateKubeconfig(ctx, kubeconfig, config.User{
+		Token: token,
+	})
+	if err != nil {
+		klog.ErrorS(err, "failed to template kubeconfig")
+		r.Recorder.Eventf(kubeconfig, "Warning", "KubeconfigSecretFailed", "Failed to template kubeconfig, %v", err)
+		return err
+	}
+	if userSecret.Data == nil {
+		userSecret.Data = map[string][]byte{}
+	}
+	userSecret.Data[ServiceAccountTokenKey] = []byte(token)
+	userSecret.Data[KubeconfigKey] = cfg
+	err = r.Update(ctx, userSecret)
+	if err != nil {
+		klog.ErrorS(err, "failed to update user secret", "namespace", userSecret.Namespace, "name", userSecret.Name)
+		return err
+	}
+
+	expiresAt := tokenRequest.Status.ExpirationTimestamp.Rfc3339Copy()
+	renewAt := metav1.NewTime(renewalTime(issuedAt, expiresAt.Time, kubeconfig.Spec.RenewBefore)).Rfc3339Copy()
+	if isFinished(kubeconfig) {
+		r.Recorder.Event(kubeconfig, "Normal", "TokenRefreshed", "Refreshed service account token of kubeconfig")
+	} else {
+		r.Recorder.Event(kubeconfig, "Normal", "TokenIssued", "Issued service account token for kubeconfig")
+	}
+	klog.V(0).InfoS("Issued service account token", "name", kubeconfig.Name, "expirationTimestamp", expiresAt)
+
+	meta.SetStatusCondition(&kubeconfig.Status.Conditions, metav1.Condition{
+		Type:    kubeconfigv1alpha1.ConditionTypeUserSecretFinished,
+		Status:  metav1.ConditionTrue,
+		Reason:  "Upserted",
+		Message: "Upserted user secret with service account token",
+	})
+	meta.SetStatusCondition(&kubeconfig.Status.Conditions, metav1.Condition{
+		Type:    kubeconfigv1alpha1.ConditionTypeKubeconfigFinished,
+		Status:  metav1.ConditionTrue,
+		Reason:  "Finished",
+		Message: "Finished kubeconfig creation",
+	})
+	kubeconfig.Status.Kubeconfig = r.statusKubeconfig(cfg)
+	kubeconfig.Status.TokenExpirationTime = &expiresAt
+	kubeconfig.Status.RenewalTime = &renewAt
+	kubeconfig.Status.CredentialsGeneration = kubeconfig.Generation
+	kubeconfig.Status.CredentialsSpecHash = credentialsSpecHash(kubeconfig)
+	kubeconfig.Status.Retries = 0
+	kubeconfig.Status.Status = phases.PhaseDone
+	return nil
+}
+
+// deleteServiceAccount removes the ServiceAccount of a kubeconfig in the ServiceAccountToken auth mode, which
+// invalidates all tokens issued for it. ServiceAccounts not created for the kubeconfig are left in place
+func (r *KubeconfigReconciler) deleteServiceAccount(ctx context.Context, kubeconfig *kubeconfigv1alpha1.Kubeconfig) error {
+	name := serviceAccountFor(kubeconfig)
+	serviceAccount := &corev1.ServiceAccount{}
+	err := r.Get(ctx, name, serviceAccount)
+	if apierrors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if !metav1.IsControlledBy(serviceAccount, kubeconfig) {
+		return nil
+	}
+	err = r.Delete(ctx, serviceAccount)
+	if err != nil && !apierrors.IsNotFound(err) {
+		klog.ErrorS(err, "failed to delete service account of kubeconfig", "namespace", name.Namespace, "name", name.Name)
+		return err
+	}
+	return nil
+}
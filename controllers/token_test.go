@@ -0,0 +1,145 @@
+/*
+Copyright 2022 zoomoid.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	kubeconfigv1alpha1 "github.com/zoomoid/kubeconfig-operator/api/v1alpha1"
+	"github.com/zoomoid/kubeconfig-operator/controllers/phases"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+var _ = Describe("ServiceAccount token kubeconfigs", func() {
+	var r *KubeconfigReconciler
+	var kubeconfig *kubeconfigv1alpha1.Kubeconfig
+
+	BeforeEach(func() {
+		r = newTestReconciler()
+		kubeconfig = newTestKubeconfig("token")
+		kubeconfig.Spec.AuthMode = kubeconfigv1alpha1.AuthModeServiceAccountToken
+		kubeconfig.Spec.CSR = nil
+	})
+
+	// tokenSecret returns the key of the user secret of the kubeconfig
+	tokenSecret := func() types.NamespacedName {
+		return types.NamespacedName{Namespace: operatorNamespace, Name: fmt.Sprintf("%s-token", kubeconfig.Name)}
+	}
+
+	// tokenClaims returns the claims of the JWT issued by the API server
+	tokenClaims := func(token string) map[string]interface{} {
+		parts := strings.Split(token, ".")
+		Expect(parts).To(HaveLen(3))
+		payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+		Expect(err).NotTo(HaveOccurred())
+		claims := map[string]interface{}{}
+		Expect(json.Unmarshal(payload, &claims)).To(Succeed())
+		return claims
+	}
+
+	It("creates nothing before the kubeconfig is approved", func() {
+		createTestKubeconfig(kubeconfig)
+		_, err := r.reconcileServiceAccountToken(ctx, kubeconfig)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(apierrors.IsNotFound(k8sClient.Get(ctx, serviceAccountFor(kubeconfig), &corev1.ServiceAccount{}))).To(BeTrue())
+		Expect(apierrors.IsNotFound(k8sClient.Get(ctx, tokenSecret(), &corev1.Secret{}))).To(BeTrue())
+		Expect(apierrors.IsNotFound(k8sClient.Get(ctx, types.NamespacedName{Name: fmt.Sprintf("%s-kubeconfig", kubeconfig.Spec.Username)}, &rbacv1.ClusterRoleBinding{}))).To(BeTrue())
+		Expect(getKubeconfig(kubeconfig).Status.Status).To(Equal(phases.PhaseAwaitingApproval))
+	})
+
+	It("delivers a bound token of the kubeconfig's ServiceAccount once approved", func() {
+		namespace := newTestNamespace("token")
+		kubeconfig.Spec.AutoApproveCSR = true
+		kubeconfig.Spec.ServiceAccountToken = &kubeconfigv1alpha1.ServiceAccountToken{
+			Audiences: []string{"https://kubernetes.default.svc"},
+			Duration:  &metav1.Duration{Duration: time.Hour},
+		}
+		kubeconfig.Spec.Target = &kubeconfigv1alpha1.KubeconfigTarget{Namespace: namespace, SecretName: "kubeconfig", Key: "config"}
+		createTestKubeconfig(kubeconfig)
+
+		result, err := r.reconcileServiceAccountToken(ctx, kubeconfig)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.RequeueAfter).To(BeNumerically(">", 0))
+		Expect(result.RequeueAfter).To(BeNumerically("<=", time.Hour))
+
+		serviceAccount := &corev1.ServiceAccount{}
+		Expect(k8sClient.Get(ctx, serviceAccountFor(kubeconfig), serviceAccount)).To(Succeed())
+		Expect(metav1.IsControlledBy(serviceAccount, kubeconfig)).To(BeTrue())
+
+		userSecret := &corev1.Secret{}
+		Expect(k8sClient.Get(ctx, tokenSecret(), userSecret)).To(Succeed())
+		token := string(userSecret.Data[ServiceAccountTokenKey])
+		Expect(token).NotTo(BeEmpty())
+		Expect(string(userSecret.Data[KubeconfigKey])).To(ContainSubstring(token))
+
+		By("binding the token to the user secret")
+		claims := tokenClaims(token)
+		Expect(claims["aud"]).To(ConsistOf("https://kubernetes.default.svc"))
+		Expect(claims["sub"]).To(Equal(fmt.Sprintf("system:serviceaccount:%s:%s", operatorNamespace, kubeconfig.Name)))
+		Expect(claims).To(HaveKey("kubernetes.io"))
+		Expect(claims["kubernetes.io"]).To(HaveKeyWithValue("secret", HaveKeyWithValue("name", userSecret.Name)))
+
+		target := &corev1.Secret{}
+		Expect(k8sClient.Get(ctx, types.NamespacedName{Namespace: namespace, Name: "kubeconfig"}, target)).To(Succeed())
+		Expect(target.Data).To(HaveKeyWithValue("config", userSecret.Data[KubeconfigKey]))
+
+		binding := &rbacv1.ClusterRoleBinding{}
+		Expect(k8sClient.Get(ctx, types.NamespacedName{Name: fmt.Sprintf("%s-kubeconfig", kubeconfig.Spec.Username)}, binding)).To(Succeed())
+		Expect(binding.Subjects).To(ConsistOf(rbacv1.Subject{Kind: rbacv1.ServiceAccountKind, Namespace: operatorNamespace, Name: kubeconfig.Name}))
+
+		stored := getKubeconfig(kubeconfig)
+		Expect(stored.Status.Status).To(Equal(phases.PhaseDone))
+		Expect(stored.Status.UserSecret).To(Equal(kubeconfigv1alpha1.SecretRef{Namespace: operatorNamespace, Name: userSecret.Name}))
+		Expect(stored.Status.TokenExpirationTime).NotTo(BeNil())
+		Expect(stored.Status.TokenExpirationTime.Time).To(BeTemporally("~", time.Now().Add(time.Hour), time.Minute))
+		Expect(stored.Status.RenewalTime).NotTo(BeNil())
+		Expect(stored.Status.RenewalTime.Time).To(BeTemporally("<", stored.Status.TokenExpirationTime.Time))
+		Expect(stored.Status.CredentialsSpecHash).To(Equal(credentialsSpecHash(kubeconfig)))
+
+		By("keeping the token while it is valid")
+		_, err = r.reconcileServiceAccountToken(ctx, stored)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(k8sClient.Get(ctx, tokenSecret(), userSecret)).To(Succeed())
+		Expect(string(userSecret.Data[ServiceAccountTokenKey])).To(Equal(token))
+	})
+
+	It("refuses to hand out tokens of a ServiceAccount not managed by the kubeconfig", func() {
+		kubeconfig.Spec.AutoApproveCSR = true
+		createTestKubeconfig(kubeconfig)
+		name := serviceAccountFor(kubeconfig)
+		Expect(k8sClient.Create(ctx, &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Namespace: name.Namespace, Name: name.Name}})).To(Succeed())
+
+		_, err := r.reconcileServiceAccountToken(ctx, kubeconfig)
+		Expect(err).To(HaveOccurred())
+		Expect(apierrors.IsNotFound(k8sClient.Get(ctx, tokenSecret(), &corev1.Secret{}))).To(BeTrue())
+		serviceAccount := &corev1.ServiceAccount{}
+		Expect(k8sClient.Get(ctx, name, serviceAccount)).To(Succeed())
+		Expect(serviceAccount.OwnerReferences).To(BeEmpty())
+	})
+})
@@ -17,32 +17,32 @@ limitations under the License.
 package controllers
 
 import (
-	"bytes"
-	"crypto"
-	"crypto/ecdsa"
-	"crypto/ed25519"
+	"context"
 	"crypto/elliptic"
-	"crypto/rand"
-	"crypto/rsa"
 	"crypto/x509"
 	"crypto/x509/pkix"
-	"encoding/pem"
 	"errors"
+	"fmt"
+	"regexp"
 
 	kubeconfigv1alpha1 "github.com/zoomoid/kubeconfig-operator/api/v1alpha1"
+	kcrypto "github.com/zoomoid/kubeconfig-operator/pkg/crypto"
 	certificatesv1 "k8s.io/api/certificates/v1"
-	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-)
-
-const (
-	RSAKeyLength int = 4096
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
 )
 
 var (
-	ErrCertificateSigningRequestCreate error = apierrors.NewInternalError(errors.New("failed to create CSR"))
+	// conditionReasonPattern is the format of reasons accepted by the API server in metav1.Conditions
+	conditionReasonPattern = regexp.MustCompile(`^[A-Za-z]([A-Za-z0-9_,:]*[A-Za-z0-9_])?$`)
+
 	ErrCertificateSigningRequestDenied error = errors.New("csr was denied")
+
+	// ErrPassphraseUnavailable is returned if the passphrase referenced by .spec.csr.privateKeyPassphrase cannot be read
+	ErrPassphraseUnavailable error = errors.New("failed to read the private key passphrase from the referenced secret")
 )
 
 func labelsForSubresources(kubeconfig *kubeconfigv1alpha1.Kubeconfig) map[string]string {
@@ -79,6 +79,24 @@ func parseSignatureAlgorithm(signatureAlgorithm kubeconfigv1alpha1.SignatureAlgo
 	}
 }
 
+// getCertFailureCondition returns the Denied or Failed condition of a CSR, or nil if it has neither
+func getCertFailureCondition(conditions []certificatesv1.CertificateSigningRequestCondition) *certificatesv1.CertificateSigningRequestCondition {
+	for i := range conditions {
+		if conditions[i].Type == certificatesv1.CertificateDenied || conditions[i].Type == certificatesv1.CertificateFailed {
+			return &conditions[i]
+		}
+	}
+	return nil
+}
+
+// conditionReason returns the reason if it is valid for a metav1.Condition, and the fallback otherwise
+func conditionReason(reason string, fallback string) string {
+	if conditionReasonPattern.MatchString(reason) {
+		return reason
+	}
+	return fallback
+}
+
 func getCertApprovalCondition(conditions []certificatesv1.CertificateSigningRequestCondition) (approved bool, denied bool, failed bool) {
 	for _, c := range conditions {
 		if c.Type == certificatesv1.CertificateApproved {
@@ -94,71 +112,141 @@ func getCertApprovalCondition(conditions []certificatesv1.CertificateSigningRequ
 	return
 }
 
-func createRSAKey() (*rsa.PrivateKey, *bytes.Buffer) {
-	key := &bytes.Buffer{}
-	priv, _ := rsa.GenerateKey(rand.Reader, RSAKeyLength)
-	pem.Encode(key, &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(priv)})
-	return priv, key
+// ecdsaCurve returns the curve requested in .spec.csr.curve, or nil for the curve matching the signature algorithm's hash
+func ecdsaCurve(csr *kubeconfigv1alpha1.CertificateSigningRequest) (elliptic.Curve, error) {
+	switch csr.Curve {
+	case "":
+		return nil, nil
+	case kubeconfigv1alpha1.ECDSACurveP256:
+		return elliptic.P256(), nil
+	case kubeconfigv1alpha1.ECDSACurveP384:
+		return elliptic.P384(), nil
+	case kubeconfigv1alpha1.ECDSACurveP521:
+		return elliptic.P521(), nil
+	default:
+		return nil, fmt.Errorf("%w: unsupported curve %s", kcrypto.ErrInvalidKeyParameters, csr.Curve)
+	}
 }
 
-func createECDSAKey() (*ecdsa.PrivateKey, *bytes.Buffer) {
-	key := &bytes.Buffer{}
-	priv, _ := ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
-	marshalledKey, _ := x509.MarshalECPrivateKey(priv)
-	pem.Encode(key, &pem.Block{Type: "EC PRIVATE KEY", Bytes: marshalledKey})
-	return priv, key
+// keyMaterial is the PEM-encoded private key and CSR generated for a kubeconfig
+type keyMaterial struct {
+	Key []byte
+	// EncryptedKey is the private key as encrypted PKCS#8, only if .spec.csr.privateKeyPassphrase is set
+	EncryptedKey []byte
+	CSR          []byte
 }
 
-func createEd25519Key() (ed25519.PrivateKey, *bytes.Buffer) {
-	key := &bytes.Buffer{}
-	_, priv, _ := ed25519.GenerateKey(rand.Reader)
-	marshalledKey, _ := x509.MarshalPKCS8PrivateKey(priv)
-	pem.Encode(key, &pem.Block{Type: "PRIVATE KEY", Bytes: marshalledKey})
-	return priv, key
+// privateKeyPassphrase reads the passphrase referenced by .spec.csr.privateKeyPassphrase. Only secrets in the
+// namespace of .spec.target may be referenced, which the webhook enforces as well. The returned error does not
+// tell missing secrets apart from missing keys or namespaces that are not allowed, since it ends up in the status
+func (r *KubeconfigReconciler) privateKeyPassphrase(ctx context.Context, kubeconfig *kubeconfigv1alpha1.Kubeconfig) ([]byte, error) {
+	ref := kubeconfig.Spec.CSR.PrivateKeyPassphrase
+	if target := kubeconfig.Spec.Target; target == nil || ref.Namespace != target.Namespace {
+		klog.ErrorS(nil, "passphrase secret is not in the target namespace", "name", kubeconfig.Name, "namespace", ref.Namespace)
+		return nil, ErrPassphraseUnavailable
+	}
+	key := ref.Key
+	if key == "" {
+		key = "passphrase"
+	}
+	secret := &corev1.Secret{}
+	err := r.Get(ctx, types.NamespacedName{Namespace: ref.Namespace, Name: ref.Name}, secret)
+	if err != nil {
+		klog.ErrorS(err, "failed to get passphrase secret", "name", kubeconfig.Name, "namespace", ref.Namespace, "secret", ref.Name)
+		return nil, ErrPassphraseUnavailable
+	}
+	passphrase := secret.Data[key]
+	if len(passphrase) == 0 {
+		klog.ErrorS(nil, "passphrase secret has no passphrase", "name", kubeconfig.Name, "namespace", ref.Namespace, "secret", ref.Name, "key", key)
+		return nil, ErrPassphraseUnavailable
+	}
+	return passphrase, nil
 }
 
 // createCSR creates a new PEM certificate signing request and a private key depending on what signature algorithm the kubeconfig resource specifieds
-// it returns both as a buffer, or nil, and an error
-func (r *KubeconfigReconciler) createCSR(kubeconfig *kubeconfigv1alpha1.Kubeconfig) (key *bytes.Buffer, csr *bytes.Buffer, err error) {
-	var keyBytes crypto.Signer
-	var encoded *bytes.Buffer
-	csr = &bytes.Buffer{}
-	sigScheme := kubeconfig.Spec.CSR.SignatureAlgorithm
-	switch sigScheme {
-	case kubeconfigv1alpha1.SHA256WithRSA, kubeconfigv1alpha1.SHA384WithRSA, kubeconfigv1alpha1.SHA512WithRSA, kubeconfigv1alpha1.SHA256WithRSAPSS, kubeconfigv1alpha1.SHA384WithRSAPSS, kubeconfigv1alpha1.SHA512WithRSAPSS:
-		keyBytes, encoded = createRSAKey()
-	case kubeconfigv1alpha1.ECDSAWithSHA256, kubeconfigv1alpha1.ECDSAWithSHA384, kubeconfigv1alpha1.ECDSAWithSHA512:
-		keyBytes, encoded = createECDSAKey()
-	case kubeconfigv1alpha1.PureEd25519:
-		keyBytes, encoded = createEd25519Key()
-	case kubeconfigv1alpha1.UnknownSignatureAlgorithm:
-		return nil, nil, errors.New("unsupported signature scheme for key generation")
+// it returns both, and the encrypted private key if a passphrase is configured, or nil and an error
+func (r *KubeconfigReconciler) createCSR(ctx context.Context, kubeconfig *kubeconfigv1alpha1.Kubeconfig) (*keyMaterial, error) {
+	csrSpec := kubeconfig.Spec.CSR
+	curve, err := ecdsaCurve(csrSpec)
+	if err != nil {
+		return nil, err
+	}
+	var passphrase []byte
+	if csrSpec.PrivateKeyPassphrase != nil {
+		// read the passphrase first to not generate keys that cannot be encrypted
+		passphrase, err = r.privateKeyPassphrase(ctx, kubeconfig)
+		if err != nil {
+			return nil, err
+		}
+	}
+	generator := r.KeyGenerator
+	if generator == nil {
+		generator = &kcrypto.DefaultKeyGenerator{}
+	}
+	algorithm := parseSignatureAlgorithm(csrSpec.SignatureAlgorithm)
+	privateKey, err := generator.GenerateKey(kcrypto.KeyOptions{
+		Algorithm:  algorithm,
+		RSAKeySize: csrSpec.KeySize,
+		Curve:      curve,
+	})
+	if err != nil {
+		return nil, err
+	}
+	material := &keyMaterial{}
+	material.Key, err = kcrypto.EncodePrivateKeyAs(privateKey, kcrypto.KeyEncoding(csrSpec.KeyEncoding))
+	if err != nil {
+		return nil, err
+	}
+	if passphrase != nil {
+		material.EncryptedKey, err = kcrypto.EncryptPrivateKey(privateKey, passphrase)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	csrSpec := kubeconfig.Spec.CSR
 	fields := csrSpec.AdditionalFields
 	subj := pkix.Name{
 		CommonName:         kubeconfig.Spec.Username,
 		Country:            fields.Country,
 		Province:           fields.Province,
 		Locality:           fields.Locality,
-		Organization:       fields.Organization,
+		Organization:       certificateOrganizations(kubeconfig),
 		OrganizationalUnit: fields.OrganizationalUnit,
 		ExtraNames:         []pkix.AttributeTypeAndValue{},
 	}
 
 	template := x509.CertificateRequest{
 		Subject:            subj,
-		SignatureAlgorithm: parseSignatureAlgorithm(csrSpec.SignatureAlgorithm),
+		SignatureAlgorithm: algorithm,
 	}
 
-	csrBytes, err := x509.CreateCertificateRequest(rand.Reader, &template, keyBytes)
+	material.CSR, err = kcrypto.CreateCertificateRequest(&template, privateKey)
 	if err != nil {
-		return nil, nil, ErrCertificateSigningRequestCreate
+		return nil, err
 	}
+	return material, nil
+}
 
-	pem.Encode(csr, &pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csrBytes})
-	return encoded, csr, nil
+// certificateOrganizations returns the organizations of the kubeconfig's certificate, which the kube-apiserver maps to the
+// user's groups. These are the organizations from the CSR's additional fields followed by the kubeconfig's groups
+func certificateOrganizations(kubeconfig *kubeconfigv1alpha1.Kubeconfig) []string {
+	var organizations []string
+	if kubeconfig.Spec.CSR != nil {
+		organizations = append(organizations, kubeconfig.Spec.CSR.AdditionalFields.Organization...)
+	}
+	for _, group := range kubeconfig.Spec.Groups {
+		found := false
+		for _, organization := range organizations {
+			if organization == group {
+				found = true
+				break
+			}
+		}
+		if !found {
+			organizations = append(organizations, group)
+		}
+	}
+	return organizations
 }
 
 // isInTerminalCondition returns true if any of the relevant final conditions have reached a terminal state
@@ -172,3 +260,8 @@ func isInTerminalCondition(kubeconfig *kubeconfigv1alpha1.Kubeconfig) bool {
 	}
 	return f.Status != metav1.ConditionUnknown
 }
+
+// isFinished returns true if the kubeconfig was created successfully
+func isFinished(kubeconfig *kubeconfigv1alpha1.Kubeconfig) bool {
+	return meta.IsStatusConditionTrue(kubeconfig.Status.Conditions, kubeconfigv1alpha1.ConditionTypeKubeconfigFinished)
+}
@@ -0,0 +1,128 @@
+/*
+Copyright 2022 zoomoid.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	kubeconfigv1alpha1 "github.com/zoomoid/kubeconfig-operator/api/v1alpha1"
+	"github.com/zoomoid/kubeconfig-operator/controllers/phases"
+	kcrypto "github.com/zoomoid/kubeconfig-operator/pkg/crypto"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("CSR creation", func() {
+	// createCSR returns the key material generated for a kubeconfig with the CSR parameters
+	createCSR := func(csr kubeconfigv1alpha1.CertificateSigningRequest) (*keyMaterial, error) {
+		kubeconfig := &kubeconfigv1alpha1.Kubeconfig{}
+		kubeconfig.Name = "alice"
+		kubeconfig.Spec.Username = "alice"
+		kubeconfig.Spec.CSR = &csr
+		return (&KubeconfigReconciler{}).createCSR(ctx, kubeconfig)
+	}
+
+	table.DescribeTable("generates a key and a signed request",
+		func(csr kubeconfigv1alpha1.CertificateSigningRequest, algorithm x509.SignatureAlgorithm, bits int, curve string) {
+			Expect(parseSignatureAlgorithm(csr.SignatureAlgorithm)).To(Equal(algorithm))
+			material, err := createCSR(csr)
+			Expect(err).NotTo(HaveOccurred())
+
+			request, err := parseCSR(material.CSR)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(request.CheckSignature()).To(Succeed())
+			Expect(request.SignatureAlgorithm).To(Equal(algorithm))
+			Expect(request.Subject.CommonName).To(Equal("alice"))
+			switch key := request.PublicKey.(type) {
+			case *rsa.PublicKey:
+				Expect(key.N.BitLen()).To(Equal(bits))
+			case *ecdsa.PublicKey:
+				Expect(key.Curve.Params().Name).To(Equal(curve))
+			case ed25519.PublicKey:
+				Expect(algorithm).To(Equal(x509.PureEd25519))
+			default:
+				Fail("unexpected public key type")
+			}
+			block, _ := pem.Decode(material.Key)
+			Expect(block).NotTo(BeNil())
+		},
+		table.Entry("SHA256WithRSA", kubeconfigv1alpha1.CertificateSigningRequest{SignatureAlgorithm: kubeconfigv1alpha1.SHA256WithRSA}, x509.SHA256WithRSA, kcrypto.DefaultRSAKeySize, ""),
+		table.Entry("SHA384WithRSA", kubeconfigv1alpha1.CertificateSigningRequest{SignatureAlgorithm: kubeconfigv1alpha1.SHA384WithRSA, KeySize: 3072}, x509.SHA384WithRSA, 3072, ""),
+		table.Entry("SHA512WithRSA", kubeconfigv1alpha1.CertificateSigningRequest{SignatureAlgorithm: kubeconfigv1alpha1.SHA512WithRSA, KeySize: 2048}, x509.SHA512WithRSA, 2048, ""),
+		table.Entry("SHA256WithRSAPSS", kubeconfigv1alpha1.CertificateSigningRequest{SignatureAlgorithm: kubeconfigv1alpha1.SHA256WithRSAPSS, KeySize: 2048}, x509.SHA256WithRSAPSS, 2048, ""),
+		table.Entry("SHA384WithRSAPSS", kubeconfigv1alpha1.CertificateSigningRequest{SignatureAlgorithm: kubeconfigv1alpha1.SHA384WithRSAPSS, KeySize: 2048}, x509.SHA384WithRSAPSS, 2048, ""),
+		table.Entry("SHA512WithRSAPSS", kubeconfigv1alpha1.CertificateSigningRequest{SignatureAlgorithm: kubeconfigv1alpha1.SHA512WithRSAPSS, KeySize: 2048}, x509.SHA512WithRSAPSS, 2048, ""),
+		table.Entry("ECDSAWithSHA256", kubeconfigv1alpha1.CertificateSigningRequest{SignatureAlgorithm: kubeconfigv1alpha1.ECDSAWithSHA256}, x509.ECDSAWithSHA256, 0, "P-256"),
+		table.Entry("ECDSAWithSHA384", kubeconfigv1alpha1.CertificateSigningRequest{SignatureAlgorithm: kubeconfigv1alpha1.ECDSAWithSHA384}, x509.ECDSAWithSHA384, 0, "P-384"),
+		table.Entry("ECDSAWithSHA512", kubeconfigv1alpha1.CertificateSigningRequest{SignatureAlgorithm: kubeconfigv1alpha1.ECDSAWithSHA512}, x509.ECDSAWithSHA512, 0, "P-521"),
+		table.Entry("ECDSAWithSHA512 with a curve", kubeconfigv1alpha1.CertificateSigningRequest{SignatureAlgorithm: kubeconfigv1alpha1.ECDSAWithSHA512, Curve: kubeconfigv1alpha1.ECDSACurveP256}, x509.ECDSAWithSHA512, 0, "P-256"),
+		table.Entry("PureEd25519", kubeconfigv1alpha1.CertificateSigningRequest{SignatureAlgorithm: kubeconfigv1alpha1.PureEd25519}, x509.PureEd25519, 0, ""),
+	)
+
+	table.DescribeTable("propagates key generation errors",
+		func(csr kubeconfigv1alpha1.CertificateSigningRequest, want error) {
+			material, err := createCSR(csr)
+			Expect(err).To(MatchError(want))
+			Expect(material).To(BeNil())
+		},
+		table.Entry("of an unknown signature algorithm", kubeconfigv1alpha1.CertificateSigningRequest{SignatureAlgorithm: kubeconfigv1alpha1.UnknownSignatureAlgorithm}, kcrypto.ErrUnsupportedAlgorithm),
+		table.Entry("of an unsupported curve", kubeconfigv1alpha1.CertificateSigningRequest{SignatureAlgorithm: kubeconfigv1alpha1.ECDSAWithSHA256, Curve: "P-224"}, kcrypto.ErrInvalidKeyParameters),
+		table.Entry("of a too small RSA key", kubeconfigv1alpha1.CertificateSigningRequest{SignatureAlgorithm: kubeconfigv1alpha1.SHA256WithRSA, KeySize: 1024}, kcrypto.ErrInvalidKeyParameters),
+	)
+
+	table.DescribeTable("fails kubeconfigs whose key cannot be generated",
+		func(err error, reason string) {
+			r := newTestReconciler()
+			r.KeyGenerator = failingKeyGenerator{err: err}
+			kubeconfig := newTestKubeconfig("keys")
+			createTestKubeconfig(kubeconfig)
+			_, reconcileErr := reconcileKubeconfig(r, kubeconfig)
+			Expect(reconcileErr).NotTo(HaveOccurred())
+
+			failed := getKubeconfig(kubeconfig)
+			Expect(failed.Status.Status).To(Equal(phases.PhaseFailed))
+			Expect(failed.Status.Csr.Name).To(BeEmpty())
+			condition := meta.FindStatusCondition(failed.Status.Conditions, kubeconfigv1alpha1.ConditionTypeCSRCreated)
+			Expect(condition).NotTo(BeNil())
+			Expect(condition.Status).To(Equal(metav1.ConditionFalse))
+			Expect(condition.Reason).To(Equal(reason))
+			Expect(condition.Message).To(ContainSubstring(err.Error()))
+		},
+		table.Entry("with a transient error", errors.New("entropy exhausted"), "CsrCreateFailed"),
+		table.Entry("with invalid key parameters", fmt.Errorf("curve P-224: %w", kcrypto.ErrInvalidKeyParameters), "InvalidKeyParameters"),
+	)
+})
+
+// failingKeyGenerator is a key generator that fails with its error
+type failingKeyGenerator struct {
+	err error
+}
+
+func (g failingKeyGenerator) GenerateKey(kcrypto.KeyOptions) (crypto.Signer, error) {
+	return nil, g.err
+}
@@ -17,8 +17,11 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"flag"
 	"os"
+	"strings"
+	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
@@ -26,7 +29,9 @@ import (
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 	"k8s.io/klog/v2"
 
+	certificatesv1 "k8s.io/api/certificates/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
@@ -54,11 +59,51 @@ func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var minCertificateDuration time.Duration
+	var maxCertificateDuration time.Duration
+	var kubeconfigStatus string
+	var approvalQuorum int
+	var privilegedClusterRoles string
+	var maxRetries int
+	var retryBackoff time.Duration
+	var signerName string
+	var signerCASecret string
+	var signerDefaultDuration time.Duration
+	var approvableSignerNames string
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.DurationVar(&minCertificateDuration, "min-certificate-duration", kubeconfigv1alpha1.MinimumCertificateDuration,
+		"The shortest client certificate lifetime that kubeconfigs may request in .spec.csr.duration.")
+	flag.DurationVar(&maxCertificateDuration, "max-certificate-duration", 0,
+		"The longest client certificate lifetime that kubeconfigs may request in .spec.csr.duration. "+
+			"Zero only enforces the limit of a CSR's expirationSeconds, about 68 years.")
+	flag.StringVar(&kubeconfigStatus, "kubeconfig-status", string(controllers.KubeconfigStatusNone),
+		"How much of the generated kubeconfig to publish in the Kubeconfig's status. "+
+			"One of None (only reference the user secret) or Redacted (the kubeconfig without the client key).")
+	flag.IntVar(&approvalQuorum, "approval-quorum", 0,
+		"The number of distinct users that need to approve the CSR of a privileged kubeconfig with a KubeconfigApproval. "+
+			"Zero disables multi-party approval.")
+	flag.StringVar(&privilegedClusterRoles, "privileged-cluster-roles", "cluster-admin",
+		"Comma-separated list of ClusterRoles that make a kubeconfig privileged when bound by its roleRef or bindings, "+
+			"or by the KubeconfigGroups of its groups.")
+	flag.IntVar(&maxRetries, "max-retries", 5,
+		"The number of automatic retries of kubeconfigs that failed transiently, e.g., because their CSR failed. Zero disables automatic retries.")
+	flag.DurationVar(&retryBackoff, "retry-backoff", 30*time.Second,
+		"The delay before the first automatic retry of a failed kubeconfig, which doubles with every subsequent retry.")
+	flag.StringVar(&signerName, "signer-name", controllers.KubeconfigSignerName,
+		"The signer name requested by the CSRs of kubeconfigs, e.g., "+controllers.OperatorSignerName+" for the built-in signer.")
+	flag.StringVar(&signerCASecret, "signer-ca-secret", "",
+		"The namespace/name of a kubernetes.io/tls secret with the CA that the operator signs approved CSRs of --signer-name with. "+
+			"Empty disables the built-in signer. The kube-apiserver needs to trust the CA for client authentication, "+
+			"and the operator's ClusterRole needs to grant the sign verb on --signer-name.")
+	flag.DurationVar(&signerDefaultDuration, "signer-default-duration", 365*24*time.Hour,
+		"The lifetime of certificates issued by the built-in signer for CSRs that do not request one.")
+	flag.StringVar(&approvableSignerNames, "approvable-signer-names", "",
+		"Comma-separated list of signers that the operator approves and denies the CSRs of kubeconfigs for. "+
+			"Defaults to --signer-name. Each signer requires the approve verb in the operator's ClusterRole.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -68,6 +113,60 @@ func main() {
 	// ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 	ctrl.SetLogger(klog.NewKlogr())
 
+	if maxCertificateDuration != 0 && minCertificateDuration > maxCertificateDuration {
+		klog.ErrorS(nil, "invalid value for --min-certificate-duration, must not exceed --max-certificate-duration",
+			"value", minCertificateDuration, "max", maxCertificateDuration)
+		os.Exit(1)
+	}
+	if approvalQuorum < 0 {
+		klog.ErrorS(nil, "invalid value for --approval-quorum, must not be negative", "value", approvalQuorum)
+		os.Exit(1)
+	}
+
+	statusMode := controllers.KubeconfigStatusMode(kubeconfigStatus)
+	if !statusMode.IsValid() {
+		klog.ErrorS(nil, "invalid value for --kubeconfig-status", "value", kubeconfigStatus)
+		os.Exit(1)
+	}
+
+	var signerCASecretName types.NamespacedName
+	if signerCASecret != "" {
+		namespace, name, ok := strings.Cut(signerCASecret, "/")
+		if !ok || namespace == "" || name == "" {
+			klog.ErrorS(nil, "invalid value for --signer-ca-secret, must be namespace/name", "value", signerCASecret)
+			os.Exit(1)
+		}
+		if signerName == certificatesv1.KubeAPIServerClientSignerName {
+			// never race the kube-controller-manager for the CSRs of its own signer
+			klog.ErrorS(nil, "the built-in signer requires a custom --signer-name", "signerName", signerName)
+			os.Exit(1)
+		}
+		signerCASecretName = types.NamespacedName{Namespace: namespace, Name: name}
+	}
+	if signerDefaultDuration <= 0 {
+		klog.ErrorS(nil, "invalid value for --signer-default-duration, must be positive", "value", signerDefaultDuration)
+		os.Exit(1)
+	}
+
+	var approvableSigners []string
+	for _, name := range strings.Split(approvableSignerNames, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			approvableSigners = append(approvableSigners, name)
+		}
+	}
+	if len(approvableSigners) == 0 {
+		approvableSigners = []string{signerName}
+	}
+
+	approval := controllers.ApprovalOptions{
+		Quorum: approvalQuorum,
+	}
+	for _, role := range strings.Split(privilegedClusterRoles, ",") {
+		if role = strings.TrimSpace(role); role != "" {
+			approval.PrivilegedClusterRoles = append(approval.PrivilegedClusterRoles, role)
+		}
+	}
+
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
 		Scheme:                 scheme,
 		MetricsBindAddress:     metricsAddr,
@@ -95,28 +194,76 @@ func main() {
 		os.Exit(1)
 	}
 
+	clientSet := kubernetes.NewForConfigOrDie(mgr.GetConfig())
+
 	if err = (&controllers.KubeconfigReconciler{
-		Client:   mgr.GetClient(),
-		Scheme:   mgr.GetScheme(),
-		Recorder: mgr.GetEventRecorderFor("kubeconfig-controller"),
+		Client:     mgr.GetClient(),
+		Scheme:     mgr.GetScheme(),
+		Recorder:   mgr.GetEventRecorderFor("kubeconfig-controller"),
+		StatusMode: statusMode,
+		Approval:   approval,
+		Retry: controllers.RetryOptions{
+			MaxRetries: maxRetries,
+			Backoff:    retryBackoff,
+		},
+		ClientSet:  clientSet,
+		SignerName: signerName,
 	}).SetupWithManager(mgr); err != nil {
 		klog.ErrorS(err, "unable to create controller", "controller", "Kubeconfig")
 		os.Exit(1)
 	}
 	if err = (&controllers.CertificateSigningRequestReconciler{
-		Client:    mgr.GetClient(),
-		Scheme:    mgr.GetScheme(),
-		Recorder:  mgr.GetEventRecorderFor("kubeconfig-csr-controller"),
-		ClientSet: kubernetes.NewForConfigOrDie(mgr.GetConfig()),
+		Client:                mgr.GetClient(),
+		Scheme:                mgr.GetScheme(),
+		Recorder:              mgr.GetEventRecorderFor("kubeconfig-csr-controller"),
+		ClientSet:             clientSet,
+		Approval:              approval,
+		SignerName:            signerName,
+		ApprovableSignerNames: approvableSigners,
 	}).SetupWithManager(mgr); err != nil {
 		klog.ErrorS(err, "unable to create controller", "controller", "CertificateSigningRequest")
 		os.Exit(1)
 	}
+	if signerCASecret != "" {
+		if err = (&controllers.CertificateSigningRequestSigner{
+			Client:          mgr.GetClient(),
+			Scheme:          mgr.GetScheme(),
+			Recorder:        mgr.GetEventRecorderFor("kubeconfig-csr-signer"),
+			ClientSet:       clientSet,
+			SignerName:      signerName,
+			CASecret:        signerCASecretName,
+			DefaultDuration: signerDefaultDuration,
+		}).SetupWithManager(mgr); err != nil {
+			klog.ErrorS(err, "unable to create controller", "controller", "CertificateSigningRequestSigner")
+			os.Exit(1)
+		}
+	}
+
+	if err = (&controllers.KubeconfigGroupReconciler{
+		Client:   mgr.GetClient(),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("kubeconfig-group-controller"),
+	}).SetupWithManager(mgr); err != nil {
+		klog.ErrorS(err, "unable to create controller", "controller", "KubeconfigGroup")
+		os.Exit(1)
+	}
 
-	if err = (&kubeconfigv1alpha1.Kubeconfig{}).SetupWebhookWithManager(mgr); err != nil {
+	if err = (&kubeconfigv1alpha1.Kubeconfig{}).SetupWebhookWithManager(mgr, kubeconfigv1alpha1.WebhookOptions{
+		MinCertificateDuration: minCertificateDuration,
+		MaxCertificateDuration: maxCertificateDuration,
+		SignerName:             signerName,
+	}); err != nil {
 		klog.ErrorS(err, "unable to create webhook", "webhook", "Kubeconfig")
 		os.Exit(1)
 	}
+	if err = (&kubeconfigv1alpha1.KubeconfigApproval{}).SetupWebhookWithManager(mgr); err != nil {
+		klog.ErrorS(err, "unable to create webhook", "webhook", "KubeconfigApproval")
+		os.Exit(1)
+	}
+	if err = (&kubeconfigv1alpha1.KubeconfigGroup{}).SetupWebhookWithManager(mgr); err != nil {
+		klog.ErrorS(err, "unable to create webhook", "webhook", "KubeconfigGroup")
+		os.Exit(1)
+	}
 	//+kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
@@ -128,6 +275,25 @@ func main() {
 		os.Exit(1)
 	}
 
+	// the ClusterRole only grants approve for the signers known at build time, point out any that were added by flag
+	missing, err := controllers.MissingSignerPermissions(context.Background(), clientSet, "approve", approvableSigners)
+	if err != nil {
+		klog.ErrorS(err, "unable to check permissions for approvable signers")
+	} else if len(missing) > 0 {
+		klog.ErrorS(nil, "the operator is not allowed to approve CSRs for some signers, grant the approve verb on them in its ClusterRole", "signers", missing)
+	}
+	if signerCASecret != "" {
+		// the ClusterRole only grants sign for the operator's suggested signer name, without it the built-in signer
+		// could never issue a certificate
+		missing, err := controllers.MissingSignerPermissions(context.Background(), clientSet, "sign", []string{signerName})
+		if err != nil {
+			klog.ErrorS(err, "unable to check permissions for the built-in signer")
+		} else if len(missing) > 0 {
+			klog.ErrorS(nil, "the operator is not allowed to sign CSRs for --signer-name, grant the sign verb on it in its ClusterRole", "signerName", signerName)
+			os.Exit(1)
+		}
+	}
+
 	klog.Info("starting manager")
 	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
 		klog.ErrorS(err, "problem running manager")
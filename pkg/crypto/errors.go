@@ -0,0 +1,61 @@
+/*
+Copyright 2022 zoomoid.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrUnsupportedAlgorithm is returned for signature algorithms that no key type is known for
+	ErrUnsupportedAlgorithm = errors.New("unsupported signature algorithm")
+	// ErrInvalidKeyParameters is returned for key sizes or curves that cannot be used
+	ErrInvalidKeyParameters = errors.New("invalid key parameters")
+	// ErrUnsupportedEncoding is returned for key encodings that cannot represent the private key
+	ErrUnsupportedEncoding = errors.New("unsupported key encoding")
+)
+
+// Op is the operation of a KeyGenerator that failed
+type Op string
+
+const (
+	OpGenerate Op = "generate private key"
+	OpEncode   Op = "encode private key"
+	OpEncrypt  Op = "encrypt private key"
+	OpRequest  Op = "create certificate request"
+)
+
+// Error is returned by the functions of this package and records the operation that failed, and the signature
+// algorithm if it is known
+type Error struct {
+	Op        Op
+	Algorithm x509.SignatureAlgorithm
+	Err       error
+}
+
+func (e *Error) Error() string {
+	if e.Algorithm == x509.UnknownSignatureAlgorithm {
+		return fmt.Sprintf("failed to %s, %v", e.Op, e.Err)
+	}
+	return fmt.Sprintf("failed to %s for %s, %v", e.Op, e.Algorithm, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
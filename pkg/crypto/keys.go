@@ -0,0 +1,202 @@
+/*
+Copyright 2022 zoomoid.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io"
+)
+
+const (
+	// DefaultRSAKeySize is the size of RSA keys in bits if KeyOptions.RSAKeySize is unset
+	DefaultRSAKeySize = 4096
+	// MinRSAKeySize is the smallest RSA key size that is generated
+	MinRSAKeySize = 2048
+)
+
+// KeyType is the type of private key used by a signature algorithm
+type KeyType string
+
+const (
+	KeyTypeUnknown KeyType = ""
+	KeyTypeRSA     KeyType = "RSA"
+	KeyTypeECDSA   KeyType = "ECDSA"
+	KeyTypeEd25519 KeyType = "Ed25519"
+)
+
+// KeyTypeOf returns the type of private key that signs with the signature algorithm
+func KeyTypeOf(algorithm x509.SignatureAlgorithm) KeyType {
+	switch algorithm {
+	case x509.SHA256WithRSA, x509.SHA384WithRSA, x509.SHA512WithRSA,
+		x509.SHA256WithRSAPSS, x509.SHA384WithRSAPSS, x509.SHA512WithRSAPSS:
+		return KeyTypeRSA
+	case x509.ECDSAWithSHA256, x509.ECDSAWithSHA384, x509.ECDSAWithSHA512:
+		return KeyTypeECDSA
+	case x509.PureEd25519:
+		return KeyTypeEd25519
+	default:
+		return KeyTypeUnknown
+	}
+}
+
+// DefaultCurve returns the curve whose strength matches the hash of an ECDSA signature algorithm
+func DefaultCurve(algorithm x509.SignatureAlgorithm) elliptic.Curve {
+	switch algorithm {
+	case x509.ECDSAWithSHA256:
+		return elliptic.P256()
+	case x509.ECDSAWithSHA384:
+		return elliptic.P384()
+	default:
+		return elliptic.P521()
+	}
+}
+
+// KeyOptions are the parameters of a private key
+type KeyOptions struct {
+	// Algorithm is the signature algorithm that the key is used with, which determines the key type
+	Algorithm x509.SignatureAlgorithm
+	// RSAKeySize is the size of RSA keys in bits, defaults to DefaultRSAKeySize
+	RSAKeySize int
+	// Curve is the curve of ECDSA keys, defaults to the curve matching the algorithm's hash
+	Curve elliptic.Curve
+}
+
+// KeyGenerator generates private keys
+type KeyGenerator interface {
+	// GenerateKey returns a new private key for the options' signature algorithm
+	GenerateKey(opts KeyOptions) (crypto.Signer, error)
+}
+
+// DefaultKeyGenerator generates keys from a source of randomness
+type DefaultKeyGenerator struct {
+	// Rand is the source of randomness, defaults to crypto/rand.Reader
+	Rand io.Reader
+}
+
+var _ KeyGenerator = &DefaultKeyGenerator{}
+
+// GenerateKey implements KeyGenerator
+func (g *DefaultKeyGenerator) GenerateKey(opts KeyOptions) (crypto.Signer, error) {
+	random := g.Rand
+	if random == nil {
+		random = rand.Reader
+	}
+	var key crypto.Signer
+	var err error
+	switch KeyTypeOf(opts.Algorithm) {
+	case KeyTypeRSA:
+		bits := opts.RSAKeySize
+		if bits == 0 {
+			bits = DefaultRSAKeySize
+		}
+		if bits < MinRSAKeySize {
+			return nil, &Error{Op: OpGenerate, Algorithm: opts.Algorithm, Err: fmt.Errorf("%w: RSA keys must be at least %d bits, not %d", ErrInvalidKeyParameters, MinRSAKeySize, bits)}
+		}
+		key, err = rsa.GenerateKey(random, bits)
+	case KeyTypeECDSA:
+		curve := opts.Curve
+		if curve == nil {
+			curve = DefaultCurve(opts.Algorithm)
+		}
+		key, err = ecdsa.GenerateKey(curve, random)
+	case KeyTypeEd25519:
+		_, key, err = ed25519.GenerateKey(random)
+	default:
+		return nil, &Error{Op: OpGenerate, Algorithm: opts.Algorithm, Err: ErrUnsupportedAlgorithm}
+	}
+	if err != nil {
+		return nil, &Error{Op: OpGenerate, Algorithm: opts.Algorithm, Err: err}
+	}
+	return key, nil
+}
+
+// KeyEncoding is the format of PEM-encoded private keys
+type KeyEncoding string
+
+const (
+	// KeyEncodingDefault encodes RSA keys as PKCS#1, ECDSA keys as SEC 1 and Ed25519 keys as PKCS#8
+	KeyEncodingDefault KeyEncoding = ""
+	KeyEncodingPKCS1   KeyEncoding = "PKCS1"
+	KeyEncodingSEC1    KeyEncoding = "SEC1"
+	KeyEncodingPKCS8   KeyEncoding = "PKCS8"
+)
+
+// EncodePrivateKey returns the PEM-encoded private key, as PKCS#1 for RSA keys, SEC 1 for ECDSA keys and PKCS#8
+// for Ed25519 keys
+func EncodePrivateKey(key crypto.Signer) ([]byte, error) {
+	return EncodePrivateKeyAs(key, KeyEncodingDefault)
+}
+
+// EncodePrivateKeyAs returns the PEM-encoded private key in the given encoding. PKCS#1 only supports RSA keys and
+// SEC 1 only supports ECDSA keys, while PKCS#8 supports all key types
+func EncodePrivateKeyAs(key crypto.Signer, encoding KeyEncoding) ([]byte, error) {
+	if encoding == KeyEncodingDefault {
+		switch key.(type) {
+		case *rsa.PrivateKey:
+			encoding = KeyEncodingPKCS1
+		case *ecdsa.PrivateKey:
+			encoding = KeyEncodingSEC1
+		default:
+			encoding = KeyEncodingPKCS8
+		}
+	}
+	var block *pem.Block
+	switch encoding {
+	case KeyEncodingPKCS1:
+		k, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			return nil, &Error{Op: OpEncode, Err: fmt.Errorf("%w: %s requires an RSA key, not %T", ErrUnsupportedEncoding, encoding, key)}
+		}
+		block = &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(k)}
+	case KeyEncodingSEC1:
+		k, ok := key.(*ecdsa.PrivateKey)
+		if !ok {
+			return nil, &Error{Op: OpEncode, Err: fmt.Errorf("%w: %s requires an ECDSA key, not %T", ErrUnsupportedEncoding, encoding, key)}
+		}
+		der, err := x509.MarshalECPrivateKey(k)
+		if err != nil {
+			return nil, &Error{Op: OpEncode, Err: err}
+		}
+		block = &pem.Block{Type: "EC PRIVATE KEY", Bytes: der}
+	case KeyEncodingPKCS8:
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return nil, &Error{Op: OpEncode, Err: fmt.Errorf("%w: %v", ErrUnsupportedAlgorithm, err)}
+		}
+		block = &pem.Block{Type: "PRIVATE KEY", Bytes: der}
+	default:
+		return nil, &Error{Op: OpEncode, Err: fmt.Errorf("%w: %s", ErrUnsupportedEncoding, encoding)}
+	}
+	return pem.EncodeToMemory(block), nil
+}
+
+// CreateCertificateRequest signs the template with the key and returns the PEM-encoded certificate request
+func CreateCertificateRequest(template *x509.CertificateRequest, key crypto.Signer) ([]byte, error) {
+	der, err := x509.CreateCertificateRequest(rand.Reader, template, key)
+	if err != nil {
+		return nil, &Error{Op: OpRequest, Algorithm: template.SignatureAlgorithm, Err: err}
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}), nil
+}
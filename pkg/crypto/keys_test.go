@@ -0,0 +1,155 @@
+/*
+Copyright 2022 zoomoid.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"testing"
+)
+
+// failingReader is a source of randomness that is exhausted
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) {
+	return 0, errors.New("entropy exhausted")
+}
+
+func TestGenerateKey(t *testing.T) {
+	tests := []struct {
+		name      string
+		opts      KeyOptions
+		wantType  KeyType
+		wantBits  int
+		wantCurve elliptic.Curve
+		wantErr   error
+	}{
+		{name: "SHA256WithRSA", opts: KeyOptions{Algorithm: x509.SHA256WithRSA, RSAKeySize: 2048}, wantType: KeyTypeRSA, wantBits: 2048},
+		{name: "SHA384WithRSA", opts: KeyOptions{Algorithm: x509.SHA384WithRSA, RSAKeySize: 3072}, wantType: KeyTypeRSA, wantBits: 3072},
+		{name: "SHA512WithRSA", opts: KeyOptions{Algorithm: x509.SHA512WithRSA, RSAKeySize: 2048}, wantType: KeyTypeRSA, wantBits: 2048},
+		{name: "SHA256WithRSAPSS", opts: KeyOptions{Algorithm: x509.SHA256WithRSAPSS, RSAKeySize: 2048}, wantType: KeyTypeRSA, wantBits: 2048},
+		{name: "SHA384WithRSAPSS", opts: KeyOptions{Algorithm: x509.SHA384WithRSAPSS, RSAKeySize: 2048}, wantType: KeyTypeRSA, wantBits: 2048},
+		{name: "SHA512WithRSAPSS", opts: KeyOptions{Algorithm: x509.SHA512WithRSAPSS, RSAKeySize: 2048}, wantType: KeyTypeRSA, wantBits: 2048},
+		{name: "ECDSAWithSHA256", opts: KeyOptions{Algorithm: x509.ECDSAWithSHA256}, wantType: KeyTypeECDSA, wantCurve: elliptic.P256()},
+		{name: "ECDSAWithSHA384", opts: KeyOptions{Algorithm: x509.ECDSAWithSHA384}, wantType: KeyTypeECDSA, wantCurve: elliptic.P384()},
+		{name: "ECDSAWithSHA512", opts: KeyOptions{Algorithm: x509.ECDSAWithSHA512}, wantType: KeyTypeECDSA, wantCurve: elliptic.P521()},
+		{name: "ECDSAWithSHA512 with explicit curve", opts: KeyOptions{Algorithm: x509.ECDSAWithSHA512, Curve: elliptic.P256()}, wantType: KeyTypeECDSA, wantCurve: elliptic.P256()},
+		{name: "PureEd25519", opts: KeyOptions{Algorithm: x509.PureEd25519}, wantType: KeyTypeEd25519},
+		{name: "UnknownSignatureAlgorithm", opts: KeyOptions{Algorithm: x509.UnknownSignatureAlgorithm}, wantErr: ErrUnsupportedAlgorithm},
+		{name: "MD5WithRSA", opts: KeyOptions{Algorithm: x509.MD5WithRSA}, wantErr: ErrUnsupportedAlgorithm},
+		{name: "RSA key too small", opts: KeyOptions{Algorithm: x509.SHA256WithRSA, RSAKeySize: 1024}, wantErr: ErrInvalidKeyParameters},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := (&DefaultKeyGenerator{}).GenerateKey(tt.opts)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("GenerateKey() error = %v, want %v", err, tt.wantErr)
+				}
+				var keyErr *Error
+				if !errors.As(err, &keyErr) || keyErr.Op != OpGenerate {
+					t.Fatalf("GenerateKey() error = %v, want *Error with Op %q", err, OpGenerate)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("GenerateKey() unexpected error = %v", err)
+			}
+			if got := KeyTypeOf(tt.opts.Algorithm); got != tt.wantType {
+				t.Errorf("KeyTypeOf() = %q, want %q", got, tt.wantType)
+			}
+			switch k := key.(type) {
+			case *rsa.PrivateKey:
+				if tt.wantType != KeyTypeRSA || k.N.BitLen() != tt.wantBits {
+					t.Errorf("GenerateKey() = RSA-%d, want %s with %d bits", k.N.BitLen(), tt.wantType, tt.wantBits)
+				}
+			case *ecdsa.PrivateKey:
+				if tt.wantType != KeyTypeECDSA || k.Curve != tt.wantCurve {
+					t.Errorf("GenerateKey() = ECDSA-%s, want %s with curve %s", k.Curve.Params().Name, tt.wantType, tt.wantCurve.Params().Name)
+				}
+			case ed25519.PrivateKey:
+				if tt.wantType != KeyTypeEd25519 {
+					t.Errorf("GenerateKey() = Ed25519, want %s", tt.wantType)
+				}
+			default:
+				t.Fatalf("GenerateKey() returned unexpected key type %T", key)
+			}
+
+			keyPEM, err := EncodePrivateKey(key)
+			if err != nil {
+				t.Fatalf("EncodePrivateKey() unexpected error = %v", err)
+			}
+			if block, _ := pem.Decode(keyPEM); block == nil {
+				t.Fatalf("EncodePrivateKey() returned no PEM block")
+			}
+
+			csrPEM, err := CreateCertificateRequest(&x509.CertificateRequest{
+				Subject:            pkix.Name{CommonName: "alice"},
+				SignatureAlgorithm: tt.opts.Algorithm,
+			}, key)
+			if err != nil {
+				t.Fatalf("CreateCertificateRequest() unexpected error = %v", err)
+			}
+			block, _ := pem.Decode(csrPEM)
+			if block == nil {
+				t.Fatalf("CreateCertificateRequest() returned no PEM block")
+			}
+			request, err := x509.ParseCertificateRequest(block.Bytes)
+			if err != nil {
+				t.Fatalf("failed to parse certificate request, %v", err)
+			}
+			if err := request.CheckSignature(); err != nil {
+				t.Errorf("certificate request has an invalid signature, %v", err)
+			}
+			if request.SignatureAlgorithm != tt.opts.Algorithm {
+				t.Errorf("certificate request is signed with %s, want %s", request.SignatureAlgorithm, tt.opts.Algorithm)
+			}
+		})
+	}
+}
+
+func TestGenerateKeyPropagatesRandomnessErrors(t *testing.T) {
+	// the standard library generates RSA and ECDSA keys from its own source of randomness, only Ed25519 keys are
+	// derived from the given reader
+	_, err := (&DefaultKeyGenerator{Rand: failingReader{}}).GenerateKey(KeyOptions{Algorithm: x509.PureEd25519})
+	var keyErr *Error
+	if !errors.As(err, &keyErr) {
+		t.Fatalf("GenerateKey() error = %v, want *Error", err)
+	}
+	if keyErr.Op != OpGenerate || keyErr.Algorithm != x509.PureEd25519 {
+		t.Errorf("GenerateKey() error = %+v, want Op %q and Algorithm %s", keyErr, OpGenerate, x509.PureEd25519)
+	}
+}
+
+func TestCreateCertificateRequestErrors(t *testing.T) {
+	key, err := (&DefaultKeyGenerator{}).GenerateKey(KeyOptions{Algorithm: x509.ECDSAWithSHA256})
+	if err != nil {
+		t.Fatalf("GenerateKey() unexpected error = %v", err)
+	}
+	// an ECDSA key cannot sign with an RSA signature algorithm
+	_, err = CreateCertificateRequest(&x509.CertificateRequest{SignatureAlgorithm: x509.SHA256WithRSA}, key)
+	var keyErr *Error
+	if !errors.As(err, &keyErr) || keyErr.Op != OpRequest {
+		t.Fatalf("CreateCertificateRequest() error = %v, want *Error with Op %q", err, OpRequest)
+	}
+}
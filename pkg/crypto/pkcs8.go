@@ -0,0 +1,123 @@
+/*
+Copyright 2022 zoomoid.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+)
+
+// PBKDF2Iterations is the iteration count of the key derivation for encrypted private keys
+const PBKDF2Iterations = 600000
+
+var (
+	oidPBES2          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2         = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidHMACWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidAES256CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+)
+
+// encryptedPrivateKeyInfo is the EncryptedPrivateKeyInfo structure of RFC 5208
+type encryptedPrivateKeyInfo struct {
+	EncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedData       []byte
+}
+
+// pbes2Params are the PBES2-params of RFC 8018
+type pbes2Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+// pbkdf2Params are the PBKDF2-params of RFC 8018
+type pbkdf2Params struct {
+	Salt           []byte
+	IterationCount int
+	PRF            pkix.AlgorithmIdentifier
+}
+
+// EncryptPrivateKey returns the PEM-encoded private key as an encrypted PKCS#8 key, i.e., PBES2 with
+// PBKDF2-HMAC-SHA256 and AES-256-CBC, which OpenSSL and Java's key stores can read
+func EncryptPrivateKey(key crypto.Signer, passphrase []byte) ([]byte, error) {
+	if len(passphrase) == 0 {
+		return nil, &Error{Op: OpEncrypt, Err: errors.New("passphrase must not be empty")}
+	}
+	plaintext, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, &Error{Op: OpEncrypt, Err: err}
+	}
+
+	salt := make([]byte, 16)
+	iv := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, &Error{Op: OpEncrypt, Err: err}
+	}
+	if _, err := rand.Read(iv); err != nil {
+		return nil, &Error{Op: OpEncrypt, Err: err}
+	}
+	derived, err := pbkdf2.Key(sha256.New, string(passphrase), salt, PBKDF2Iterations, 32)
+	if err != nil {
+		return nil, &Error{Op: OpEncrypt, Err: err}
+	}
+	block, err := aes.NewCipher(derived)
+	if err != nil {
+		return nil, &Error{Op: OpEncrypt, Err: err}
+	}
+	// PKCS#7 padding, which always adds at least one byte
+	padding := aes.BlockSize - len(plaintext)%aes.BlockSize
+	plaintext = append(plaintext, bytes.Repeat([]byte{byte(padding)}, padding)...)
+	ciphertext := make([]byte, len(plaintext))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, plaintext)
+
+	kdfParams, err := asn1.Marshal(pbkdf2Params{
+		Salt:           salt,
+		IterationCount: PBKDF2Iterations,
+		PRF:            pkix.AlgorithmIdentifier{Algorithm: oidHMACWithSHA256, Parameters: asn1.NullRawValue},
+	})
+	if err != nil {
+		return nil, &Error{Op: OpEncrypt, Err: err}
+	}
+	ivParams, err := asn1.Marshal(iv)
+	if err != nil {
+		return nil, &Error{Op: OpEncrypt, Err: err}
+	}
+	schemeParams, err := asn1.Marshal(pbes2Params{
+		KeyDerivationFunc: pkix.AlgorithmIdentifier{Algorithm: oidPBKDF2, Parameters: asn1.RawValue{FullBytes: kdfParams}},
+		EncryptionScheme:  pkix.AlgorithmIdentifier{Algorithm: oidAES256CBC, Parameters: asn1.RawValue{FullBytes: ivParams}},
+	})
+	if err != nil {
+		return nil, &Error{Op: OpEncrypt, Err: err}
+	}
+	der, err := asn1.Marshal(encryptedPrivateKeyInfo{
+		EncryptionAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidPBES2, Parameters: asn1.RawValue{FullBytes: schemeParams}},
+		EncryptedData:       ciphertext,
+	})
+	if err != nil {
+		return nil, &Error{Op: OpEncrypt, Err: err}
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "ENCRYPTED PRIVATE KEY", Bytes: der}), nil
+}
@@ -0,0 +1,180 @@
+/*
+Copyright 2022 zoomoid.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// decryptPrivateKey reverses EncryptPrivateKey and returns the DER-encoded PKCS#8 private key
+func decryptPrivateKey(t *testing.T, pemBytes []byte, passphrase []byte) []byte {
+	t.Helper()
+	block, _ := pem.Decode(pemBytes)
+	if block == nil || block.Type != "ENCRYPTED PRIVATE KEY" {
+		t.Fatalf("no ENCRYPTED PRIVATE KEY PEM block found")
+	}
+	var info encryptedPrivateKeyInfo
+	if _, err := asn1.Unmarshal(block.Bytes, &info); err != nil {
+		t.Fatalf("failed to parse EncryptedPrivateKeyInfo, %v", err)
+	}
+	if !info.EncryptionAlgorithm.Algorithm.Equal(oidPBES2) {
+		t.Fatalf("encryption algorithm is %v, want PBES2", info.EncryptionAlgorithm.Algorithm)
+	}
+	var scheme pbes2Params
+	if _, err := asn1.Unmarshal(info.EncryptionAlgorithm.Parameters.FullBytes, &scheme); err != nil {
+		t.Fatalf("failed to parse PBES2 parameters, %v", err)
+	}
+	var kdf pbkdf2Params
+	if _, err := asn1.Unmarshal(scheme.KeyDerivationFunc.Parameters.FullBytes, &kdf); err != nil {
+		t.Fatalf("failed to parse PBKDF2 parameters, %v", err)
+	}
+	var iv []byte
+	if _, err := asn1.Unmarshal(scheme.EncryptionScheme.Parameters.FullBytes, &iv); err != nil {
+		t.Fatalf("failed to parse IV, %v", err)
+	}
+	derived, err := pbkdf2.Key(sha256.New, string(passphrase), kdf.Salt, kdf.IterationCount, 32)
+	if err != nil {
+		t.Fatalf("failed to derive key, %v", err)
+	}
+	aesBlock, err := aes.NewCipher(derived)
+	if err != nil {
+		t.Fatalf("failed to create cipher, %v", err)
+	}
+	plaintext := make([]byte, len(info.EncryptedData))
+	cipher.NewCBCDecrypter(aesBlock, iv).CryptBlocks(plaintext, info.EncryptedData)
+	padding := int(plaintext[len(plaintext)-1])
+	if padding == 0 || padding > aes.BlockSize {
+		return nil
+	}
+	return plaintext[:len(plaintext)-padding]
+}
+
+func TestEncodePrivateKeyAs(t *testing.T) {
+	tests := []struct {
+		name      string
+		algorithm x509.SignatureAlgorithm
+		encoding  KeyEncoding
+		wantType  string
+		wantErr   error
+	}{
+		{name: "RSA default", algorithm: x509.SHA256WithRSA, encoding: KeyEncodingDefault, wantType: "RSA PRIVATE KEY"},
+		{name: "RSA PKCS1", algorithm: x509.SHA256WithRSA, encoding: KeyEncodingPKCS1, wantType: "RSA PRIVATE KEY"},
+		{name: "RSA PKCS8", algorithm: x509.SHA256WithRSA, encoding: KeyEncodingPKCS8, wantType: "PRIVATE KEY"},
+		{name: "RSA SEC1", algorithm: x509.SHA256WithRSA, encoding: KeyEncodingSEC1, wantErr: ErrUnsupportedEncoding},
+		{name: "ECDSA default", algorithm: x509.ECDSAWithSHA256, encoding: KeyEncodingDefault, wantType: "EC PRIVATE KEY"},
+		{name: "ECDSA SEC1", algorithm: x509.ECDSAWithSHA256, encoding: KeyEncodingSEC1, wantType: "EC PRIVATE KEY"},
+		{name: "ECDSA PKCS8", algorithm: x509.ECDSAWithSHA256, encoding: KeyEncodingPKCS8, wantType: "PRIVATE KEY"},
+		{name: "ECDSA PKCS1", algorithm: x509.ECDSAWithSHA256, encoding: KeyEncodingPKCS1, wantErr: ErrUnsupportedEncoding},
+		{name: "Ed25519 default", algorithm: x509.PureEd25519, encoding: KeyEncodingDefault, wantType: "PRIVATE KEY"},
+		{name: "Ed25519 PKCS8", algorithm: x509.PureEd25519, encoding: KeyEncodingPKCS8, wantType: "PRIVATE KEY"},
+		{name: "Ed25519 SEC1", algorithm: x509.PureEd25519, encoding: KeyEncodingSEC1, wantErr: ErrUnsupportedEncoding},
+		{name: "unknown encoding", algorithm: x509.PureEd25519, encoding: "PEM", wantErr: ErrUnsupportedEncoding},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := (&DefaultKeyGenerator{}).GenerateKey(KeyOptions{Algorithm: tt.algorithm, RSAKeySize: 2048})
+			if err != nil {
+				t.Fatalf("GenerateKey() unexpected error = %v", err)
+			}
+			keyPEM, err := EncodePrivateKeyAs(key, tt.encoding)
+			if tt.wantErr != nil {
+				var keyErr *Error
+				if !errors.Is(err, tt.wantErr) || !errors.As(err, &keyErr) || keyErr.Op != OpEncode {
+					t.Fatalf("EncodePrivateKeyAs() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("EncodePrivateKeyAs() unexpected error = %v", err)
+			}
+			block, _ := pem.Decode(keyPEM)
+			if block == nil || block.Type != tt.wantType {
+				t.Fatalf("EncodePrivateKeyAs() returned PEM block %v, want %s", block, tt.wantType)
+			}
+			var parsed interface{}
+			switch block.Type {
+			case "RSA PRIVATE KEY":
+				parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+			case "EC PRIVATE KEY":
+				parsed, err = x509.ParseECPrivateKey(block.Bytes)
+			default:
+				parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+			}
+			if err != nil {
+				t.Fatalf("failed to parse encoded key, %v", err)
+			}
+			if !reflect.DeepEqual(parsed, key) {
+				t.Errorf("encoded key does not match the generated key")
+			}
+		})
+	}
+}
+
+func TestEncryptPrivateKey(t *testing.T) {
+	tests := []x509.SignatureAlgorithm{
+		x509.SHA256WithRSA,
+		x509.ECDSAWithSHA384,
+		x509.PureEd25519,
+	}
+	passphrase := []byte("correct horse battery staple")
+	for _, algorithm := range tests {
+		t.Run(algorithm.String(), func(t *testing.T) {
+			key, err := (&DefaultKeyGenerator{}).GenerateKey(KeyOptions{Algorithm: algorithm, RSAKeySize: 2048})
+			if err != nil {
+				t.Fatalf("GenerateKey() unexpected error = %v", err)
+			}
+			encrypted, err := EncryptPrivateKey(key, passphrase)
+			if err != nil {
+				t.Fatalf("EncryptPrivateKey() unexpected error = %v", err)
+			}
+			der := decryptPrivateKey(t, encrypted, passphrase)
+			parsed, err := x509.ParsePKCS8PrivateKey(der)
+			if err != nil {
+				t.Fatalf("failed to parse decrypted key, %v", err)
+			}
+			if !reflect.DeepEqual(parsed, key) {
+				t.Errorf("decrypted key does not match the generated key")
+			}
+			if der := decryptPrivateKey(t, encrypted, []byte("wrong")); der != nil {
+				if _, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+					t.Errorf("decrypted key with the wrong passphrase")
+				}
+			}
+		})
+	}
+}
+
+func TestEncryptPrivateKeyRequiresPassphrase(t *testing.T) {
+	key, err := (&DefaultKeyGenerator{}).GenerateKey(KeyOptions{Algorithm: x509.PureEd25519})
+	if err != nil {
+		t.Fatalf("GenerateKey() unexpected error = %v", err)
+	}
+	_, err = EncryptPrivateKey(key, nil)
+	var keyErr *Error
+	if !errors.As(err, &keyErr) || keyErr.Op != OpEncrypt {
+		t.Fatalf("EncryptPrivateKey() error = %v, want *Error with Op %q", err, OpEncrypt)
+	}
+}
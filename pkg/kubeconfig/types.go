@@ -68,8 +68,18 @@ type Context struct {
 }
 
 type User struct {
-	ClientCertificate string `json:"client-certificate-data"`
-	ClientKey         string `json:"client-key-data"`
+	ClientCertificate string `json:"client-certificate-data,omitempty"`
+	ClientKey         string `json:"client-key-data,omitempty"`
+	Token             string `json:"token,omitempty"`
+	Exec              *Exec  `json:"exec,omitempty"`
+}
+
+// Exec configures a credential plugin that kubectl and client-go run for obtaining the user's credentials
+type Exec struct {
+	APIVersion      string   `json:"apiVersion"`
+	Command         string   `json:"command"`
+	Args            []string `json:"args,omitempty"`
+	InteractiveMode string   `json:"interactiveMode,omitempty"`
 }
 
 func (c *Config) Marshal() []byte {
@@ -142,6 +152,20 @@ func Unmarshal(config []byte) (*Config, error) {
 	return cfg, nil
 }
 
+// Redacted returns a copy of the config with all user credentials that must be kept secret removed, i.e.,
+// client keys and tokens. Redacted configs can be published where private keys must not be exposed, and are
+// completed by the user locally
+func (c *Config) Redacted() *Config {
+	redacted := *c
+	redacted.Users = make(map[string]User, len(c.Users))
+	for name, user := range c.Users {
+		user.ClientKey = ""
+		user.Token = ""
+		redacted.Users[name] = user
+	}
+	return &redacted
+}
+
 func NewBareConfig() *Config {
 	return &Config{
 		ObjectMeta: ObjectMeta{